@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"flag"
 	v1 "github.com/StellrisJAY/workflow-ai/internal/api/v1"
+	"github.com/StellrisJAY/workflow-ai/internal/common"
 	"github.com/StellrisJAY/workflow-ai/internal/config"
 	"github.com/StellrisJAY/workflow-ai/internal/rag"
 	"github.com/StellrisJAY/workflow-ai/internal/repo"
@@ -11,6 +13,7 @@ import (
 	"github.com/StellrisJAY/workflow-ai/internal/service"
 	"github.com/StellrisJAY/workflow-ai/internal/workflow"
 	"github.com/bwmarrin/snowflake"
+	"log"
 	"strconv"
 )
 
@@ -43,6 +46,9 @@ func main() {
 	store := fs.NewFileStore(conf)
 	llmRepo := repo.NewProviderRepo(repository)
 	templateRepo := repo.NewTemplateRepo(repository)
+	scheduleRepo := repo.NewScheduleRepo(repository)
+	webhookRepo := repo.NewWebhookRepo(repository)
+	secretRepo := repo.NewSecretRepo(repository)
 	instanceRepo := repo.NewInstanceRepo(repository)
 	kbRepo := repo.NewKnowledgeBaseRepo(repository)
 	fileRepo := repo.NewFileRepo(repository)
@@ -50,21 +56,42 @@ func main() {
 	tm := repo.NewTransactionManager(repository)
 	vectorstoreFactory := vector.MakeFactory(*conf)
 	documentProcessor := rag.NewDocumentProcessor(8, kbRepo, store, llmRepo, vectorstoreFactory)
-	engine := workflow.NewEngine(instanceRepo, llmRepo, snowflakeNode, tm, kbRepo, documentProcessor, conf, fileRepo, store)
+	secretCipher, err := common.NewSecretCipher(conf.SecretKey)
+	if err != nil {
+		panic(err)
+	}
+	if !secretCipher.Enabled() {
+		log.Println("secretKey未配置，密钥功能已禁用，只能使用环境变量")
+	}
+	if conf.Workflow.CallbackSecret == "" {
+		log.Println("workflow.callbackSecret未配置，流程结束回调已禁用")
+	}
+	engine := workflow.NewEngine(instanceRepo, llmRepo, snowflakeNode, tm, kbRepo, documentProcessor, conf, fileRepo, store,
+		templateRepo, secretRepo, secretCipher)
+	// 恢复服务重启前运行中的流程实例
+	if err := engine.Recover(context.Background()); err != nil {
+		panic(err)
+	}
+	workflow.NewCronRunner(engine, scheduleRepo)
 
-	templateService := service.NewTemplateService(templateRepo, snowflakeNode)
+	templateService := service.NewTemplateService(templateRepo, scheduleRepo, webhookRepo, secretRepo, snowflakeNode)
 	workflowService := service.NewWorkflowService(templateRepo, engine, instanceRepo)
 	kbService := service.NewKnowledgeBaseService(kbRepo, snowflakeNode, tm, store, documentProcessor, vectorstoreFactory)
 	fileService := service.NewFileService(fileRepo, store, tm, snowflakeNode)
 	providerService := service.NewProviderService(providerRepo, snowflakeNode)
+	webhookService := service.NewWebhookService(webhookRepo, templateRepo, instanceRepo, engine, secretCipher, snowflakeNode)
+	secretService := service.NewSecretService(secretRepo, templateRepo, secretCipher, snowflakeNode)
 
 	templateHandler := v1.NewTemplateHandler(templateService)
 	workflowHandler := v1.NewWorkflowHandler(workflowService)
 	kbHandler := v1.NewKnowledgeBaseHandler(kbService)
 	fileHandler := v1.NewFSHandler(fileService)
 	providerHandler := v1.NewProviderHandler(providerService)
+	webhookHandler := v1.NewWebhookHandler(webhookService)
+	secretHandler := v1.NewSecretHandler(secretService)
 
-	if err := router.Init(templateHandler, workflowHandler, kbHandler, fileHandler, providerHandler); err != nil {
+	if err := router.Init(templateHandler, workflowHandler, kbHandler, fileHandler, providerHandler, webhookHandler,
+		secretHandler); err != nil {
 		panic(err)
 	}
 	if err = router.Start(); err != nil {
@@ -53,6 +53,17 @@ func (p *ProviderHandler) ListProviderModel(c *gin.Context) {
 	c.JSON(200, common.NewSuccessResponseWithTotal(list, total))
 }
 
+func (p *ProviderHandler) UpdateModelPrice(c *gin.Context) {
+	var request model.UpdateModelPriceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		panic(err)
+	}
+	if err := p.service.UpdateModelPrice(c, &request); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
+
 func (p *ProviderHandler) ListProviders(c *gin.Context) {
 	list, err := p.service.ListProviders(c)
 	if err != nil {
@@ -19,7 +19,8 @@ func NewRouter(conf *config.Config) *Router {
 }
 
 func (r *Router) Init(templateHandler *TemplateHandler, workflowHandler *WorkflowHandler, kbHandler *KnowledgeBaseHandler,
-	fileHandler *FSHandler, providerHandler *ProviderHandler) error {
+	fileHandler *FSHandler, providerHandler *ProviderHandler, webhookHandler *WebhookHandler,
+	secretHandler *SecretHandler) error {
 	r.e.Use(middleware.Recovery)
 	r.e.Use(cors.New(cors.Config{
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
@@ -32,12 +33,14 @@ func (r *Router) Init(templateHandler *TemplateHandler, workflowHandler *Workflo
 		v1.GET("/ping", func(c *gin.Context) {
 			c.JSON(200, "pong")
 		})
+		v1.POST("/hooks/:token", webhookHandler.Trigger)
 		provider := v1.Group("/provider")
 		{
 			provider.GET("/schemas", providerHandler.ListProviderSchemas)
 			provider.POST("/create", providerHandler.CreateProvider)
 			provider.POST("/model/create", providerHandler.CreateProviderModel)
 			provider.GET("/model/list", providerHandler.ListProviderModel)
+			provider.PUT("/model/price", providerHandler.UpdateModelPrice)
 			provider.GET("/list", providerHandler.ListProviders)
 		}
 		template := v1.Group("/template")
@@ -45,10 +48,19 @@ func (r *Router) Init(templateHandler *TemplateHandler, workflowHandler *Workflo
 			template.POST("/create", templateHandler.Create)
 			template.DELETE("/:id", templateHandler.Delete)
 			template.PUT("/update", templateHandler.Update)
+			template.POST("/validate", templateHandler.Validate)
 			template.GET("/detail/:id", templateHandler.GetDetail)
 			template.GET("/list", templateHandler.List)
 			template.GET("/start-variables/:id", templateHandler.GetStartInputVariables)
 			template.GET("/prototype", templateHandler.GetNodePrototype)
+			template.GET("/:id/schedules", templateHandler.ListSchedules)
+			template.POST("/:id/schedules", templateHandler.CreateSchedule)
+			template.PUT("/:id/schedules/:scheduleId", templateHandler.UpdateSchedule)
+			template.DELETE("/:id/schedules/:scheduleId", templateHandler.DeleteSchedule)
+			template.GET("/:id/webhooks", webhookHandler.List)
+			template.POST("/:id/webhooks", webhookHandler.Create)
+			template.PUT("/:id/webhooks/:webhookId", webhookHandler.Update)
+			template.DELETE("/:id/webhooks/:webhookId", webhookHandler.Delete)
 		}
 		wf := v1.Group("/workflow")
 		{
@@ -57,6 +69,21 @@ func (r *Router) Init(templateHandler *TemplateHandler, workflowHandler *Workflo
 			wf.GET("/list", workflowHandler.List)
 			wf.GET("/node/detail", workflowHandler.GetNodeInstanceDetail)
 			wf.POST("/start-and-listen", workflowHandler.StartAndListen)
+			wf.POST("/debug-node", workflowHandler.DebugNode)
+			wf.GET("/events/:id", workflowHandler.Events)
+			wf.GET("/callbacks/:id", workflowHandler.ListCallbackDeliveries)
+			wf.POST("/cancel/:id", workflowHandler.Cancel)
+			wf.POST("/retry/:id", workflowHandler.Retry)
+			wf.GET("/pending-tasks", workflowHandler.ListPendingTasks)
+			wf.POST("/task/:id/complete", workflowHandler.CompleteTask)
+			wf.GET("/usage/statistics", workflowHandler.UsageStatistics)
+		}
+		secret := v1.Group("/secret")
+		{
+			secret.GET("/list", secretHandler.List)
+			secret.POST("/create", secretHandler.Create)
+			secret.PUT("/:id", secretHandler.Update)
+			secret.DELETE("/:id", secretHandler.Delete)
 		}
 		kb := v1.Group("/knowledgeBase")
 		{
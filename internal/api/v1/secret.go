@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"github.com/StellrisJAY/workflow-ai/internal/common"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/StellrisJAY/workflow-ai/internal/service"
+	"github.com/gin-gonic/gin"
+	"strconv"
+)
+
+type SecretHandler struct {
+	service *service.SecretService
+}
+
+func NewSecretHandler(service *service.SecretService) *SecretHandler {
+	return &SecretHandler{service: service}
+}
+
+// List 查询环境变量和密钥，不传templateId时查询全局变量
+func (s *SecretHandler) List(c *gin.Context) {
+	var templateId int64
+	if id := c.Query("templateId"); id != "" {
+		var err error
+		if templateId, err = strconv.ParseInt(id, 10, 64); err != nil {
+			panic(err)
+		}
+	}
+	list, err := s.service.List(c, templateId)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(list))
+}
+
+func (s *SecretHandler) Create(c *gin.Context) {
+	var request model.SecretRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		panic(err)
+	}
+	id, err := s.service.Create(c, &request)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(struct {
+		Id int64 `json:"id,string"`
+	}{id}))
+}
+
+func (s *SecretHandler) Update(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	var request model.SecretRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		panic(err)
+	}
+	if err := s.service.Update(c, id, &request); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
+
+func (s *SecretHandler) Delete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if err := s.service.Delete(c, id); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
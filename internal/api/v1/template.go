@@ -1,9 +1,11 @@
 package v1
 
 import (
+	"errors"
 	"github.com/StellrisJAY/workflow-ai/internal/common"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"github.com/StellrisJAY/workflow-ai/internal/service"
+	"github.com/StellrisJAY/workflow-ai/internal/workflow"
 	"github.com/gin-gonic/gin"
 	"strconv"
 )
@@ -23,6 +25,9 @@ func (t *TemplateHandler) Create(c *gin.Context) {
 	}
 	id, err := t.service.Insert(c, &template)
 	if err != nil {
+		if renderValidationError(c, err) {
+			return
+		}
 		panic(err)
 	}
 	c.JSON(200, common.NewSuccessResponse(struct {
@@ -73,11 +78,32 @@ func (t *TemplateHandler) Update(c *gin.Context) {
 		panic(err)
 	}
 	if err := t.service.Update(c, &template); err != nil {
+		if renderValidationError(c, err) {
+			return
+		}
 		panic(err)
 	}
 	c.JSON(200, common.NewSuccessResponse(nil))
 }
 
+func (t *TemplateHandler) Validate(c *gin.Context) {
+	var request model.ValidateDefinitionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(t.service.Validate(c, &request)))
+}
+
+// renderValidationError 流程定义校验失败时在响应中返回诊断信息
+func renderValidationError(c *gin.Context, err error) bool {
+	var validationErr *workflow.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+	c.JSON(200, common.NewErrorResponseWithData(err.Error(), validationErr.Diagnostics))
+	return true
+}
+
 func (t *TemplateHandler) GetStartInputVariables(c *gin.Context) {
 	param := c.Param("id")
 	id, err := strconv.ParseInt(param, 10, 64)
@@ -91,6 +117,70 @@ func (t *TemplateHandler) GetStartInputVariables(c *gin.Context) {
 	c.JSON(200, common.NewSuccessResponse(variables))
 }
 
+func (t *TemplateHandler) ListSchedules(c *gin.Context) {
+	templateId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	list, err := t.service.ListSchedules(c, templateId)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(list))
+}
+
+func (t *TemplateHandler) CreateSchedule(c *gin.Context) {
+	templateId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	var request model.WorkflowScheduleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		panic(err)
+	}
+	id, err := t.service.CreateSchedule(c, templateId, &request)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(struct {
+		Id int64 `json:"id,string"`
+	}{id}))
+}
+
+func (t *TemplateHandler) UpdateSchedule(c *gin.Context) {
+	templateId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	scheduleId, err := strconv.ParseInt(c.Param("scheduleId"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	var request model.WorkflowScheduleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		panic(err)
+	}
+	if err := t.service.UpdateSchedule(c, templateId, scheduleId, &request); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
+
+func (t *TemplateHandler) DeleteSchedule(c *gin.Context) {
+	templateId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	scheduleId, err := strconv.ParseInt(c.Param("scheduleId"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if err := t.service.DeleteSchedule(c, templateId, scheduleId); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
+
 func (t *TemplateHandler) GetNodePrototype(c *gin.Context) {
 	nodeType := c.Query("nodeType")
 	prototype, err := t.service.GetNodePrototype(c, model.NodeType(nodeType))
@@ -0,0 +1,104 @@
+package v1
+
+import (
+	"errors"
+	"github.com/StellrisJAY/workflow-ai/internal/common"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/StellrisJAY/workflow-ai/internal/service"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type WebhookHandler struct {
+	service *service.WebhookService
+}
+
+func NewWebhookHandler(service *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{service: service}
+}
+
+func (w *WebhookHandler) List(c *gin.Context) {
+	templateId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	list, err := w.service.List(c, templateId)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(list))
+}
+
+func (w *WebhookHandler) Create(c *gin.Context) {
+	templateId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	var request model.WorkflowWebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		panic(err)
+	}
+	webhook, err := w.service.Create(c, templateId, &request)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(webhook))
+}
+
+func (w *WebhookHandler) Update(c *gin.Context) {
+	templateId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	webhookId, err := strconv.ParseInt(c.Param("webhookId"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	var request model.WorkflowWebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		panic(err)
+	}
+	if err := w.service.Update(c, templateId, webhookId, &request); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
+
+func (w *WebhookHandler) Delete(c *gin.Context) {
+	templateId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	webhookId, err := strconv.ParseInt(c.Param("webhookId"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if err := w.service.Delete(c, templateId, webhookId); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
+
+// Trigger 外部系统调用的webhook地址，签名通过X-Signature-256或X-Hub-Signature-256请求头传递
+func (w *WebhookHandler) Trigger(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		panic(err)
+	}
+	signature := c.GetHeader("X-Signature-256")
+	if signature == "" {
+		signature = c.GetHeader("X-Hub-Signature-256")
+	}
+	result, err := w.service.Trigger(c.Request.Context(), c.Param("token"), body, signature)
+	switch {
+	case errors.Is(err, service.ErrWebhookNotFound):
+		c.JSON(http.StatusNotFound, common.NewErrorResponse(err.Error()))
+	case errors.Is(err, service.ErrInvalidSignature):
+		c.JSON(http.StatusUnauthorized, common.NewErrorResponse(err.Error()))
+	case err != nil:
+		panic(err)
+	default:
+		c.JSON(200, common.NewSuccessResponse(result))
+	}
+}
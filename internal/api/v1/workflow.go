@@ -2,12 +2,16 @@ package v1
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/StellrisJAY/workflow-ai/internal/common"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"github.com/StellrisJAY/workflow-ai/internal/service"
+	"github.com/StellrisJAY/workflow-ai/internal/workflow"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 	"io"
 	"log"
+	"net/http"
 	"strconv"
 )
 
@@ -28,6 +32,9 @@ func (w *WorkflowHandler) Start(c *gin.Context) {
 	}
 	workflowId, err := w.service.Start(c, &request)
 	if err != nil {
+		if renderValidationError(c, err) {
+			return
+		}
 		panic(err)
 	}
 	c.JSON(200, common.NewSuccessResponse(struct {
@@ -35,6 +42,104 @@ func (w *WorkflowHandler) Start(c *gin.Context) {
 	}{WorkflowId: workflowId}))
 }
 
+func (w *WorkflowHandler) Cancel(c *gin.Context) {
+	workflowId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	if err := w.service.Cancel(c, workflowId); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
+
+func (w *WorkflowHandler) Retry(c *gin.Context) {
+	workflowId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	// 请求体可以为空，表示按原配置重试
+	var request model.RetryWorkflowRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		panic(err)
+	}
+	if err := w.service.Retry(c, workflowId, &request); err != nil {
+		if renderValidationError(c, err) {
+			return
+		}
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
+
+func (w *WorkflowHandler) ListCallbackDeliveries(c *gin.Context) {
+	workflowId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	list, err := w.service.ListCallbackDeliveries(c, workflowId)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(list))
+}
+
+func (w *WorkflowHandler) DebugNode(c *gin.Context) {
+	var request model.DebugNodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, common.NewErrorResponse(err.Error()))
+		return
+	}
+	result, err := w.service.DebugNode(c.Request.Context(), &request)
+	switch {
+	case errors.Is(err, workflow.ErrInvalidDebugRequest):
+		c.JSON(http.StatusBadRequest, common.NewErrorResponse(err.Error()))
+	case err != nil:
+		panic(err)
+	default:
+		c.JSON(200, common.NewSuccessResponse(result))
+	}
+}
+
+func (w *WorkflowHandler) UsageStatistics(c *gin.Context) {
+	var query model.UsageStatisticsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		panic(err)
+	}
+	list, err := w.service.UsageStatistics(c, &query)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(list))
+}
+
+func (w *WorkflowHandler) ListPendingTasks(c *gin.Context) {
+	var query model.HumanTaskQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		panic(err)
+	}
+	list, total, err := w.service.ListPendingTasks(c, query)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponseWithTotal(list, total))
+}
+
+func (w *WorkflowHandler) CompleteTask(c *gin.Context) {
+	taskId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	var request model.CompleteHumanTaskRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		panic(err)
+	}
+	if err := w.service.CompleteTask(c, taskId, &request); err != nil {
+		panic(err)
+	}
+	c.JSON(200, common.NewSuccessResponse(nil))
+}
+
 func (w *WorkflowHandler) List(c *gin.Context) {
 	var query model.WorkflowInstanceQuery
 	if err := c.ShouldBindQuery(&query); err != nil {
@@ -91,28 +196,68 @@ func (w *WorkflowHandler) StartAndListen(c *gin.Context) {
 	if err := c.ShouldBindJSON(&request); err != nil {
 		panic(err)
 	}
+	workflowId, err := w.service.Start(c, &request)
+	if err != nil {
+		panic(err)
+	}
+	w.streamEvents(c, workflowId, 0)
+}
+
+// Events 订阅流程实例的事件，客户端重连时通过Last-Event-ID请求头或lastEventId参数补发错过的事件
+func (w *WorkflowHandler) Events(c *gin.Context) {
+	workflowId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	lastEventIdStr := c.GetHeader("Last-Event-ID")
+	if lastEventIdStr == "" {
+		lastEventIdStr = c.DefaultQuery("lastEventId", "0")
+	}
+	lastEventId, err := strconv.ParseInt(lastEventIdStr, 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	w.streamEvents(c, workflowId, lastEventId)
+}
+
+// streamEvents 使用SSE推送流程事件，流程结束、订阅被断开或客户端断开时返回
+func (w *WorkflowHandler) streamEvents(c *gin.Context, workflowId int64, lastEventId int64) {
+	sub, err := w.service.Subscribe(c, workflowId, lastEventId)
+	if err != nil {
+		panic(err)
+	}
+	defer w.service.Unsubscribe(sub)
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	c.Writer.Header().Set("Cache-Control", "no-cache")
 	c.Writer.Header().Set("Connection", "keep-alive")
-
+	writeEvent := func(msg model.WorkflowExecuteMessage) {
+		data, _ := json.Marshal(msg)
+		event := sse.Event{Event: "message", Data: string(data)}
+		if msg.EventId != 0 {
+			event.Id = strconv.FormatInt(msg.EventId, 10)
+		}
+		c.Render(-1, event)
+		c.Writer.Flush()
+	}
+	for _, msg := range sub.Replay {
+		writeEvent(msg)
+		lastEventId = max(lastEventId, msg.EventId)
+	}
 	done := c.Request.Context().Done()
-	msgChan := make(chan model.WorkflowExecuteMessage)
-	if err := w.service.StartWithMessageChan(c, &request, msgChan); err != nil {
-		panic(err)
-	}
-	c.Stream(func(w io.Writer) bool {
-		for {
-			select {
-			case <-done:
-				log.Println("client closed connection")
-				return true
-			case msg, ok := <-msgChan:
-				if !ok {
-					return false
-				}
-				data, _ := json.Marshal(msg)
-				c.SSEvent("message", string(data))
+	for {
+		select {
+		case <-done:
+			log.Println("client closed connection")
+			return
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
 			}
+			// 跳过已经补发过的事件
+			if msg.EventId != 0 && msg.EventId <= lastEventId {
+				continue
+			}
+			writeEvent(msg)
 		}
-	})
+	}
 }
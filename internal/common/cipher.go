@@ -0,0 +1,71 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// SecretCipher 使用AES-256-GCM加密敏感数据，密钥由配置的字符串经过sha256生成，密文格式为base64(nonce+密文)
+type SecretCipher struct {
+	aead cipher.AEAD
+}
+
+// NewSecretCipher 创建加密器，key为空时返回禁用的加密器，不会使用空字符串生成密钥，加密和解密都返回ErrSecretKeyNotConfigured
+func NewSecretCipher(key string) (*SecretCipher, error) {
+	if key == "" {
+		return &SecretCipher{}, nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretCipher{aead: aead}, nil
+}
+
+var ErrSecretKeyNotConfigured = errors.New("未配置加密密钥(secretKey)，密钥功能不可用")
+
+// Enabled 是否配置了加密密钥
+func (c *SecretCipher) Enabled() bool {
+	return c.aead != nil
+}
+
+func (c *SecretCipher) Encrypt(plaintext string) (string, error) {
+	if !c.Enabled() {
+		return "", ErrSecretKeyNotConfigured
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *SecretCipher) Decrypt(ciphertext string) (string, error) {
+	if !c.Enabled() {
+		return "", ErrSecretKeyNotConfigured
+	}
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize {
+		return "", errors.New("无效的密文")
+	}
+	plaintext, err := c.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return "", errors.New("解密失败，请检查加密密钥")
+	}
+	return string(plaintext), nil
+}
@@ -30,11 +30,18 @@ type Config struct {
 		Db       int    `yaml:"db"`
 	}
 	BochaAPIKey string `yaml:"bochaAPIKey"`
+	SecretKey   string `yaml:"secretKey"` // 加密密钥和环境变量中密钥的主密钥，修改后已保存的密钥无法解密
 	Milvus      struct {
 		Address  string `yaml:"address"`
 		Username string `yaml:"username"`
 		Password string `yaml:"password"`
 	} `yaml:"milvus"`
+	Workflow struct {
+		Workers        int    `yaml:"workers"`        // 节点执行工作协程数量
+		PollInterval   int    `yaml:"pollInterval"`   // 轮询节点队列的间隔，单位秒
+		LeaseDuration  int    `yaml:"leaseDuration"`  // 领取节点的租约时长，单位秒，租约过期未续约的节点会被重新排队
+		CallbackSecret string `yaml:"callbackSecret"` // 流程结束回调的签名密钥，为空时不能使用回调
+	} `yaml:"workflow"`
 }
 
 func ParseConfig(path string) (*Config, error) {
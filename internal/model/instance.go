@@ -11,6 +11,7 @@ const (
 	WorkflowInstanceStatusRunning WorkflowInstanceStatus = iota
 	WorkflowInstanceStatusCompleted
 	WorkflowInstanceStatusFailed
+	WorkflowInstanceStatusCancelled
 )
 
 func (wi WorkflowInstanceStatus) String() string {
@@ -21,6 +22,8 @@ func (wi WorkflowInstanceStatus) String() string {
 		return "完成"
 	case WorkflowInstanceStatusFailed:
 		return "失败"
+	case WorkflowInstanceStatusCancelled:
+		return "已取消"
 	default:
 		return "Unknown"
 	}
@@ -29,22 +32,31 @@ func (wi WorkflowInstanceStatus) String() string {
 type NodeInstanceStatus int
 
 const (
-	NodeInstanceStatusUnreached NodeInstanceStatus = iota
+	NodeInstanceStatusUnreached NodeInstanceStatus = iota // 未被执行的分支上的节点，标记为跳过
 	NodeInstanceStatusRunning
 	NodeInstanceStatusCompleted
 	NodeInstanceStatusFailed
+	NodeInstanceStatusCancelled
+	NodeInstanceStatusQueued  // 已进入调度队列，等待工作协程执行
+	NodeInstanceStatusWaiting // 等待人工处理等外部事件
 )
 
 func (ni NodeInstanceStatus) String() string {
 	switch ni {
 	case NodeInstanceStatusUnreached:
-		return "未到达"
+		return "已跳过"
 	case NodeInstanceStatusRunning:
 		return "运行中"
 	case NodeInstanceStatusCompleted:
 		return "完成"
 	case NodeInstanceStatusFailed:
 		return "失败"
+	case NodeInstanceStatusCancelled:
+		return "已取消"
+	case NodeInstanceStatusQueued:
+		return "排队中"
+	case NodeInstanceStatusWaiting:
+		return "等待处理"
 	default:
 		return "Unknown"
 	}
@@ -58,6 +70,10 @@ type WorkflowInstance struct {
 	AddTime      time.Time              `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
 	AddUser      int64                  `json:"addUser" gorm:"column:add_user;type:bigint;not null"`
 	CompleteTime time.Time              `json:"completeTime" gorm:"column:complete_time;type:datetime;not null"`
+	ParentId     int64                  `json:"parentId,string" gorm:"column:parent_id;type:bigint;not null;default:0;index"` // 子流程所属的父流程实例id
+	ParentNodeId string                 `json:"parentNodeId" gorm:"column:parent_node_id;type:varchar(64)"`                   // 子流程所属的父流程节点id
+	CallbackUrl  string                 `json:"callbackUrl" gorm:"column:callback_url;type:varchar(512);not null;default:''"` // 流程结束时回调的地址
+	Revision     int                    `json:"revision" gorm:"column:revision;type:int;not null;default:0"`                  // 流程定义版本，重试修改节点配置时递增
 }
 
 func (WorkflowInstance) TableName() string {
@@ -67,20 +83,48 @@ func (WorkflowInstance) TableName() string {
 // NodeInstance 节点实例表
 type NodeInstance struct {
 	Id           int64              `json:"id" gorm:"primary_key;column:id;type:bigint"`
-	WorkflowId   int64              `json:"workflowId" gorm:"column:workflow_id;type:bigint;not null"`
+	WorkflowId   int64              `json:"workflowId" gorm:"column:workflow_id;type:bigint;not null;uniqueIndex:uk_node_instance"`
 	Type         NodeType           `json:"type" gorm:"column:type;type:varchar(32);not null"`
-	NodeId       string             `json:"nodeId" gorm:"column:node_id;type:varchar(64);not null"`
+	NodeId       string             `json:"nodeId" gorm:"column:node_id;type:varchar(64);not null;uniqueIndex:uk_node_instance"` // 同一个流程实例中节点只会被调度一次
 	AddTime      time.Time          `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
 	CompleteTime time.Time          `json:"completeTime" gorm:"column:complete_time;type:datetime;not null"`
 	Status       NodeInstanceStatus `json:"status" gorm:"column:status;type:int;not null"`
-	Output       string             `json:"output" gorm:"column:output;type:json"` // 节点输出变量json
-	Error        string             `json:"error" gorm:"column:error;type:text"`   // 节点执行错误信息
+	Output       string             `json:"output" gorm:"column:output;type:json"`                                                        // 节点输出变量json
+	Error        string             `json:"error" gorm:"column:error;type:text"`                                                          // 节点执行错误信息
+	Attempts     int                `json:"attempts" gorm:"column:attempts;type:int;not null;default:0"`                                  // 节点执行次数
+	Executor     string             `json:"executor" gorm:"column:executor;type:varchar(64)"`                                             // 领取节点的服务实例id
+	ParentId     int64              `json:"parentId" gorm:"column:parent_id;type:bigint;not null;default:0;uniqueIndex:uk_node_instance"` // 迭代子流程节点所属的迭代节点实例id
+	Iteration    int                `json:"iteration" gorm:"column:iteration;type:int;not null;default:0;uniqueIndex:uk_node_instance"`   // 迭代子流程节点所属的迭代序号
+	ClaimedUntil *time.Time         `json:"-" gorm:"column:claimed_until;type:datetime"`                                                  // 领取节点的租约到期时间，执行期间由工作协程续约
 }
 
 func (NodeInstance) TableName() string {
 	return "wf_node_instance"
 }
 
+// NodeExecution 节点执行记录，节点每次尝试执行都会生成一条记录
+type NodeExecution struct {
+	Id             int64              `json:"id,string" gorm:"primary_key;column:id;type:bigint"`
+	NodeInstanceId int64              `json:"nodeInstanceId,string" gorm:"column:node_instance_id;type:bigint;not null;index"`
+	WorkflowId     int64              `json:"workflowId,string" gorm:"column:workflow_id;type:bigint;not null"`
+	NodeId         string             `json:"nodeId" gorm:"column:node_id;type:varchar(64);not null"`
+	Attempt        int                `json:"attempt" gorm:"column:attempt;type:int;not null"` // 第几次执行
+	Status         NodeInstanceStatus `json:"status" gorm:"column:status;type:int;not null"`
+	Error          string             `json:"error" gorm:"column:error;type:text"`
+	Inputs         string             `json:"inputs" gorm:"column:inputs;type:longtext"`                                // 解析后的输入变量json
+	Prompt         string             `json:"prompt" gorm:"column:prompt;type:longtext"`                                // 发送给模型的消息
+	RawResponse    string             `json:"rawResponse" gorm:"column:raw_response;type:longtext"`                     // 模型的原始响应
+	ModelId        int64              `json:"modelId,string" gorm:"column:model_id;type:bigint;not null;default:0"`     // 返回结果的模型，使用备用模型时与节点配置的模型不同
+	ModelName      string             `json:"modelName" gorm:"column:model_name;type:varchar(255);not null;default:''"` // 返回结果的模型名称
+	QueuedTime     time.Time          `json:"queuedTime" gorm:"column:queued_time;type:datetime"`                       // 节点进入队列的时间
+	StartTime      time.Time          `json:"startTime" gorm:"column:start_time;type:datetime;not null"`
+	FinishTime     time.Time          `json:"finishTime" gorm:"column:finish_time;type:datetime;not null"`
+}
+
+func (NodeExecution) TableName() string {
+	return "wf_node_execution"
+}
+
 type WorkflowInstanceQuery struct {
 	common.PageQuery
 }
@@ -108,9 +152,14 @@ type WorkflowInstanceDetailDTO struct {
 	CompleteTime      time.Time                           `json:"completeTime"`
 	Duration          string                              `json:"duration"`
 	Data              string                              `json:"data"`
+	ParentId          int64                               `json:"parentId,string"`
+	ParentNodeId      string                              `json:"parentNodeId"`
+	CallbackUrl       string                              `json:"callbackUrl"`
 	NodeStatusList    []*NodeStatusDTO                    `json:"nodeStatusList" gorm:"-"`
 	PassedEdgesList   []string                            `json:"passedEdgesList" gorm:"-"`
 	SuccessBranchList []*WorkflowInstanceSuccessBranchDTO `json:"successBranchList" gorm:"-"`
+	Usage             *TokenUsageSummary                  `json:"usage" gorm:"-"`      // 流程实例的token用量
+	NodeUsages        []*NodeTokenUsageDTO                `json:"nodeUsages" gorm:"-"` // 每个节点的token用量
 }
 
 type WorkflowInstanceSuccessBranchDTO struct {
@@ -123,6 +172,9 @@ type NodeStatusDTO struct {
 	NodeId     string             `json:"nodeId"`
 	Status     NodeInstanceStatus `json:"status"`
 	StatusName string             `json:"statusName"`
+	Attempts   int                `json:"attempts"`
+
+	ClaimedUntil *time.Time `json:"-"` // 运行中节点的租约到期时间
 }
 
 type NodeInstanceDetailDTO struct {
@@ -137,6 +189,24 @@ type NodeInstanceDetailDTO struct {
 	Error               string                  `json:"error"`  // 节点执行错误信息
 	StatusName          string                  `json:"statusName"`
 	OutputVariableTypes map[string]VariableType `json:"outputVariableTypes" gorm:"-"`
+	Attempts            int                     `json:"attempts"`
+	Executions          []*NodeExecution        `json:"executions" gorm:"-"`             // 每次执行的记录
+	Children            []*ChildNodeInstanceDTO `json:"children" gorm:"-"`               // 迭代节点每次迭代执行的子节点
+	ChildWorkflowId     int64                   `json:"childWorkflowId,string" gorm:"-"` // 子流程节点创建的子流程实例
+}
+
+// ChildNodeInstanceDTO 迭代子流程中的节点实例
+type ChildNodeInstanceDTO struct {
+	Id           int64              `json:"id"`
+	NodeId       string             `json:"nodeId"`
+	Type         NodeType           `json:"type"`
+	Iteration    int                `json:"iteration"`
+	Status       NodeInstanceStatus `json:"status"`
+	StatusName   string             `json:"statusName"`
+	Output       string             `json:"output"`
+	Error        string             `json:"error"`
+	AddTime      time.Time          `json:"addTime"`
+	CompleteTime time.Time          `json:"completeTime"`
 }
 
 type WorkflowInstanceTimelineDTO struct {
@@ -155,7 +225,21 @@ type NodeInstanceOutputVariable struct {
 	Value any          `json:"value"`
 }
 
+// WorkflowEvent 流程实例事件日志，订阅流程事件的客户端重连后根据事件id补发错过的事件
+type WorkflowEvent struct {
+	Id         int64     `json:"id,string" gorm:"primary_key;column:id;type:bigint"`
+	WorkflowId int64     `json:"workflowId,string" gorm:"column:workflow_id;type:bigint;not null;index"`
+	Data       string    `json:"data" gorm:"column:data;type:longtext;not null"`
+	AddTime    time.Time `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
+}
+
+func (WorkflowEvent) TableName() string {
+	return "wf_workflow_event"
+}
+
 type WorkflowExecuteMessage struct {
+	EventId            int64                  `json:"eventId,string"` // 事件id，流式输出的片段不记录事件日志，id为0
+	WorkflowId         int64                  `json:"workflowId,string"`
 	NodeId             string                 `json:"nodeId"`
 	NodeStatus         NodeInstanceStatus     `json:"nodeStatus"`
 	NodeStatusName     string                 `json:"nodeStatusName"`
@@ -165,4 +249,36 @@ type WorkflowExecuteMessage struct {
 	WorkflowStatusName string                 `json:"workflowStatusName"`
 
 	StreamChatContent string `json:"streamChatContent"`
+	StreamReset       bool   `json:"streamReset,omitempty"` // 模型调用失败切换备用模型，客户端需要丢弃该节点已经收到的流式输出
+}
+
+// CallbackDelivery 流程结束回调的投递记录，每次投递尝试记录一条。
+// 等待投递的记录带有下一次投递时间，服务重启后由任意服务实例继续投递
+type CallbackDelivery struct {
+	Id              int64      `json:"id,string" gorm:"primary_key;column:id;type:bigint"`
+	WorkflowId      int64      `json:"workflowId,string" gorm:"column:workflow_id;type:bigint;not null;index"`
+	Url             string     `json:"url" gorm:"column:url;type:varchar(512);not null"`
+	Attempt         int        `json:"attempt" gorm:"column:attempt;type:int;not null"`
+	Payload         string     `json:"payload" gorm:"column:payload;type:text;not null"`
+	StatusCode      int        `json:"statusCode" gorm:"column:status_code;type:int;not null"` // 响应状态码，请求失败时为0
+	Success         bool       `json:"success" gorm:"column:success;type:tinyint(1);not null"`
+	Error           string     `json:"error" gorm:"column:error;type:text"`
+	Duration        int64      `json:"duration" gorm:"column:duration;type:bigint;not null"`                // 请求耗时（毫秒）
+	NextAttemptTime *time.Time `json:"nextAttemptTime" gorm:"column:next_attempt_time;type:datetime;index"` // 等待投递或投递中的记录下一次投递的时间，投递完成后为空
+	AddTime         time.Time  `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
+}
+
+func (CallbackDelivery) TableName() string {
+	return "wf_callback_delivery"
+}
+
+// CallbackPayload 流程结束回调的请求体
+type CallbackPayload struct {
+	WorkflowId   int64                  `json:"workflowId,string"`
+	TemplateId   int64                  `json:"templateId,string"`
+	Status       WorkflowInstanceStatus `json:"status"`
+	StatusName   string                 `json:"statusName"`
+	Output       map[string]any         `json:"output"` // 结束节点的输出
+	Error        string                 `json:"error"`  // 失败节点的错误信息
+	CompleteTime time.Time              `json:"completeTime"`
 }
@@ -1,5 +1,12 @@
 package model
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
 type NodeType string
 
 const (
@@ -15,6 +22,10 @@ const (
 	NodeTypeQuestionOptimization NodeType = "questionOptimization" // 问题优化节点
 	NodeTypeImageUnderstanding   NodeType = "imageUnderstanding"   // 图像理解节点
 	NodeTypeOCR                  NodeType = "ocr"                  // OCR文档识别节点
+	NodeTypeHumanTask            NodeType = "humanTask"            // 人工处理节点
+	NodeTypeIteration            NodeType = "iteration"            // 迭代节点
+	NodeTypeSubWorkflow          NodeType = "subWorkflow"          // 子流程节点
+	NodeTypeCode                 NodeType = "code"                 // 代码节点
 )
 
 type VariableType string
@@ -83,6 +94,8 @@ type NodeData struct {
 	Input  []Input  `json:"input"`  // 节点输入变量列表
 	Output []Output `json:"output"` // 节点输出变量列表
 
+	Policy *ExecutionPolicy `json:"policy,omitempty"` // 节点执行策略
+
 	LLMNodeData                   *LLMNodeData                   `json:"llmNodeData,omitempty"`                   // 大模型节点数据
 	KnowledgeBaseWriteNodeData    *KnowledgeBaseWriteNodeData    `json:"knowledgeBaseWriteNodeData,omitempty"`    // 写入知识库节点数据
 	RetrieveKnowledgeBaseNodeData *RetrieveKnowledgeBaseNodeData `json:"retrieveKnowledgeBaseNodeData,omitempty"` // 检索知识库节点数据
@@ -95,8 +108,48 @@ type NodeData struct {
 	QuestionOptimizationNodeData  *QuestionOptimizationNodeData  `json:"questionOptimizationNodeData,omitempty"`  // 问题优化节点数据
 	ImageUnderstandingNodeData    *ImageUnderstandingNodeData    `json:"imageUnderstandingNodeData,omitempty"`    // 图像理解节点数据
 	OCRNodeData                   *OCRNodeData                   `json:"ocrNodeData,omitempty"`                   // OCR文档识别节点数据
+	HumanTaskNodeData             *HumanTaskNodeData             `json:"humanTaskNodeData,omitempty"`             // 人工处理节点数据
+	IterationNodeData             *IterationNodeData             `json:"iterationNodeData,omitempty"`             // 迭代节点数据
+	SubWorkflowNodeData           *SubWorkflowNodeData           `json:"subWorkflowNodeData,omitempty"`           // 子流程节点数据
+	CodeNodeData                  *CodeNodeData                  `json:"codeNodeData,omitempty"`                  // 代码节点数据
 }
 
+type RetryableError string
+
+const (
+	RetryableErrorAll         RetryableError = "all"          // 所有错误
+	RetryableErrorTimeout     RetryableError = "timeout"      // 执行超时
+	RetryableErrorNetwork     RetryableError = "network"      // 网络错误
+	RetryableErrorRateLimit   RetryableError = "rate_limit"   // 请求限流 429
+	RetryableErrorServerError RetryableError = "server_error" // 服务端错误 5xx
+)
+
+// ExecutionPolicy 节点执行策略，适用于所有类型的节点
+type ExecutionPolicy struct {
+	Timeout     int              `json:"timeout"`     // 单次执行超时时间（秒），0表示不限制
+	MaxRetries  int              `json:"maxRetries"`  // 最大重试次数
+	Backoff     int              `json:"backoff"`     // 首次重试等待时间（毫秒）
+	BackoffRate float64          `json:"backoffRate"` // 每次重试等待时间的增长倍率
+	RetryOn     []RetryableError `json:"retryOn"`     // 需要重试的错误类型，为空时重试超时、网络、限流和服务端错误
+
+	ErrorMode     ErrorMode      `json:"errorMode"`               // 重试后仍然失败时的处理方式，默认使流程失败
+	DefaultOutput map[string]any `json:"defaultOutput,omitempty"` // errorMode为default时节点使用的输出
+}
+
+type ErrorMode string
+
+const (
+	ErrorModeFail    ErrorMode = "fail"    // 流程失败
+	ErrorModeDefault ErrorMode = "default" // 使用默认输出继续执行后续节点
+	ErrorModeBranch  ErrorMode = "branch"  // 执行error分支的后续节点，可以引用节点的error.message和error.type
+)
+
+const (
+	NodeErrorHandle       = "error"         // 节点失败分支
+	NodeErrorMessageField = "error.message" // 失败分支中节点的错误信息
+	NodeErrorTypeField    = "error.type"    // 失败分支中节点的错误类型
+)
+
 // LLMNodeData LLM节点数据
 type LLMNodeData struct {
 	ModelName    string  `json:"modelName"`      // 模型名称
@@ -107,13 +160,47 @@ type LLMNodeData struct {
 	OutputFormat string  `json:"outputFormat"`   // 输出格式 text,markdown,json
 	Temperature  float64 `json:"temperature"`    // 温度 0~2
 	TopP         float64 `json:"topP"`           // TopP 0~1
+
+	FallbackModelIds ModelIds `json:"fallbackModelIds,omitempty"` // 备用模型，主模型调用失败时按顺序尝试
+}
+
+// ModelIds 模型id列表，json中使用字符串数组，避免前端处理int64时丢失精度
+type ModelIds []int64
+
+func (ids ModelIds) MarshalJSON() ([]byte, error) {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strconv.FormatInt(id, 10)
+	}
+	return json.Marshal(values)
+}
+
+func (ids *ModelIds) UnmarshalJSON(data []byte) error {
+	// 同时兼容字符串和数字
+	var values []json.Number
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	result := make(ModelIds, len(values))
+	for i, v := range values {
+		id, err := v.Int64()
+		if err != nil {
+			return fmt.Errorf("无效的模型id: %s", v)
+		}
+		result[i] = id
+	}
+	*ids = result
+	return nil
 }
 
 // KnowledgeBaseWriteNodeData 写入知识库节点数据
 type KnowledgeBaseWriteNodeData struct {
-	KbId       int64    `json:"kbId,string"` // 知识库ID
-	ChunkSize  int      `json:"chunkSize"`   // 分片大小
-	Separators []string `json:"separators"`  // 分隔符
+	KbId         int64      `json:"kbId,string"`  // 知识库ID
+	ChunkSize    int        `json:"chunkSize"`    // 分片大小
+	ChunkOverlap int        `json:"chunkOverlap"` // 分片重叠
+	Separators   []string   `json:"separators"`   // 分隔符
+	FileName     string     `json:"fileName"`     // 写入文本时的文件名，可以使用{{变量名}}引用输入变量
+	Metadata     []KeyValue `json:"metadata"`     // 文件元数据，可以使用{{变量名}}引用输入变量
 }
 
 // RetrieveKnowledgeBaseNodeData 检索知识库节点数据
@@ -133,7 +220,51 @@ type StartNodeData struct {
 type EndNodeData struct {
 }
 
+type HttpBodyType string
+
+const (
+	HttpBodyTypeNone HttpBodyType = "none" // 无请求体
+	HttpBodyTypeJSON HttpBodyType = "json" // application/json
+	HttpBodyTypeForm HttpBodyType = "form" // application/x-www-form-urlencoded
+	HttpBodyTypeRaw  HttpBodyType = "raw"  // 原始文本
+)
+
+type HttpAuthType string
+
+const (
+	HttpAuthTypeNone   HttpAuthType = "none"   // 无认证
+	HttpAuthTypeBearer HttpAuthType = "bearer" // Bearer Token
+	HttpAuthTypeBasic  HttpAuthType = "basic"  // Basic认证
+	HttpAuthTypeApiKey HttpAuthType = "apiKey" // 通过请求头传递API Key
+)
+
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// HttpAuth HTTP请求认证配置
+type HttpAuth struct {
+	Type       HttpAuthType `json:"type"`
+	Token      string       `json:"token"`      // Bearer Token
+	Username   string       `json:"username"`   // Basic认证用户名
+	Password   string       `json:"password"`   // Basic认证密码
+	HeaderName string       `json:"headerName"` // API Key请求头名称
+	ApiKey     string       `json:"apiKey"`     // API Key
+}
+
+// CrawlerNodeData HTTP请求节点数据，url、请求头、参数、请求体和认证信息中可以使用{{变量名}}引用输入变量
 type CrawlerNodeData struct {
+	Method          string       `json:"method"`          // 请求方法，默认GET
+	Headers         []KeyValue   `json:"headers"`         // 请求头
+	Params          []KeyValue   `json:"params"`          // 查询参数
+	BodyType        HttpBodyType `json:"bodyType"`        // 请求体类型
+	Body            string       `json:"body"`            // json或raw类型的请求体
+	FormData        []KeyValue   `json:"formData"`        // form类型的请求体
+	Auth            *HttpAuth    `json:"auth,omitempty"`  // 认证配置
+	Timeout         int          `json:"timeout"`         // 超时时间（秒），0表示使用默认值
+	MaxResponseSize int64        `json:"maxResponseSize"` // 响应内容最大字节数，0表示使用默认值
+	FailOnNon2xx    bool         `json:"failOnNon2xx"`    // 响应状态码不是2xx时节点失败，否则作为输出返回
 }
 
 type Condition struct {
@@ -163,21 +294,24 @@ type WebSearchNodeData struct {
 }
 
 type KeywordExtractionNodeData struct {
-	ModelId   int64  `json:"modelId,string"`
-	ModelName string `json:"modelName"`
-	Count     int    `json:"count"`
+	ModelId          int64    `json:"modelId,string"`
+	ModelName        string   `json:"modelName"`
+	Count            int      `json:"count"`
+	FallbackModelIds ModelIds `json:"fallbackModelIds,omitempty"` // 备用模型
 }
 
 type QuestionOptimizationNodeData struct {
-	ModelId   int64  `json:"modelId,string"`
-	ModelName string `json:"modelName"`
+	ModelId          int64    `json:"modelId,string"`
+	ModelName        string   `json:"modelName"`
+	FallbackModelIds ModelIds `json:"fallbackModelIds,omitempty"` // 备用模型
 }
 
 type ImageUnderstandingNodeData struct {
-	ModelId      int64  `json:"modelId,string"`
-	ModelName    string `json:"modelName"`
-	Prompt       string `json:"prompt"`
-	OutputFormat string `json:"outputFormat"`
+	ModelId          int64    `json:"modelId,string"`
+	ModelName        string   `json:"modelName"`
+	Prompt           string   `json:"prompt"`
+	OutputFormat     string   `json:"outputFormat"`
+	FallbackModelIds ModelIds `json:"fallbackModelIds,omitempty"` // 备用模型
 }
 
 type OCRNodeData struct {
@@ -188,6 +322,54 @@ type OCRNodeData struct {
 type MemoryNodeData struct {
 }
 
+type IterationErrorMode string
+
+const (
+	IterationErrorModeStop    IterationErrorMode = "stop"    // 任意一次迭代失败时节点失败
+	IterationErrorModeSkip    IterationErrorMode = "skip"    // 忽略失败的迭代，结果中不包含失败的元素
+	IterationErrorModeCollect IterationErrorMode = "collect" // 失败的迭代结果为null，错误信息收集到errors输出
+)
+
+// IterationNodeData 迭代节点数据，对输入数组的每个元素执行一次子流程。
+// 子流程中的节点通过引用迭代节点的item和index变量获取当前元素和序号
+type IterationNodeData struct {
+	Parallelism    int                 `json:"parallelism"`    // 同时执行的迭代数量
+	ErrorMode      IterationErrorMode  `json:"errorMode"`      // 迭代失败的处理方式
+	SubGraph       *WorkflowDefinition `json:"subGraph"`       // 子流程，需要包含结束节点
+	OutputVariable string              `json:"outputVariable"` // 子流程结束节点中作为迭代结果的变量，为空时收集结束节点的全部输出
+}
+
+// SubWorkflowNodeData 子流程节点数据，节点的输入变量对应子流程模板开始节点的变量，输出为子流程结束节点的输出
+type SubWorkflowNodeData struct {
+	TemplateId   int64  `json:"templateId,string"` // 子流程模板id
+	TemplateName string `json:"templateName"`
+}
+
+// CodeNodeData 代码节点数据，脚本使用Starlark语言，必须定义main(inputs)函数，返回值作为节点输出
+type CodeNodeData struct {
+	Code     string `json:"code"`     // 脚本代码
+	MaxSteps uint64 `json:"maxSteps"` // 最大执行步数，0表示使用默认值
+}
+
+const (
+	HumanTaskHandleApprove = "approve" // 人工节点通过分支
+	HumanTaskHandleReject  = "reject"  // 人工节点驳回分支
+)
+
+// FormField 人工节点表单字段，处理任务时提交的值作为节点同名输出变量
+type FormField struct {
+	Name     string       `json:"name"`
+	Label    string       `json:"label"`
+	Type     VariableType `json:"type"`
+	Required bool         `json:"required"`
+}
+
+type HumanTaskNodeData struct {
+	Title       string      `json:"title"`       // 任务标题
+	Description string      `json:"description"` // 任务说明，可以引用输入变量
+	FormFields  []FormField `json:"formFields"`  // 表单字段
+}
+
 // ConditionNodePrototype 条件判断节点原型
 var ConditionNodePrototype = &Node{
 	Type: NodeTypeCondition,
@@ -262,17 +444,25 @@ var CrawlerNodePrototype = &Node{
 	Data: NodeData{
 		Name:                 "HTTP请求",
 		DefaultAllowVarTypes: []VariableType{VariableTypeString, VariableTypeNumber},
-		AllowAddInputVar:     false,
+		AllowAddInputVar:     true,
 		AllowAddOutputVar:    false,
 		Input: []Input{
 			{Name: "url", Type: VariableTypeString, Required: true, Fixed: true},
 		},
 		Output: []Output{
 			{Name: "code", Type: VariableTypeNumber},
+			{Name: "headers", Type: VariableTypeString},
 			{Name: "content-type", Type: VariableTypeString},
 			{Name: "content", Type: VariableTypeString},
 		},
-		CrawlerNodeData: &CrawlerNodeData{},
+		CrawlerNodeData: &CrawlerNodeData{
+			Method:   http.MethodGet,
+			Headers:  []KeyValue{},
+			Params:   []KeyValue{},
+			BodyType: HttpBodyTypeNone,
+			FormData: []KeyValue{},
+			Auth:     &HttpAuth{Type: HttpAuthTypeNone},
+		},
 	},
 }
 
@@ -375,6 +565,100 @@ var OCRNodePrototype = &Node{
 	},
 }
 
+var HumanTaskNodePrototype = &Node{
+	Type: NodeTypeHumanTask,
+	Data: NodeData{
+		Name:                 "人工处理",
+		DefaultAllowVarTypes: []VariableType{VariableTypeString, VariableTypeNumber},
+		AllowAddInputVar:     true,
+		AllowAddOutputVar:    false,
+		Input:                []Input{},
+		Output: []Output{
+			{Name: "comment", Type: VariableTypeString},
+		},
+		HumanTaskNodeData: &HumanTaskNodeData{
+			Title:      "",
+			FormFields: []FormField{},
+		},
+	},
+}
+
+var IterationNodePrototype = &Node{
+	Type: NodeTypeIteration,
+	Data: NodeData{
+		Name:                 "迭代",
+		DefaultAllowVarTypes: []VariableType{VariableTypeStringArray, VariableTypeNumberArray},
+		AllowAddInputVar:     false,
+		AllowAddOutputVar:    false,
+		Input: []Input{
+			{Name: "items", Type: VariableTypeStringArray, Required: true, Fixed: true},
+		},
+		Output: []Output{
+			{Name: "results", Type: VariableTypeStringArray},
+			{Name: "errors", Type: VariableTypeStringArray},
+		},
+		IterationNodeData: &IterationNodeData{
+			Parallelism: 1,
+			ErrorMode:   IterationErrorModeStop,
+			SubGraph:    &WorkflowDefinition{Nodes: []*Node{}, Edges: []*Edge{}},
+		},
+	},
+}
+
+var SubWorkflowNodePrototype = &Node{
+	Type: NodeTypeSubWorkflow,
+	Data: NodeData{
+		Name:                 "子流程",
+		DefaultAllowVarTypes: []VariableType{VariableTypeString, VariableTypeNumber},
+		AllowAddInputVar:     true,
+		AllowAddOutputVar:    true,
+		Input:                []Input{},
+		Output:               []Output{},
+		SubWorkflowNodeData:  &SubWorkflowNodeData{},
+	},
+}
+
+var KbWriteNodePrototype = &Node{
+	Type: NodeTypeKnowledgeWrite,
+	Data: NodeData{
+		Name:                 "写入知识库",
+		DefaultAllowVarTypes: []VariableType{VariableTypeString, VariableTypeTextFile},
+		AllowAddInputVar:     true,
+		AllowAddOutputVar:    false,
+		Input: []Input{
+			{Name: "content", Type: VariableTypeString, Required: true, Fixed: true},
+		},
+		Output: []Output{
+			{Name: "fileId", Type: VariableTypeString},
+			{Name: "chunks", Type: VariableTypeNumber},
+		},
+		KnowledgeBaseWriteNodeData: &KnowledgeBaseWriteNodeData{
+			ChunkSize:    DefaultChunkSize,
+			ChunkOverlap: DefaultChunkOverlap,
+			Separators:   []string{"\n\n"},
+			Metadata:     []KeyValue{},
+		},
+	},
+}
+
+var CodeNodePrototype = &Node{
+	Type: NodeTypeCode,
+	Data: NodeData{
+		Name: "代码",
+		DefaultAllowVarTypes: []VariableType{VariableTypeString, VariableTypeNumber, VariableTypeStringArray,
+			VariableTypeNumberArray},
+		AllowAddInputVar:  true,
+		AllowAddOutputVar: true,
+		Input:             []Input{},
+		Output: []Output{
+			{Name: "result", Type: VariableTypeString},
+		},
+		CodeNodeData: &CodeNodeData{
+			Code: "def main(inputs):\n    return {\"result\": \"\"}\n",
+		},
+	},
+}
+
 var EndNodePrototype = &Node{
 	Type: NodeTypeEnd,
 	Data: NodeData{
@@ -387,7 +671,14 @@ var EndNodePrototype = &Node{
 	},
 }
 
+// SystemVariableNodeId 系统变量的来源节点id，引用系统变量时SourceNode为sys，SourceName为变量名
+const SystemVariableNodeId = "sys"
+
+// SystemVariablePrototype 所有节点都可以引用的系统变量，值在运行时根据流程实例填充
 var SystemVariablePrototype = []Input{
-	{Name: "sys.query", Type: VariableTypeString, Fixed: true, Value: Value{Type: VarValueTypeLiteral, Content: ""}},
-	{Name: "sys.workflow_id", Type: VariableTypeString, Fixed: true, Value: Value{Type: VarValueTypeLiteral, Content: ""}},
+	{Name: "sys.query", Type: VariableTypeString, Fixed: true, Value: Value{Type: VarValueTypeRef, SourceNode: SystemVariableNodeId, SourceName: "query"}},
+	{Name: "sys.workflow_id", Type: VariableTypeString, Fixed: true, Value: Value{Type: VarValueTypeRef, SourceNode: SystemVariableNodeId, SourceName: "workflow_id"}},
+	{Name: "sys.template_id", Type: VariableTypeString, Fixed: true, Value: Value{Type: VarValueTypeRef, SourceNode: SystemVariableNodeId, SourceName: "template_id"}},
+	{Name: "sys.user", Type: VariableTypeString, Fixed: true, Value: Value{Type: VarValueTypeRef, SourceNode: SystemVariableNodeId, SourceName: "user"}},
+	{Name: "sys.time", Type: VariableTypeString, Fixed: true, Value: Value{Type: VarValueTypeRef, SourceNode: SystemVariableNodeId, SourceName: "time"}},
 }
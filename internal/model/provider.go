@@ -49,6 +49,8 @@ type ProviderModel struct {
 	ModelType   ProviderModelType `json:"modelType" gorm:"column:model_type;type:varchar(32);not null"`
 	Credentials string            `json:"credentials" gorm:"column:credentials;type:text"`
 	AddTime     time.Time         `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
+	InputPrice  float64           `json:"inputPrice" gorm:"column:input_price;type:decimal(12,6);not null;default:0"`   // 输入价格（元/百万tokens）
+	OutputPrice float64           `json:"outputPrice" gorm:"column:output_price;type:decimal(12,6);not null;default:0"` // 输出价格（元/百万tokens）
 }
 
 func (ProviderModel) TableName() string {
@@ -71,6 +73,8 @@ type ProviderModelListDTO struct {
 	ModelType    ProviderModelType `json:"modelType"`
 	AddTime      time.Time         `json:"addTime"`
 	ProviderCode ProviderCode      `json:"providerCode"`
+	InputPrice   float64           `json:"inputPrice"`
+	OutputPrice  float64           `json:"outputPrice"`
 }
 
 type ProviderModelDetail struct {
@@ -83,6 +87,15 @@ type ProviderModelDetail struct {
 	Credentials         string            `json:"credentials"`
 	ProviderCredentials string            `json:"providerCredentials"`
 	ProviderCode        ProviderCode      `json:"providerCode"`
+	InputPrice          float64           `json:"inputPrice"`
+	OutputPrice         float64           `json:"outputPrice"`
+}
+
+// UpdateModelPriceRequest 修改模型价格
+type UpdateModelPriceRequest struct {
+	Id          int64   `json:"id,string" binding:"required"`
+	InputPrice  float64 `json:"inputPrice"`
+	OutputPrice float64 `json:"outputPrice"`
 }
 
 type ProviderModelQuery struct {
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// WorkflowSchedule 模板的定时触发配置，按cron表达式在指定时区定时使用固定的输入变量启动流程
+type WorkflowSchedule struct {
+	Id             int64      `json:"id,string" gorm:"primary_key;type:bigint"`
+	TemplateId     int64      `json:"templateId,string" gorm:"column:template_id;type:bigint;not null;index"`
+	Name           string     `json:"name" gorm:"column:name;type:varchar(50);not null"`
+	CronExpr       string     `json:"cronExpr" gorm:"column:cron_expr;type:varchar(100);not null"`
+	Timezone       string     `json:"timezone" gorm:"column:timezone;type:varchar(64);not null"` // 为空时使用服务器时区
+	Inputs         string     `json:"inputs" gorm:"column:inputs;type:text;not null"`            // 开始节点输入变量json
+	Enabled        bool       `json:"enabled" gorm:"column:enabled;type:tinyint(1);not null"`
+	LastFireTime   *time.Time `json:"lastFireTime" gorm:"column:last_fire_time;type:datetime"`
+	NextFireTime   *time.Time `json:"nextFireTime" gorm:"column:next_fire_time;type:datetime;index"` // 停用时为空
+	LastWorkflowId int64      `json:"lastWorkflowId,string" gorm:"column:last_workflow_id;type:bigint;not null"`
+	LastError      string     `json:"lastError" gorm:"column:last_error;type:text"` // 最近一次启动流程失败的原因
+	AddTime        time.Time  `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
+	AddUser        int64      `json:"addUser" gorm:"column:add_user;type:bigint;not null"`
+}
+
+func (WorkflowSchedule) TableName() string {
+	return "wf_workflow_schedule"
+}
+
+// WorkflowScheduleRequest 创建或修改定时触发
+type WorkflowScheduleRequest struct {
+	Name     string         `json:"name"`
+	CronExpr string         `json:"cronExpr" binding:"required"`
+	Timezone string         `json:"timezone"`
+	Inputs   map[string]any `json:"inputs"`
+	Enabled  bool           `json:"enabled"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+type SecretKind string
+
+const (
+	SecretKindEnv    SecretKind = "env"    // 环境变量，明文保存，节点中使用{{env.NAME}}引用
+	SecretKindSecret SecretKind = "secret" // 密钥，加密保存且不会通过接口返回，节点中使用{{secret.NAME}}引用
+)
+
+// Secret 环境变量和密钥，模板id为0时全局可用，模板内的同名变量优先
+type Secret struct {
+	Id          int64      `json:"id,string" gorm:"primary_key;type:bigint"`
+	TemplateId  int64      `json:"templateId,string" gorm:"column:template_id;type:bigint;not null;uniqueIndex:uk_secret_name"`
+	Kind        SecretKind `json:"kind" gorm:"column:kind;type:varchar(16);not null;uniqueIndex:uk_secret_name"`
+	Name        string     `json:"name" gorm:"column:name;type:varchar(64);not null;uniqueIndex:uk_secret_name"`
+	Value       string     `json:"value,omitempty" gorm:"column:value;type:text;not null"` // 密钥保存加密后的值，查询时不返回
+	Description string     `json:"description" gorm:"column:description;type:varchar(255);not null"`
+	AddTime     time.Time  `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
+	UpdateTime  time.Time  `json:"updateTime" gorm:"column:update_time;type:datetime;not null"`
+}
+
+func (Secret) TableName() string {
+	return "wf_secret"
+}
+
+// SecretRequest 创建或修改环境变量和密钥，修改密钥时value为空表示不修改值
+type SecretRequest struct {
+	TemplateId  int64      `json:"templateId,string"`
+	Kind        SecretKind `json:"kind"`
+	Name        string     `json:"name"`
+	Value       string     `json:"value"`
+	Description string     `json:"description"`
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"github.com/StellrisJAY/workflow-ai/internal/common"
+	"time"
+)
+
+type HumanTaskStatus int
+
+const (
+	HumanTaskStatusPending HumanTaskStatus = iota
+	HumanTaskStatusApproved
+	HumanTaskStatusRejected
+	HumanTaskStatusCancelled
+)
+
+func (s HumanTaskStatus) String() string {
+	switch s {
+	case HumanTaskStatusPending:
+		return "待处理"
+	case HumanTaskStatusApproved:
+		return "已通过"
+	case HumanTaskStatusRejected:
+		return "已驳回"
+	case HumanTaskStatusCancelled:
+		return "已取消"
+	default:
+		return "Unknown"
+	}
+}
+
+// HumanTask 人工处理任务，人工节点执行时创建，处理完成后流程继续执行
+type HumanTask struct {
+	Id             int64           `json:"id,string" gorm:"primary_key;column:id;type:bigint"`
+	WorkflowId     int64           `json:"workflowId,string" gorm:"column:workflow_id;type:bigint;not null;index"`
+	NodeInstanceId int64           `json:"nodeInstanceId,string" gorm:"column:node_instance_id;type:bigint;not null;index"`
+	NodeId         string          `json:"nodeId" gorm:"column:node_id;type:varchar(64);not null"`
+	Title          string          `json:"title" gorm:"column:title;type:varchar(255);not null"`
+	Description    string          `json:"description" gorm:"column:description;type:text"`
+	FormFields     string          `json:"formFields" gorm:"column:form_fields;type:json"` // 表单字段定义json
+	Values         string          `json:"values" gorm:"column:form_values;type:json"`     // 表单值json，创建时为输入变量中的同名值
+	Comment        string          `json:"comment" gorm:"column:comment;type:text"`
+	Status         HumanTaskStatus `json:"status" gorm:"column:status;type:int;not null;index"`
+	AddTime        time.Time       `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
+	CompleteTime   time.Time       `json:"completeTime" gorm:"column:complete_time;type:datetime;not null"`
+	CompleteUser   int64           `json:"completeUser" gorm:"column:complete_user;type:bigint;not null;default:0"`
+}
+
+func (HumanTask) TableName() string {
+	return "wf_human_task"
+}
+
+type HumanTaskQuery struct {
+	common.PageQuery
+	WorkflowId int64 `form:"workflowId"`
+}
+
+type HumanTaskListDTO struct {
+	Id           int64           `json:"id,string"`
+	WorkflowId   int64           `json:"workflowId,string"`
+	NodeId       string          `json:"nodeId"`
+	TemplateName string          `json:"templateName"`
+	Title        string          `json:"title"`
+	Description  string          `json:"description"`
+	FormFields   []FormField     `json:"formFields" gorm:"-"`
+	Values       map[string]any  `json:"values" gorm:"-"`
+	Status       HumanTaskStatus `json:"status"`
+	StatusName   string          `json:"statusName"`
+	AddTime      time.Time       `json:"addTime"`
+
+	FormFieldsJSON string `json:"-" gorm:"column:form_fields"`
+	ValuesJSON     string `json:"-" gorm:"column:form_values"`
+}
+
+type HumanTaskAction string
+
+const (
+	HumanTaskActionApprove HumanTaskAction = "approve"
+	HumanTaskActionReject  HumanTaskAction = "reject"
+)
+
+// CompleteHumanTaskRequest 处理人工任务，通过或驳回分别执行人工节点的approve和reject分支
+type CompleteHumanTaskRequest struct {
+	Action  HumanTaskAction `json:"action" binding:"required"`
+	Values  map[string]any  `json:"values"`
+	Comment string          `json:"comment"`
+}
@@ -12,6 +12,7 @@ type Template struct {
 	Data        string    `json:"data" biding:"required" gorm:"column:data;type:text;not null"`
 	AddTime     time.Time `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
 	AddUser     int64     `json:"addUser" gorm:"column:add_user;type:bigint;not null"`
+	CallbackUrl string    `json:"callbackUrl" gorm:"column:callback_url;type:varchar(512);not null;default:''"` // 默认的流程结束回调地址
 }
 
 func (Template) TableName() string {
@@ -27,6 +28,7 @@ type TemplateDetailDTO struct {
 	AddUser     int64     `json:"addUser"`
 	AddUserName string    `json:"addUserName"`
 	UsageCount  int64     `json:"usageCount"`
+	CallbackUrl string    `json:"callbackUrl"`
 }
 
 type TemplateListDTO struct {
@@ -0,0 +1,59 @@
+package model
+
+import "time"
+
+// TokenUsage 模型调用的token用量，节点每次调用模型记录一条，费用按调用时的模型价格计算
+type TokenUsage struct {
+	Id               int64     `json:"id,string" gorm:"primary_key;column:id;type:bigint"`
+	WorkflowId       int64     `json:"workflowId,string" gorm:"column:workflow_id;type:bigint;not null;index"`
+	NodeInstanceId   int64     `json:"nodeInstanceId,string" gorm:"column:node_instance_id;type:bigint;not null;index"`
+	NodeId           string    `json:"nodeId" gorm:"column:node_id;type:varchar(64);not null"`
+	ModelId          int64     `json:"modelId,string" gorm:"column:model_id;type:bigint;not null"`
+	ModelName        string    `json:"modelName" gorm:"column:model_name;type:varchar(64);not null"`
+	PromptTokens     int       `json:"promptTokens" gorm:"column:prompt_tokens;type:int;not null"`
+	CompletionTokens int       `json:"completionTokens" gorm:"column:completion_tokens;type:int;not null"`
+	Cost             float64   `json:"cost" gorm:"column:cost;type:decimal(16,6);not null"`
+	AddTime          time.Time `json:"addTime" gorm:"column:add_time;type:datetime;not null;index"`
+}
+
+func (TokenUsage) TableName() string {
+	return "wf_token_usage"
+}
+
+// TokenUsageSummary token用量汇总
+type TokenUsageSummary struct {
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	TotalTokens      int     `json:"totalTokens"`
+	Cost             float64 `json:"cost"`
+	Calls            int     `json:"calls"` // 模型调用次数
+}
+
+// NodeTokenUsageDTO 流程实例中每个节点的token用量
+type NodeTokenUsageDTO struct {
+	NodeId string `json:"nodeId"`
+	TokenUsageSummary
+}
+
+type UsageGroupBy string
+
+const (
+	UsageGroupByTemplate UsageGroupBy = "template" // 按模板统计
+	UsageGroupByDay      UsageGroupBy = "day"      // 按天统计
+	UsageGroupByModel    UsageGroupBy = "model"    // 按模型统计
+)
+
+type UsageStatisticsQuery struct {
+	GroupBy   UsageGroupBy `form:"groupBy"`
+	StartTime string       `form:"startTime"` // 开始日期 2006-01-02
+	EndTime   string       `form:"endTime"`   // 结束日期 2006-01-02，包含当天
+	Start     time.Time    `form:"-"`
+	End       time.Time    `form:"-"`
+}
+
+// UsageStatisticsDTO 按模板、日期或模型汇总的token用量
+type UsageStatisticsDTO struct {
+	Key  string `json:"key"`  // 模板id、日期或模型id
+	Name string `json:"name"` // 模板名称、日期或模型名称
+	TokenUsageSummary
+}
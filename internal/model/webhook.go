@@ -0,0 +1,56 @@
+package model
+
+import "time"
+
+type WebhookResponseMode string
+
+const (
+	WebhookResponseModeAsync WebhookResponseMode = "async" // 启动流程后立即返回流程实例id
+	WebhookResponseModeSync  WebhookResponseMode = "sync"  // 等待流程结束后返回结束节点的输出
+)
+
+// WorkflowWebhook 模板的webhook触发器，外部系统向 /api/v1/hooks/{token} 发送POST请求启动流程
+type WorkflowWebhook struct {
+	Id           int64               `json:"id,string" gorm:"primary_key;type:bigint"`
+	TemplateId   int64               `json:"templateId,string" gorm:"column:template_id;type:bigint;not null;index"`
+	Name         string              `json:"name" gorm:"column:name;type:varchar(50);not null"`
+	Token        string              `json:"token" gorm:"column:token;type:varchar(64);not null;uniqueIndex"` // webhook地址中的随机令牌
+	Secret       string              `json:"-" gorm:"column:secret;type:varchar(255);not null"`               // 加密后的请求签名密钥，为空时不校验签名
+	HasSecret    bool                `json:"hasSecret" gorm:"-"`                                              // 是否配置了签名密钥，密钥不会返回
+	Mappings     string              `json:"mappings" gorm:"column:mappings;type:text;not null"`              // 请求体到开始节点变量的映射json
+	ResponseMode WebhookResponseMode `json:"responseMode" gorm:"column:response_mode;type:varchar(16);not null"`
+	Timeout      int                 `json:"timeout" gorm:"column:timeout;type:int;not null"` // 同步响应时等待流程结束的时间（秒）
+	Enabled      bool                `json:"enabled" gorm:"column:enabled;type:tinyint(1);not null"`
+	AddTime      time.Time           `json:"addTime" gorm:"column:add_time;type:datetime;not null"`
+	AddUser      int64               `json:"addUser" gorm:"column:add_user;type:bigint;not null"`
+}
+
+func (WorkflowWebhook) TableName() string {
+	return "wf_workflow_webhook"
+}
+
+// WebhookMapping 使用JSONPath从请求体中取值作为开始节点的输入变量
+type WebhookMapping struct {
+	Variable string `json:"variable"` // 开始节点变量名
+	Path     string `json:"path"`     // JSONPath，如 $.issue.title
+}
+
+// WorkflowWebhookRequest 创建或修改webhook，没有配置映射时请求体中与变量同名的字段作为输入变量。
+// 修改时secret为空保留原来的签名密钥，clearSecret为true时删除签名密钥
+type WorkflowWebhookRequest struct {
+	Name         string              `json:"name"`
+	Secret       string              `json:"secret"`
+	ClearSecret  bool                `json:"clearSecret"`
+	Mappings     []WebhookMapping    `json:"mappings"`
+	ResponseMode WebhookResponseMode `json:"responseMode"`
+	Timeout      int                 `json:"timeout"`
+	Enabled      bool                `json:"enabled"`
+}
+
+// WebhookTriggerResult webhook触发结果，同步响应时包含流程状态和结束节点的输出
+type WebhookTriggerResult struct {
+	WorkflowId int64                  `json:"workflowId,string"`
+	Status     WorkflowInstanceStatus `json:"status"`
+	StatusName string                 `json:"statusName"`
+	Output     map[string]any         `json:"output,omitempty"`
+}
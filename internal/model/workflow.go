@@ -7,7 +7,64 @@ type WorkflowDefinition struct {
 }
 
 type StartWorkflowRequest struct {
-	TemplateId int64          `json:"templateId,string" biding:"required"`
-	Inputs     map[string]any `json:"inputs" biding:"required"`
-	Definition string         `json:"definition"`
+	TemplateId  int64          `json:"templateId,string" biding:"required"`
+	Inputs      map[string]any `json:"inputs" biding:"required"`
+	Definition  string         `json:"definition"`
+	CallbackUrl string         `json:"callbackUrl"` // 流程结束时回调的地址，为空时使用模板的默认回调地址
+}
+
+// RetryWorkflowRequest 从失败的节点继续执行流程实例，可以同时修改未完成节点的配置
+type RetryWorkflowRequest struct {
+	NodeId   string            `json:"nodeId"`   // 需要修改的节点id，为空时按原配置重试
+	NodeData *NodeData         `json:"nodeData"` // 修改后的节点配置
+	Inputs   map[string]string `json:"inputs"`   // 覆盖节点的输入变量，以字面量保存
+}
+
+type DiagnosticLevel string
+
+const (
+	DiagnosticLevelError   DiagnosticLevel = "error"   // 错误，流程定义不能保存和运行
+	DiagnosticLevelWarning DiagnosticLevel = "warning" // 警告，不影响保存和运行
+)
+
+// Diagnostic 流程定义的校验结果，NodeId和EdgeId都为空时表示整个流程的问题
+type Diagnostic struct {
+	Level        DiagnosticLevel `json:"level"`
+	NodeId       string          `json:"nodeId"`
+	EdgeId       string          `json:"edgeId"`
+	ParentNodeId string          `json:"parentNodeId"` // 迭代子流程中的节点所属的迭代节点
+	Message      string          `json:"message"`
+}
+
+type ValidateDefinitionRequest struct {
+	Data string `json:"data" binding:"required"` // 流程定义json
+}
+
+type ValidateDefinitionResult struct {
+	Valid       bool          `json:"valid"` // 没有错误级别的诊断
+	Diagnostics []*Diagnostic `json:"diagnostics"`
+}
+
+// DebugNodeRequest 单独调试一个节点，输入变量全部使用请求中的值
+type DebugNodeRequest struct {
+	Node       *Node          `json:"node" binding:"required"`
+	Inputs     map[string]any `json:"inputs"`            // 输入变量的值，没有提供的变量使用节点配置的字面量
+	TemplateId int64          `json:"templateId,string"` // 节点所属模板，用于查找节点引用的环境变量和密钥
+
+	AllowSideEffects bool `json:"allowSideEffects"` // 允许调试会写入数据或发送HTTP请求的节点
+}
+
+// DebugNodeResult 节点调试结果，不会创建流程实例和保存执行记录
+type DebugNodeResult struct {
+	Status      NodeInstanceStatus `json:"status"`
+	StatusName  string             `json:"statusName"`
+	Inputs      string             `json:"inputs"`         // 节点实际使用的输入变量json
+	Output      string             `json:"output"`         // 节点输出变量json
+	Error       string             `json:"error"`          // 节点执行错误信息
+	Prompt      string             `json:"prompt"`         // 渲染后的提示词
+	RawResponse string             `json:"rawResponse"`    // 模型的原始响应
+	ModelId     int64              `json:"modelId,string"` // 返回结果的模型
+	ModelName   string             `json:"modelName"`      // 返回结果的模型名称
+	Latency     int64              `json:"latency"`        // 执行耗时（毫秒）
+	Usage       *TokenUsageSummary `json:"usage"`          // token用量
 }
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/StellrisJAY/workflow-ai/internal/ai"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"github.com/StellrisJAY/workflow-ai/internal/repo"
@@ -209,6 +210,9 @@ func (d *DocumentProcessor) splitDocument(ctx context.Context, file *model.Knowl
 		return nil, err
 	}
 	for i, chunk := range chunks {
+		for key, value := range metadata {
+			chunk.Metadata[key] = value
+		}
 		chunk.Metadata["fileId"] = file.Id
 		chunk.Metadata["order"] = i
 		chunk.Metadata["kbId"] = file.KbId
@@ -251,20 +255,26 @@ func (d *DocumentProcessor) worker(ctx context.Context) {
 }
 
 func (d *DocumentProcessor) handleTask(ctx context.Context, taskId int64) {
+	if _, err := d.ProcessTask(ctx, taskId); err != nil {
+		log.Println("handleTask err:", err)
+	}
+}
+
+// ProcessTask 执行文件处理任务，拆分文档并写入向量库，返回分片数量
+func (d *DocumentProcessor) ProcessTask(ctx context.Context, taskId int64) (chunkCount int, err error) {
 	task, err := d.kbRepo.GetFileProcessTask(ctx, taskId)
 	if err != nil {
-		log.Println("handleTask err:", err)
-		return
+		return 0, err
 	}
 	defer func() {
 		if r := recover(); r != nil {
-			err, ok := r.(error)
+			e, ok := r.(error)
 			if !ok {
-				log.Println("handleTask err:", r)
-				return
+				e = fmt.Errorf("%v", r)
 			}
+			err = e
 			task.Status = model.KbFileProcessStatusFailed
-			task.Error = err.Error()
+			task.Error = e.Error()
 			task.CompleteTime = time.Now()
 			if err := d.kbRepo.UpdateFileProcessTask(ctx, task); err != nil {
 				log.Println("handleTask err:", err)
@@ -310,7 +320,11 @@ func (d *DocumentProcessor) handleTask(ctx context.Context, taskId int64) {
 	if err := d.kbRepo.UpdateFileProcessTask(ctx, task); err != nil {
 		log.Println("handleTask err:", err)
 	}
+	if err := d.kbRepo.UpdateFileChunks(ctx, file.Id, len(chunks)); err != nil {
+		log.Println("handleTask err:", err)
+	}
 	if err := d.kbRepo.UpdateFileStatus(ctx, file.Id, model.KbFileProcessed); err != nil {
 		log.Println("handleTask err:", err)
 	}
+	return len(chunks), nil
 }
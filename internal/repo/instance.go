@@ -7,6 +7,7 @@ import (
 	"github.com/StellrisJAY/workflow-ai/internal/common"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"gorm.io/gorm"
+	"time"
 )
 
 type InstanceRepo struct {
@@ -21,6 +22,15 @@ func (i *InstanceRepo) InsertNodeInstance(ctx context.Context, nodeInstance *mod
 	return i.DB(ctx).Table(nodeInstance.TableName()).WithContext(ctx).Create(nodeInstance).Error
 }
 
+// InsertNodeInstanceOnce 创建节点实例，节点在流程实例中已经存在实例时不会重复创建，返回是否创建成功
+func (i *InstanceRepo) InsertNodeInstanceOnce(ctx context.Context, nodeInstance *model.NodeInstance) (bool, error) {
+	err := i.InsertNodeInstance(ctx, nodeInstance)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (i *InstanceRepo) InsertWorkflowInstance(ctx context.Context, workflowInstance *model.WorkflowInstance) error {
 	return i.DB(ctx).Table(workflowInstance.TableName()).WithContext(ctx).Create(workflowInstance).Error
 }
@@ -65,11 +75,23 @@ func (i *InstanceRepo) GetWorkflowInstanceStatus(ctx context.Context, id int64)
 	return workflowInstance, err
 }
 
+func (i *InstanceRepo) GetWorkflowTemplateId(ctx context.Context, id int64) (int64, error) {
+	var templateId int64
+	err := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).
+		Select("template_id").
+		WithContext(ctx).
+		Where("id =?", id).
+		Scan(&templateId).
+		Error
+	return templateId, err
+}
+
 func (i *InstanceRepo) GetNodeInstanceByNodeId(ctx context.Context, workflowId int64, nodeId string) (*model.NodeInstance, error) {
 	var nodeInstance *model.NodeInstance
 	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
 		WithContext(ctx).
 		Where("workflow_id =? and node_id =?", workflowId, nodeId).
+		Where("parent_id = 0").
 		Scan(&nodeInstance).
 		Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -78,27 +100,77 @@ func (i *InstanceRepo) GetNodeInstanceByNodeId(ctx context.Context, workflowId i
 	return nodeInstance, err
 }
 
-func (i *InstanceRepo) UpdateNodeInstance(ctx context.Context, nodeInstance *model.NodeInstance) error {
-	return i.DB(ctx).Table(nodeInstance.TableName()).
+// FinishRunningNodeInstance 更新执行中节点的执行结果，节点已经被取消时不会更新，返回false
+func (i *InstanceRepo) FinishRunningNodeInstance(ctx context.Context, nodeInstance *model.NodeInstance) (bool, error) {
+	result := i.DB(ctx).Table(nodeInstance.TableName()).
 		WithContext(ctx).
 		Where("id=?", nodeInstance.Id).
+		Where("status = ?", model.NodeInstanceStatusRunning).
 		UpdateColumns(map[string]interface{}{
 			"status":        nodeInstance.Status,
 			"complete_time": nodeInstance.CompleteTime,
 			"output":        nodeInstance.Output,
 			"error":         nodeInstance.Error,
-		}).Error
+			"attempts":      nodeInstance.Attempts,
+		})
+	return result.RowsAffected > 0, result.Error
 }
 
-func (i *InstanceRepo) CountRunningNodeInstancesWithNodeIds(ctx context.Context, workflowId int64, nodeIds []string) (int64, error) {
-	var count int64
+func (i *InstanceRepo) InsertNodeExecution(ctx context.Context, execution *model.NodeExecution) error {
+	return i.DB(ctx).Table(execution.TableName()).WithContext(ctx).Create(execution).Error
+}
+
+func (i *InstanceRepo) ListNodeExecutions(ctx context.Context, nodeInstanceId int64) ([]*model.NodeExecution, error) {
+	var result []*model.NodeExecution
+	err := i.DB(ctx).Table(model.NodeExecution{}.TableName()).
+		WithContext(ctx).
+		Where("node_instance_id = ?", nodeInstanceId).
+		Order("attempt").
+		Find(&result).Error
+	return result, err
+}
+
+func (i *InstanceRepo) ListNodeInstancesByNodeIds(ctx context.Context, workflowId int64, nodeIds []string) ([]*model.NodeInstance, error) {
+	var result []*model.NodeInstance
 	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
 		WithContext(ctx).
 		Where("workflow_id =?", workflowId).
 		Where("node_id IN (?)", nodeIds).
-		Where("status = ?", model.NodeInstanceStatusRunning).
-		Count(&count).Error
-	return count, err
+		Where("parent_id = 0").
+		Find(&result).Error
+	return result, err
+}
+
+// ListIterationNodeInstances 查询迭代节点某一次迭代中的子节点实例
+func (i *InstanceRepo) ListIterationNodeInstances(ctx context.Context, parentId int64, iteration int,
+	nodeIds []string) ([]*model.NodeInstance, error) {
+	var result []*model.NodeInstance
+	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
+		WithContext(ctx).
+		Where("parent_id = ?", parentId).
+		Where("iteration = ?", iteration).
+		Where("node_id IN (?)", nodeIds).
+		Find(&result).Error
+	return result, err
+}
+
+func (i *InstanceRepo) ListChildNodeInstances(ctx context.Context, parentId int64) ([]*model.NodeInstance, error) {
+	var result []*model.NodeInstance
+	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
+		WithContext(ctx).
+		Where("parent_id = ?", parentId).
+		Order("iteration, add_time").
+		Find(&result).Error
+	return result, err
+}
+
+// DeleteUnfinishedChildNodeInstances 删除迭代节点中未完成的子节点实例，迭代节点重新执行时已完成的子节点不再执行
+func (i *InstanceRepo) DeleteUnfinishedChildNodeInstances(ctx context.Context, parentId int64) error {
+	return i.DB(ctx).Table(model.NodeInstance{}.TableName()).
+		WithContext(ctx).
+		Where("parent_id = ?", parentId).
+		Where("status <> ?", model.NodeInstanceStatusCompleted).
+		Delete(&model.NodeInstance{}).Error
 }
 
 func (i *InstanceRepo) CountCompletedNodeInstancesWithNodeIds(ctx context.Context, workflowId int64, nodeIds []string) (int64, error) {
@@ -121,6 +193,233 @@ func (i *InstanceRepo) UpdateWorkflowInstance(ctx context.Context, instance *mod
 		}).Error
 }
 
+// FinishWorkflowInstance 仅当流程实例处于运行中时更新为结束状态，返回是否更新成功
+func (i *InstanceRepo) FinishWorkflowInstance(ctx context.Context, instance *model.WorkflowInstance) (bool, error) {
+	result := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).WithContext(ctx).
+		Where("id=?", instance.Id).
+		Where("status=?", model.WorkflowInstanceStatusRunning).
+		UpdateColumns(map[string]interface{}{
+			"status":        instance.Status,
+			"complete_time": instance.CompleteTime,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// CancelNodeInstances 将流程实例中指定状态的节点实例修改为已取消
+func (i *InstanceRepo) CancelNodeInstances(ctx context.Context, workflowId int64, reason string,
+	statuses ...model.NodeInstanceStatus) error {
+	return i.DB(ctx).Table(model.NodeInstance{}.TableName()).WithContext(ctx).
+		Where("workflow_id=?", workflowId).
+		Where("status IN (?)", statuses).
+		UpdateColumns(map[string]interface{}{
+			"status":        model.NodeInstanceStatusCancelled,
+			"complete_time": time.Now(),
+			"error":         reason,
+		}).Error
+}
+
+// ClaimQueuedNodeInstance 领取一个运行中流程的排队节点实例，通过带状态条件的更新保证同一个节点实例只会被领取一次，
+// 领取的节点在lease时间内有效，执行期间需要续约。队列为空时返回nil
+func (i *InstanceRepo) ClaimQueuedNodeInstance(ctx context.Context, executor string,
+	lease time.Duration) (*model.NodeInstance, error) {
+	for {
+		var candidates []*model.NodeInstance
+		err := i.DB(ctx).Table(model.NodeInstance{}.TableName()+" ni").
+			Joins("JOIN wf_workflow_instance wi ON wi.id = ni.workflow_id").
+			Select("ni.*").
+			Where("ni.status = ?", model.NodeInstanceStatusQueued).
+			Where("ni.parent_id = 0").
+			Where("wi.status = ?", model.WorkflowInstanceStatusRunning).
+			Order("ni.add_time").
+			Limit(10).
+			WithContext(ctx).
+			Find(&candidates).Error
+		if err != nil || len(candidates) == 0 {
+			return nil, err
+		}
+		for _, candidate := range candidates {
+			claimedUntil := time.Now().Add(lease)
+			result := i.DB(ctx).Table(model.NodeInstance{}.TableName()).WithContext(ctx).
+				Where("id=?", candidate.Id).
+				Where("status=?", model.NodeInstanceStatusQueued).
+				UpdateColumns(map[string]interface{}{
+					"status":        model.NodeInstanceStatusRunning,
+					"executor":      executor,
+					"claimed_until": claimedUntil,
+				})
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			// 已被其他工作协程领取
+			if result.RowsAffected == 0 {
+				continue
+			}
+			candidate.Status = model.NodeInstanceStatusRunning
+			candidate.Executor = executor
+			candidate.ClaimedUntil = &claimedUntil
+			return candidate, nil
+		}
+	}
+}
+
+// RenewNodeInstanceClaim 延长executor领取的运行中节点实例的租约，节点已结束或被其他服务实例重新领取时返回false
+func (i *InstanceRepo) RenewNodeInstanceClaim(ctx context.Context, id int64, executor string,
+	lease time.Duration) (bool, error) {
+	result := i.DB(ctx).Table(model.NodeInstance{}.TableName()).WithContext(ctx).
+		Where("id=?", id).
+		Where("status=?", model.NodeInstanceStatusRunning).
+		Where("executor=?", executor).
+		UpdateColumn("claimed_until", time.Now().Add(lease))
+	return result.RowsAffected > 0, result.Error
+}
+
+// RequeueExpiredNodeInstances 将运行中流程里租约已过期的节点实例重新放回队列，领取节点的服务实例已经停止或失去响应，
+// 不区分是哪个服务实例领取的
+func (i *InstanceRepo) RequeueExpiredNodeInstances(ctx context.Context) (int64, error) {
+	runningWorkflows := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).
+		Select("id").
+		Where("status = ?", model.WorkflowInstanceStatusRunning)
+	result := i.DB(ctx).Table(model.NodeInstance{}.TableName()).WithContext(ctx).
+		Where("status = ?", model.NodeInstanceStatusRunning).
+		// 迭代子节点由迭代节点执行，迭代节点重新执行时会处理
+		Where("parent_id = 0").
+		// 升级前领取的节点实例没有租约
+		Where("(claimed_until IS NULL OR claimed_until < ?)", time.Now()).
+		Where("workflow_id IN (?)", runningWorkflows).
+		UpdateColumns(map[string]interface{}{
+			"status": model.NodeInstanceStatusQueued,
+		})
+	return result.RowsAffected, result.Error
+}
+
+// ResumeWorkflowInstance 将失败或已取消的流程实例恢复为运行中，并保存修改后的流程定义，返回是否更新成功
+func (i *InstanceRepo) ResumeWorkflowInstance(ctx context.Context, workflowId int64, data string) (bool, error) {
+	result := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).WithContext(ctx).
+		Where("id=?", workflowId).
+		Where("status IN (?)", []model.WorkflowInstanceStatus{model.WorkflowInstanceStatusFailed,
+			model.WorkflowInstanceStatusCancelled}).
+		UpdateColumns(map[string]interface{}{
+			"status":        model.WorkflowInstanceStatusRunning,
+			"data":          data,
+			"complete_time": time.Now(),
+			"revision":      gorm.Expr("revision + 1"),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// RequeueFailedNodeInstances 将失败、已取消和租约过期的节点实例重置为排队状态，已完成节点的输出保持不变
+func (i *InstanceRepo) RequeueFailedNodeInstances(ctx context.Context, workflowId int64) error {
+	return i.DB(ctx).Table(model.NodeInstance{}.TableName()).WithContext(ctx).
+		Where("workflow_id=?", workflowId).
+		Where("parent_id = 0").
+		Where("(status IN (?) OR (status = ? AND (claimed_until IS NULL OR claimed_until < ?)))",
+			[]model.NodeInstanceStatus{model.NodeInstanceStatusFailed, model.NodeInstanceStatusCancelled},
+			model.NodeInstanceStatusRunning, time.Now()).
+		UpdateColumns(map[string]interface{}{
+			"status":   model.NodeInstanceStatusQueued,
+			"output":   "{}",
+			"error":    "",
+			"executor": "",
+		}).Error
+}
+
+// GetLatestChildWorkflowInstance 查询父流程节点最近一次创建的子流程实例
+func (i *InstanceRepo) GetLatestChildWorkflowInstance(ctx context.Context, parentId int64,
+	parentNodeId string) (*model.WorkflowInstance, error) {
+	var result []*model.WorkflowInstance
+	err := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).
+		Where("parent_id = ?", parentId).
+		Where("parent_node_id = ?", parentNodeId).
+		Order("add_time DESC").
+		Limit(1).
+		WithContext(ctx).
+		Find(&result).Error
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+	return result[0], nil
+}
+
+func (i *InstanceRepo) ListRunningChildWorkflowIds(ctx context.Context, parentId int64) ([]int64, error) {
+	var ids []int64
+	err := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).
+		Select("id").
+		Where("parent_id = ?", parentId).
+		Where("status = ?", model.WorkflowInstanceStatusRunning).
+		WithContext(ctx).
+		Find(&ids).Error
+	return ids, err
+}
+
+// GetEndNodeOutput 查询流程实例中已完成的结束节点输出
+func (i *InstanceRepo) GetEndNodeOutput(ctx context.Context, workflowId int64) (string, error) {
+	var outputs []string
+	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
+		Select("output").
+		Where("workflow_id = ?", workflowId).
+		Where("parent_id = 0").
+		Where("type = ?", model.NodeTypeEnd).
+		Where("status = ?", model.NodeInstanceStatusCompleted).
+		Limit(1).
+		WithContext(ctx).
+		Find(&outputs).Error
+	if err != nil || len(outputs) == 0 {
+		return "{}", err
+	}
+	return outputs[0], nil
+}
+
+func (i *InstanceRepo) GetStartNodeOutput(ctx context.Context, workflowId int64) (string, error) {
+	var outputs []string
+	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
+		Select("output").
+		Where("workflow_id = ?", workflowId).
+		Where("parent_id = 0").
+		Where("type = ?", model.NodeTypeStart).
+		Limit(1).
+		WithContext(ctx).
+		Find(&outputs).Error
+	if err != nil || len(outputs) == 0 {
+		return "{}", err
+	}
+	return outputs[0], nil
+}
+
+// ListNotRunningWorkflowInstanceIds 查询ids中已经结束或被取消的流程实例
+func (i *InstanceRepo) ListNotRunningWorkflowInstanceIds(ctx context.Context, ids []int64) ([]int64, error) {
+	var result []int64
+	err := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).
+		Select("id").
+		Where("id IN ?", ids).
+		Where("status <> ?", model.WorkflowInstanceStatusRunning).
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
+func (i *InstanceRepo) ListRunningWorkflowInstanceIds(ctx context.Context) ([]int64, error) {
+	var ids []int64
+	err := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).
+		Select("id").
+		Where("status = ?", model.WorkflowInstanceStatusRunning).
+		WithContext(ctx).
+		Find(&ids).Error
+	return ids, err
+}
+
+// ListFinishedNodeInstances 查询已完成和已跳过的节点实例
+func (i *InstanceRepo) ListFinishedNodeInstances(ctx context.Context, workflowId int64) ([]*model.NodeInstance, error) {
+	var result []*model.NodeInstance
+	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
+		Where("workflow_id = ?", workflowId).
+		Where("parent_id = 0").
+		Where("status IN (?)", []model.NodeInstanceStatus{model.NodeInstanceStatusCompleted,
+			model.NodeInstanceStatusUnreached}).
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
 func (i *InstanceRepo) GetWorkflowDefinition(ctx context.Context, workflowId int64) (string, error) {
 	var definition string
 	err := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).
@@ -131,6 +430,17 @@ func (i *InstanceRepo) GetWorkflowDefinition(ctx context.Context, workflowId int
 	return definition, err
 }
 
+// GetWorkflowRevision 查询流程实例定义的版本，用于判断缓存的执行计划是否过期
+func (i *InstanceRepo) GetWorkflowRevision(ctx context.Context, workflowId int64) (int, error) {
+	var revision int
+	err := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()).
+		Select("revision").
+		WithContext(ctx).
+		Where("id =?", workflowId).
+		Scan(&revision).Error
+	return revision, err
+}
+
 func (i *InstanceRepo) ListWorkflowInstance(ctx context.Context, query model.WorkflowInstanceQuery) ([]*model.WorkflowInstanceListDTO, int, error) {
 	var result []*model.WorkflowInstanceListDTO
 	p := common.Pagination{
@@ -152,7 +462,8 @@ func (i *InstanceRepo) GetWorkflowInstanceDetail(ctx context.Context, workflowId
 	var result *model.WorkflowInstanceDetailDTO
 	err := i.DB(ctx).Table(model.WorkflowInstance{}.TableName()+" wi").
 		Joins("LEFT JOIN wf_template wt ON wt.id = wi.template_id").
-		Select("wi.id, wi.data, wi.template_id, wi.add_time, wi.complete_time, wi.status, wi.add_user, wt.name AS template_name").
+		Select("wi.id, wi.data, wi.template_id, wi.add_time, wi.complete_time, wi.status, wi.add_user, wi.parent_id, "+
+			"wi.parent_node_id, wi.callback_url, wt.name AS template_name").
 		Where("wi.id = ?", workflowId).
 		WithContext(ctx).
 		Find(&result).Error
@@ -162,9 +473,10 @@ func (i *InstanceRepo) GetWorkflowInstanceDetail(ctx context.Context, workflowId
 func (i *InstanceRepo) ListNodeInstanceStatus(ctx context.Context, workflowId int64) ([]*model.NodeStatusDTO, error) {
 	var result []*model.NodeStatusDTO
 	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
-		Select("id, node_id, status").
+		Select("id, node_id, status, attempts, claimed_until").
 		WithContext(ctx).
 		Where("workflow_id =?", workflowId).
+		Where("parent_id = 0").
 		Scan(&result).Error
 	return result, err
 }
@@ -186,8 +498,10 @@ func (i *InstanceRepo) GetConditionNodeBranch(ctx context.Context, workflowId in
 	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
 		Select("JSON_EXTRACT(output, \"$.successBranch\") AS branch, node_id").
 		Where("workflow_id =?", workflowId).
+		Where("parent_id = 0").
 		Where("status = ?", model.NodeInstanceStatusCompleted).
-		Where("type = ?", "condition").
+		// 条件节点和人工节点都会输出选中的分支
+		Where("JSON_EXTRACT(output, \"$.successBranch\") IS NOT NULL").
 		WithContext(ctx).
 		Find(&result).Error
 	return result, err
@@ -202,3 +516,146 @@ func (i *InstanceRepo) GetWorkflowTimeline(ctx context.Context, workflowId int64
 		Error
 	return result, err
 }
+
+func (i *InstanceRepo) InsertWorkflowEvent(ctx context.Context, event *model.WorkflowEvent) error {
+	return i.DB(ctx).WithContext(ctx).Create(event).Error
+}
+
+// ListWorkflowEvents 查询流程实例id大于afterId的事件
+func (i *InstanceRepo) ListWorkflowEvents(ctx context.Context, workflowId int64, afterId int64) ([]*model.WorkflowEvent, error) {
+	var result []*model.WorkflowEvent
+	err := i.DB(ctx).Table(model.WorkflowEvent{}.TableName()).
+		Where("workflow_id = ?", workflowId).
+		Where("id > ?", afterId).
+		Order("id ASC").
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
+func (i *InstanceRepo) InsertTokenUsages(ctx context.Context, usages []*model.TokenUsage) error {
+	return i.DB(ctx).WithContext(ctx).Create(usages).Error
+}
+
+const tokenUsageSummaryColumns = "COALESCE(SUM(tu.prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(tu.completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(SUM(tu.prompt_tokens + tu.completion_tokens), 0) AS total_tokens, " +
+	"COALESCE(SUM(tu.cost), 0) AS cost, COUNT(*) AS calls"
+
+func (i *InstanceRepo) GetWorkflowTokenUsage(ctx context.Context, workflowId int64) (*model.TokenUsageSummary, error) {
+	var result model.TokenUsageSummary
+	err := i.DB(ctx).Table(model.TokenUsage{}.TableName()+" tu").
+		Select(tokenUsageSummaryColumns).
+		Where("tu.workflow_id = ?", workflowId).
+		WithContext(ctx).
+		Scan(&result).Error
+	return &result, err
+}
+
+func (i *InstanceRepo) ListNodeTokenUsages(ctx context.Context, workflowId int64) ([]*model.NodeTokenUsageDTO, error) {
+	var result []*model.NodeTokenUsageDTO
+	err := i.DB(ctx).Table(model.TokenUsage{}.TableName()+" tu").
+		Select("tu.node_id, "+tokenUsageSummaryColumns).
+		Where("tu.workflow_id = ?", workflowId).
+		Group("tu.node_id").
+		WithContext(ctx).
+		Scan(&result).Error
+	return result, err
+}
+
+// UsageStatistics 按模板、日期或模型汇总时间范围内的token用量
+func (i *InstanceRepo) UsageStatistics(ctx context.Context, query *model.UsageStatisticsQuery) ([]*model.UsageStatisticsDTO, error) {
+	var result []*model.UsageStatisticsDTO
+	d := i.DB(ctx).Table(model.TokenUsage{}.TableName()+" tu").
+		Where("tu.add_time >= ?", query.Start).
+		Where("tu.add_time < ?", query.End).
+		WithContext(ctx)
+	switch query.GroupBy {
+	case model.UsageGroupByDay:
+		d = d.Select("DATE_FORMAT(tu.add_time, '%Y-%m-%d') AS `key`, DATE_FORMAT(tu.add_time, '%Y-%m-%d') AS name, " +
+			tokenUsageSummaryColumns).
+			Group("`key`").
+			Order("`key` ASC")
+	case model.UsageGroupByModel:
+		d = d.Select("CAST(tu.model_id AS CHAR) AS `key`, MAX(tu.model_name) AS name, " + tokenUsageSummaryColumns).
+			Group("tu.model_id").
+			Order("cost DESC")
+	default:
+		d = d.Joins("INNER JOIN wf_workflow_instance wi ON wi.id = tu.workflow_id").
+			Joins("LEFT JOIN wf_template wt ON wt.id = wi.template_id").
+			Select("CAST(wi.template_id AS CHAR) AS `key`, MAX(wt.name) AS name, " + tokenUsageSummaryColumns).
+			Group("wi.template_id").
+			Order("cost DESC")
+	}
+	err := d.Scan(&result).Error
+	return result, err
+}
+
+func (i *InstanceRepo) InsertCallbackDelivery(ctx context.Context, delivery *model.CallbackDelivery) error {
+	return i.DB(ctx).Table(delivery.TableName()).WithContext(ctx).Create(delivery).Error
+}
+
+// ListDueCallbackDeliveries 查询到达投递时间的回调
+func (i *InstanceRepo) ListDueCallbackDeliveries(ctx context.Context, now time.Time, limit int) ([]*model.CallbackDelivery, error) {
+	var result []*model.CallbackDelivery
+	err := i.DB(ctx).Table(model.CallbackDelivery{}.TableName()).
+		Where("next_attempt_time <= ?", now).
+		Order("next_attempt_time ASC").
+		Limit(limit).
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
+// ClaimCallbackDelivery 领取到达投递时间的回调，下一次投递时间推迟到until，投递的服务实例停止后由其他服务实例重新投递
+func (i *InstanceRepo) ClaimCallbackDelivery(ctx context.Context, id int64, now time.Time, until time.Time) (bool, error) {
+	result := i.DB(ctx).Table(model.CallbackDelivery{}.TableName()).
+		Where("id = ?", id).
+		Where("next_attempt_time <= ?", now).
+		WithContext(ctx).
+		UpdateColumn("next_attempt_time", until)
+	return result.RowsAffected == 1, result.Error
+}
+
+// UpdateCallbackDelivery 记录回调的投递结果
+func (i *InstanceRepo) UpdateCallbackDelivery(ctx context.Context, delivery *model.CallbackDelivery) error {
+	return i.DB(ctx).Table(delivery.TableName()).
+		Where("id = ?", delivery.Id).
+		WithContext(ctx).
+		UpdateColumns(map[string]interface{}{
+			"status_code":       delivery.StatusCode,
+			"success":           delivery.Success,
+			"error":             delivery.Error,
+			"duration":          delivery.Duration,
+			"next_attempt_time": delivery.NextAttemptTime,
+			"add_time":          delivery.AddTime,
+		}).Error
+}
+
+func (i *InstanceRepo) ListCallbackDeliveries(ctx context.Context, workflowId int64) ([]*model.CallbackDelivery, error) {
+	var result []*model.CallbackDelivery
+	err := i.DB(ctx).Table(model.CallbackDelivery{}.TableName()).
+		Where("workflow_id = ?", workflowId).
+		Order("add_time ASC, attempt ASC").
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
+// GetFailedNodeError 查询流程实例中最先失败的节点的错误信息
+func (i *InstanceRepo) GetFailedNodeError(ctx context.Context, workflowId int64) (string, error) {
+	var errs []string
+	err := i.DB(ctx).Table(model.NodeInstance{}.TableName()).
+		Select("error").
+		Where("workflow_id = ?", workflowId).
+		Where("parent_id = 0").
+		Where("status = ?", model.NodeInstanceStatusFailed).
+		Order("complete_time ASC").
+		Limit(1).
+		WithContext(ctx).
+		Find(&errs).Error
+	if err != nil || len(errs) == 0 {
+		return "", err
+	}
+	return errs[0], nil
+}
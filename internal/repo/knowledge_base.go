@@ -170,6 +170,13 @@ func (k *KnowledgeBaseRepo) UpdateFileStatus(ctx context.Context, fileId int64,
 		}).Error
 }
 
+func (k *KnowledgeBaseRepo) UpdateFileChunks(ctx context.Context, fileId int64, chunks int) error {
+	return k.DB(ctx).Table(model.KnowledgeBaseFile{}.TableName()).Where("id = ?", fileId).
+		UpdateColumns(map[string]interface{}{
+			"chunks": chunks,
+		}).Error
+}
+
 func (k *KnowledgeBaseRepo) GetFileProcessTaskByFileId(ctx context.Context, fileId int64) (*model.KbFileProcessTask, error) {
 	var task *model.KbFileProcessTask
 	if err := k.DB(ctx).Table(model.KbFileProcessTask{}.TableName()).
@@ -67,6 +67,14 @@ func (m *ProviderRepo) GetProviderModel(ctx context.Context, id int64) (*model.P
 	return result, err
 }
 
+func (m *ProviderRepo) UpdateModelPrice(ctx context.Context, request *model.UpdateModelPriceRequest) error {
+	return m.DB(ctx).Table(model.ProviderModel{}.TableName()).Where("id = ?", request.Id).
+		UpdateColumns(map[string]interface{}{
+			"input_price":  request.InputPrice,
+			"output_price": request.OutputPrice,
+		}).Error
+}
+
 func (m *ProviderRepo) GetProvider(ctx context.Context, id int64) (*model.Provider, error) {
 	var result *model.Provider
 	err := m.DB(ctx).Table(model.Provider{}.TableName()).Where("id=?", id).First(&result).Error
@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"fmt"
 	"github.com/StellrisJAY/workflow-ai/internal/config"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"golang.org/x/net/context"
@@ -17,7 +18,8 @@ type Repository struct {
 
 func NewRepository(conf *config.Config) (*Repository, error) {
 	db, err := gorm.Open(mysql.Open(conf.Database.Url), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger:         logger.Default.LogMode(logger.Info),
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, err
@@ -51,12 +53,40 @@ func (r *Repository) MigrateDB() {
 	migrator := r.db.Migrator()
 	tables := []any{&model.Provider{}, &model.ProviderModel{}, &model.Template{}, &model.WorkflowInstance{}, &model.NodeInstance{},
 		&model.KnowledgeBase{}, &model.KnowledgeBaseFile{}, &model.User{}, &model.KbFileProcessTask{}, &model.KbFileChunk{},
-		&model.File{}}
+		&model.File{}, &model.NodeExecution{}, &model.HumanTask{},
+		&model.WorkflowEvent{}, &model.TokenUsage{}, &model.WorkflowSchedule{}, &model.WorkflowWebhook{}, &model.CallbackDelivery{},
+		&model.Secret{}}
+	r.dedupNodeInstances()
 	for _, table := range tables {
 		if !migrator.HasTable(table) {
 			if err := migrator.CreateTable(table); err != nil {
 				panic(err)
 			}
+			continue
 		}
+		// 已存在的表自动添加新增的字段和索引
+		if err := migrator.AutoMigrate(table); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// dedupNodeInstances 创建节点实例唯一索引前清理重复调度产生的节点实例，每组重复记录只保留一条：
+// 优先保留已完成的记录，后续节点需要读取它的输出，状态相同时保留id最大的记录
+func (r *Repository) dedupNodeInstances() {
+	migrator := r.db.Migrator()
+	if !migrator.HasTable(&model.NodeInstance{}) || migrator.HasIndex(&model.NodeInstance{}, "uk_node_instance") {
+		return
+	}
+	cond := "a.workflow_id = b.workflow_id AND a.node_id = b.node_id"
+	if migrator.HasColumn(&model.NodeInstance{}, "parent_id") && migrator.HasColumn(&model.NodeInstance{}, "iteration") {
+		cond += " AND a.parent_id = b.parent_id AND a.iteration = b.iteration"
+	}
+	// b比a更应该保留：b已完成而a未完成，或两者完成状态相同且b的id更大
+	sql := "DELETE a FROM wf_node_instance a JOIN wf_node_instance b ON " + cond +
+		" AND ((b.status = ? AND a.status <> ?) OR ((b.status = ?) = (a.status = ?) AND a.id < b.id))"
+	completed := model.NodeInstanceStatusCompleted
+	if err := r.db.Exec(sql, completed, completed, completed, completed).Error; err != nil {
+		panic(fmt.Errorf("清理重复的节点实例失败，请手动删除wf_node_instance中重复的(workflow_id, node_id)记录后重试: %w", err))
 	}
 }
@@ -0,0 +1,110 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+type ScheduleRepo struct {
+	*Repository
+}
+
+func NewScheduleRepo(repo *Repository) *ScheduleRepo {
+	return &ScheduleRepo{repo}
+}
+
+func (s *ScheduleRepo) Insert(ctx context.Context, schedule *model.WorkflowSchedule) error {
+	return s.DB(ctx).Table(schedule.TableName()).WithContext(ctx).Create(schedule).Error
+}
+
+func (s *ScheduleRepo) Get(ctx context.Context, templateId, id int64) (*model.WorkflowSchedule, error) {
+	var result *model.WorkflowSchedule
+	err := s.DB(ctx).Table(model.WorkflowSchedule{}.TableName()).
+		Where("id = ? AND template_id = ?", id, templateId).
+		WithContext(ctx).
+		First(&result).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (s *ScheduleRepo) ListByTemplate(ctx context.Context, templateId int64) ([]*model.WorkflowSchedule, error) {
+	var result []*model.WorkflowSchedule
+	err := s.DB(ctx).Table(model.WorkflowSchedule{}.TableName()).
+		Where("template_id = ?", templateId).
+		Order("add_time DESC").
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
+func (s *ScheduleRepo) Update(ctx context.Context, schedule *model.WorkflowSchedule) error {
+	return s.DB(ctx).Table(schedule.TableName()).
+		Where("id = ? AND template_id = ?", schedule.Id, schedule.TemplateId).
+		WithContext(ctx).
+		UpdateColumns(map[string]interface{}{
+			"name":           schedule.Name,
+			"cron_expr":      schedule.CronExpr,
+			"timezone":       schedule.Timezone,
+			"inputs":         schedule.Inputs,
+			"enabled":        schedule.Enabled,
+			"next_fire_time": schedule.NextFireTime,
+		}).Error
+}
+
+func (s *ScheduleRepo) Delete(ctx context.Context, templateId, id int64) error {
+	return s.DB(ctx).Table(model.WorkflowSchedule{}.TableName()).
+		Where("id = ? AND template_id = ?", id, templateId).
+		WithContext(ctx).
+		Delete(&model.WorkflowSchedule{}).Error
+}
+
+func (s *ScheduleRepo) DeleteByTemplate(ctx context.Context, templateId int64) error {
+	return s.DB(ctx).Table(model.WorkflowSchedule{}.TableName()).
+		Where("template_id = ?", templateId).
+		WithContext(ctx).
+		Delete(&model.WorkflowSchedule{}).Error
+}
+
+// ListDueSchedules 查询已经到达执行时间的定时触发
+func (s *ScheduleRepo) ListDueSchedules(ctx context.Context, now time.Time, limit int) ([]*model.WorkflowSchedule, error) {
+	var result []*model.WorkflowSchedule
+	err := s.DB(ctx).Table(model.WorkflowSchedule{}.TableName()).
+		Where("enabled = ?", true).
+		Where("next_fire_time <= ?", now).
+		Order("next_fire_time").
+		Limit(limit).
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
+// ClaimSchedule 领取一次定时触发，只有下一次执行时间没有被其他服务实例修改时才能领取成功，保证多个服务实例不会重复触发
+func (s *ScheduleRepo) ClaimSchedule(ctx context.Context, schedule *model.WorkflowSchedule, fireTime time.Time,
+	nextFireTime *time.Time) (bool, error) {
+	result := s.DB(ctx).Table(schedule.TableName()).
+		Where("id = ?", schedule.Id).
+		Where("enabled = ?", true).
+		Where("next_fire_time = ?", schedule.NextFireTime).
+		WithContext(ctx).
+		UpdateColumns(map[string]interface{}{
+			"last_fire_time": fireTime,
+			"next_fire_time": nextFireTime,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// UpdateFireResult 记录定时触发启动的流程实例或启动失败的原因
+func (s *ScheduleRepo) UpdateFireResult(ctx context.Context, id int64, workflowId int64, errMsg string) error {
+	return s.DB(ctx).Table(model.WorkflowSchedule{}.TableName()).
+		Where("id = ?", id).
+		WithContext(ctx).
+		UpdateColumns(map[string]interface{}{
+			"last_workflow_id": workflowId,
+			"last_error":       errMsg,
+		}).Error
+}
@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"gorm.io/gorm"
+)
+
+type SecretRepo struct {
+	*Repository
+}
+
+func NewSecretRepo(repo *Repository) *SecretRepo {
+	return &SecretRepo{repo}
+}
+
+func (s *SecretRepo) Insert(ctx context.Context, secret *model.Secret) error {
+	return s.DB(ctx).Table(secret.TableName()).WithContext(ctx).Create(secret).Error
+}
+
+func (s *SecretRepo) Get(ctx context.Context, id int64) (*model.Secret, error) {
+	var result *model.Secret
+	err := s.DB(ctx).Table(model.Secret{}.TableName()).
+		Where("id = ?", id).
+		WithContext(ctx).
+		First(&result).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (s *SecretRepo) GetByName(ctx context.Context, templateId int64, kind model.SecretKind, name string) (*model.Secret, error) {
+	var result *model.Secret
+	err := s.DB(ctx).Table(model.Secret{}.TableName()).
+		Where("template_id = ? AND kind = ? AND name = ?", templateId, kind, name).
+		WithContext(ctx).
+		First(&result).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return result, err
+}
+
+// List 查询一个作用域内的变量，templateId为0时查询全局变量
+func (s *SecretRepo) List(ctx context.Context, templateId int64) ([]*model.Secret, error) {
+	var result []*model.Secret
+	err := s.DB(ctx).Table(model.Secret{}.TableName()).
+		Where("template_id = ?", templateId).
+		Order("kind, name").
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
+// ListAvailable 查询模板可以使用的变量，包括全局变量和模板内的变量
+func (s *SecretRepo) ListAvailable(ctx context.Context, templateId int64) ([]*model.Secret, error) {
+	var result []*model.Secret
+	err := s.DB(ctx).Table(model.Secret{}.TableName()).
+		Where("template_id IN ?", []int64{0, templateId}).
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
+func (s *SecretRepo) Update(ctx context.Context, secret *model.Secret) error {
+	return s.DB(ctx).Table(secret.TableName()).
+		Where("id = ?", secret.Id).
+		WithContext(ctx).
+		UpdateColumns(map[string]interface{}{
+			"value":       secret.Value,
+			"description": secret.Description,
+			"update_time": secret.UpdateTime,
+		}).Error
+}
+
+func (s *SecretRepo) Delete(ctx context.Context, id int64) error {
+	return s.DB(ctx).Table(model.Secret{}.TableName()).
+		Where("id = ?", id).
+		WithContext(ctx).
+		Delete(&model.Secret{}).Error
+}
+
+func (s *SecretRepo) DeleteByTemplate(ctx context.Context, templateId int64) error {
+	return s.DB(ctx).Table(model.Secret{}.TableName()).
+		Where("template_id = ?", templateId).
+		WithContext(ctx).
+		Delete(&model.Secret{}).Error
+}
@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"github.com/StellrisJAY/workflow-ai/internal/common"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+func (i *InstanceRepo) InsertHumanTask(ctx context.Context, task *model.HumanTask) error {
+	return i.DB(ctx).Table(task.TableName()).WithContext(ctx).Create(task).Error
+}
+
+func (i *InstanceRepo) GetHumanTask(ctx context.Context, id int64) (*model.HumanTask, error) {
+	var task *model.HumanTask
+	err := i.DB(ctx).Table(model.HumanTask{}.TableName()).
+		WithContext(ctx).
+		Where("id = ?", id).
+		Scan(&task).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return task, err
+}
+
+// ListPendingHumanTasks 查询待处理的人工任务
+func (i *InstanceRepo) ListPendingHumanTasks(ctx context.Context, query model.HumanTaskQuery) ([]*model.HumanTaskListDTO, int, error) {
+	var result []*model.HumanTaskListDTO
+	p := common.Pagination{
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    0,
+		Paged:    query.Paged,
+	}
+	db := i.DB(ctx).Table(model.HumanTask{}.TableName()+" ht").
+		Joins("LEFT JOIN wf_workflow_instance wi ON wi.id = ht.workflow_id").
+		Joins("LEFT JOIN wf_template wt ON wt.id = wi.template_id").
+		Where("ht.status = ?", model.HumanTaskStatusPending)
+	if query.WorkflowId != 0 {
+		db = db.Where("ht.workflow_id = ?", query.WorkflowId)
+	}
+	err := db.Scopes(common.WithPagination(&p)).
+		Select("ht.id, ht.workflow_id, ht.node_id, ht.title, ht.description, ht.form_fields, ht.form_values, " +
+			"ht.status, ht.add_time, wt.name AS template_name").
+		Order("ht.add_time").
+		WithContext(ctx).
+		Scan(&result).Error
+	return result, p.Total, err
+}
+
+// FinishHumanTask 仅当任务待处理时更新处理结果，返回是否更新成功
+func (i *InstanceRepo) FinishHumanTask(ctx context.Context, task *model.HumanTask) (bool, error) {
+	result := i.DB(ctx).Table(model.HumanTask{}.TableName()).WithContext(ctx).
+		Where("id = ?", task.Id).
+		Where("status = ?", model.HumanTaskStatusPending).
+		UpdateColumns(map[string]interface{}{
+			"status":        task.Status,
+			"form_values":   task.Values,
+			"comment":       task.Comment,
+			"complete_time": task.CompleteTime,
+			"complete_user": task.CompleteUser,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// CancelHumanTasks 流程取消或失败时取消待处理的人工任务
+func (i *InstanceRepo) CancelHumanTasks(ctx context.Context, workflowId int64) error {
+	return i.DB(ctx).Table(model.HumanTask{}.TableName()).WithContext(ctx).
+		Where("workflow_id = ?", workflowId).
+		Where("status = ?", model.HumanTaskStatusPending).
+		UpdateColumns(map[string]interface{}{
+			"status":        model.HumanTaskStatusCancelled,
+			"complete_time": time.Now(),
+		}).Error
+}
+
+// FinishWaitingNodeInstance 仅当节点实例处于等待状态时更新为完成或失败，返回是否更新成功
+func (i *InstanceRepo) FinishWaitingNodeInstance(ctx context.Context, nodeInstance *model.NodeInstance) (bool, error) {
+	result := i.DB(ctx).Table(model.NodeInstance{}.TableName()).WithContext(ctx).
+		Where("id = ?", nodeInstance.Id).
+		Where("status = ?", model.NodeInstanceStatusWaiting).
+		UpdateColumns(map[string]interface{}{
+			"status":        nodeInstance.Status,
+			"output":        nodeInstance.Output,
+			"error":         nodeInstance.Error,
+			"complete_time": nodeInstance.CompleteTime,
+		})
+	return result.RowsAffected > 0, result.Error
+}
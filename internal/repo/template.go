@@ -66,8 +66,9 @@ func (tr *TemplateRepo) Update(ctx context.Context, template *model.Template) er
 		WithContext(ctx).
 		Where("id = ?", template.Id).
 		UpdateColumns(map[string]interface{}{
-			"name":        template.Name,
-			"description": template.Description,
-			"data":        template.Data,
+			"name":         template.Name,
+			"description":  template.Description,
+			"data":         template.Data,
+			"callback_url": template.CallbackUrl,
 		}).Error
 }
@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"gorm.io/gorm"
+)
+
+type WebhookRepo struct {
+	*Repository
+}
+
+func NewWebhookRepo(repo *Repository) *WebhookRepo {
+	return &WebhookRepo{repo}
+}
+
+func (w *WebhookRepo) Insert(ctx context.Context, webhook *model.WorkflowWebhook) error {
+	return w.DB(ctx).Table(webhook.TableName()).WithContext(ctx).Create(webhook).Error
+}
+
+func (w *WebhookRepo) Get(ctx context.Context, templateId, id int64) (*model.WorkflowWebhook, error) {
+	var result *model.WorkflowWebhook
+	err := w.DB(ctx).Table(model.WorkflowWebhook{}.TableName()).
+		Where("id = ? AND template_id = ?", id, templateId).
+		WithContext(ctx).
+		First(&result).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (w *WebhookRepo) GetByToken(ctx context.Context, token string) (*model.WorkflowWebhook, error) {
+	var result *model.WorkflowWebhook
+	err := w.DB(ctx).Table(model.WorkflowWebhook{}.TableName()).
+		Where("token = ?", token).
+		WithContext(ctx).
+		First(&result).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (w *WebhookRepo) ListByTemplate(ctx context.Context, templateId int64) ([]*model.WorkflowWebhook, error) {
+	var result []*model.WorkflowWebhook
+	err := w.DB(ctx).Table(model.WorkflowWebhook{}.TableName()).
+		Where("template_id = ?", templateId).
+		Order("add_time DESC").
+		WithContext(ctx).
+		Find(&result).Error
+	return result, err
+}
+
+func (w *WebhookRepo) Update(ctx context.Context, webhook *model.WorkflowWebhook) error {
+	return w.DB(ctx).Table(webhook.TableName()).
+		Where("id = ? AND template_id = ?", webhook.Id, webhook.TemplateId).
+		WithContext(ctx).
+		UpdateColumns(map[string]interface{}{
+			"name":          webhook.Name,
+			"secret":        webhook.Secret,
+			"mappings":      webhook.Mappings,
+			"response_mode": webhook.ResponseMode,
+			"timeout":       webhook.Timeout,
+			"enabled":       webhook.Enabled,
+		}).Error
+}
+
+func (w *WebhookRepo) Delete(ctx context.Context, templateId, id int64) error {
+	return w.DB(ctx).Table(model.WorkflowWebhook{}.TableName()).
+		Where("id = ? AND template_id = ?", id, templateId).
+		WithContext(ctx).
+		Delete(&model.WorkflowWebhook{}).Error
+}
+
+func (w *WebhookRepo) DeleteByTemplate(ctx context.Context, templateId int64) error {
+	return w.DB(ctx).Table(model.WorkflowWebhook{}.TableName()).
+		Where("template_id = ?", templateId).
+		WithContext(ctx).
+		Delete(&model.WorkflowWebhook{}).Error
+}
@@ -61,6 +61,21 @@ func (s *ProviderService) ListProviderModels(ctx context.Context, query *model.P
 	return s.repo.GetProviderModelList(ctx, query)
 }
 
+// UpdateModelPrice 修改模型价格，只影响之后的调用费用
+func (s *ProviderService) UpdateModelPrice(ctx context.Context, request *model.UpdateModelPriceRequest) error {
+	if request.InputPrice < 0 || request.OutputPrice < 0 {
+		return errors.New("价格不能为负数")
+	}
+	pm, err := s.repo.GetProviderModel(ctx, request.Id)
+	if err != nil {
+		return err
+	}
+	if pm == nil {
+		return errors.New("model not found")
+	}
+	return s.repo.UpdateModelPrice(ctx, request)
+}
+
 func (s *ProviderService) ListProviders(ctx context.Context) ([]*model.ProviderListDTO, error) {
 	return s.repo.GetProviderList(ctx)
 }
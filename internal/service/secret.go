@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/common"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/StellrisJAY/workflow-ai/internal/repo"
+	"github.com/bwmarrin/snowflake"
+	"regexp"
+	"time"
+)
+
+// secretNamePattern 变量名只能包含字母、数字和下划线，不能以数字开头
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z_]\w{0,63}$`)
+
+type SecretService struct {
+	repo         *repo.SecretRepo
+	templateRepo *repo.TemplateRepo
+	cipher       *common.SecretCipher
+	snowflake    *snowflake.Node
+}
+
+func NewSecretService(repo *repo.SecretRepo, templateRepo *repo.TemplateRepo, cipher *common.SecretCipher,
+	snowflake *snowflake.Node) *SecretService {
+	return &SecretService{repo: repo, templateRepo: templateRepo, cipher: cipher, snowflake: snowflake}
+}
+
+// List 查询全局或模板内的环境变量和密钥，密钥的值不会返回
+func (s *SecretService) List(ctx context.Context, templateId int64) ([]*model.Secret, error) {
+	list, err := s.repo.List(ctx, templateId)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range list {
+		if secret.Kind == model.SecretKindSecret {
+			secret.Value = ""
+		}
+	}
+	return list, nil
+}
+
+func (s *SecretService) Create(ctx context.Context, request *model.SecretRequest) (int64, error) {
+	if request.Kind != model.SecretKindEnv && request.Kind != model.SecretKindSecret {
+		return 0, fmt.Errorf("不支持的变量类型: %s", request.Kind)
+	}
+	if !secretNamePattern.MatchString(request.Name) {
+		return 0, errors.New("变量名只能包含字母、数字和下划线，且不能以数字开头")
+	}
+	if request.TemplateId != 0 {
+		template, err := s.templateRepo.GetDetail(ctx, request.TemplateId)
+		if err != nil {
+			return 0, err
+		}
+		if template == nil {
+			return 0, errors.New("模板不存在")
+		}
+	}
+	existing, err := s.repo.GetByName(ctx, request.TemplateId, request.Kind, request.Name)
+	if err != nil {
+		return 0, err
+	}
+	if existing != nil {
+		return 0, fmt.Errorf("变量已存在: %s.%s", request.Kind, request.Name)
+	}
+	secret := &model.Secret{
+		Id:          s.snowflake.Generate().Int64(),
+		TemplateId:  request.TemplateId,
+		Kind:        request.Kind,
+		Name:        request.Name,
+		Description: request.Description,
+		AddTime:     time.Now(),
+		UpdateTime:  time.Now(),
+	}
+	if secret.Value, err = s.encodeValue(secret.Kind, request.Value); err != nil {
+		return 0, err
+	}
+	if err := s.repo.Insert(ctx, secret); err != nil {
+		return 0, err
+	}
+	return secret.Id, nil
+}
+
+// Update 修改变量的值和描述，变量名、类型和作用域不能修改。密钥的value为空时保留原来的值
+func (s *SecretService) Update(ctx context.Context, id int64, request *model.SecretRequest) error {
+	secret, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return errors.New("变量不存在")
+	}
+	if secret.Kind == model.SecretKindEnv || request.Value != "" {
+		if secret.Value, err = s.encodeValue(secret.Kind, request.Value); err != nil {
+			return err
+		}
+	}
+	secret.Description = request.Description
+	secret.UpdateTime = time.Now()
+	return s.repo.Update(ctx, secret)
+}
+
+func (s *SecretService) Delete(ctx context.Context, id int64) error {
+	return s.repo.Delete(ctx, id)
+}
+
+// encodeValue 密钥加密后保存，环境变量保存明文
+func (s *SecretService) encodeValue(kind model.SecretKind, value string) (string, error) {
+	if kind != model.SecretKindSecret {
+		return value, nil
+	}
+	if !s.cipher.Enabled() {
+		return "", common.ErrSecretKeyNotConfigured
+	}
+	if value == "" {
+		return "", errors.New("密钥的值不能为空")
+	}
+	return s.cipher.Encrypt(value)
+}
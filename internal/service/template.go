@@ -4,23 +4,36 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"github.com/StellrisJAY/workflow-ai/internal/repo"
+	"github.com/StellrisJAY/workflow-ai/internal/workflow"
 	"github.com/bwmarrin/snowflake"
 	"slices"
 	"time"
 )
 
 type TemplateService struct {
-	repo      *repo.TemplateRepo
-	snowflake *snowflake.Node
+	repo         *repo.TemplateRepo
+	scheduleRepo *repo.ScheduleRepo
+	webhookRepo  *repo.WebhookRepo
+	secretRepo   *repo.SecretRepo
+	snowflake    *snowflake.Node
 }
 
-func NewTemplateService(repo *repo.TemplateRepo, snowflake *snowflake.Node) *TemplateService {
-	return &TemplateService{repo: repo, snowflake: snowflake}
+func NewTemplateService(repo *repo.TemplateRepo, scheduleRepo *repo.ScheduleRepo, webhookRepo *repo.WebhookRepo,
+	secretRepo *repo.SecretRepo, snowflake *snowflake.Node) *TemplateService {
+	return &TemplateService{repo: repo, scheduleRepo: scheduleRepo, webhookRepo: webhookRepo, secretRepo: secretRepo,
+		snowflake: snowflake}
 }
 
 func (t *TemplateService) Insert(ctx context.Context, template *model.Template) (int64, error) {
+	if err := checkTemplateData(template.Data); err != nil {
+		return 0, err
+	}
+	if err := validateCallbackUrl(template.CallbackUrl); err != nil {
+		return 0, err
+	}
 	template.Id = t.snowflake.Generate().Int64()
 	template.AddTime = time.Now()
 	template.AddUser = 1
@@ -46,13 +59,111 @@ func (t *TemplateService) List(ctx context.Context, query *model.TemplateQuery)
 }
 
 func (t *TemplateService) Delete(ctx context.Context, id int64) error {
+	if err := t.scheduleRepo.DeleteByTemplate(ctx, id); err != nil {
+		return err
+	}
+	if err := t.webhookRepo.DeleteByTemplate(ctx, id); err != nil {
+		return err
+	}
+	if err := t.secretRepo.DeleteByTemplate(ctx, id); err != nil {
+		return err
+	}
 	return t.repo.Delete(ctx, id)
 }
 
+func (t *TemplateService) ListSchedules(ctx context.Context, templateId int64) ([]*model.WorkflowSchedule, error) {
+	return t.scheduleRepo.ListByTemplate(ctx, templateId)
+}
+
+func (t *TemplateService) CreateSchedule(ctx context.Context, templateId int64,
+	request *model.WorkflowScheduleRequest) (int64, error) {
+	schedule := &model.WorkflowSchedule{
+		Id:         t.snowflake.Generate().Int64(),
+		TemplateId: templateId,
+		AddTime:    time.Now(),
+		AddUser:    1,
+	}
+	if err := t.applyScheduleRequest(ctx, schedule, request); err != nil {
+		return 0, err
+	}
+	if err := t.scheduleRepo.Insert(ctx, schedule); err != nil {
+		return 0, err
+	}
+	return schedule.Id, nil
+}
+
+func (t *TemplateService) UpdateSchedule(ctx context.Context, templateId int64, id int64,
+	request *model.WorkflowScheduleRequest) error {
+	schedule, err := t.scheduleRepo.Get(ctx, templateId, id)
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		return errors.New("定时触发不存在")
+	}
+	if err := t.applyScheduleRequest(ctx, schedule, request); err != nil {
+		return err
+	}
+	return t.scheduleRepo.Update(ctx, schedule)
+}
+
+func (t *TemplateService) DeleteSchedule(ctx context.Context, templateId int64, id int64) error {
+	return t.scheduleRepo.Delete(ctx, templateId, id)
+}
+
+// applyScheduleRequest 校验cron表达式、时区和开始节点的必填变量，重新计算下一次执行时间
+func (t *TemplateService) applyScheduleRequest(ctx context.Context, schedule *model.WorkflowSchedule,
+	request *model.WorkflowScheduleRequest) error {
+	next, err := workflow.NextFireTime(request.CronExpr, request.Timezone, time.Now())
+	if err != nil {
+		return err
+	}
+	variables, err := t.GetStartInputVariables(ctx, schedule.TemplateId)
+	if err != nil {
+		return err
+	}
+	for _, variable := range variables {
+		if _, ok := request.Inputs[variable.Name]; !ok && variable.Required {
+			return fmt.Errorf("缺少必填变量: %s", variable.Name)
+		}
+	}
+	inputs, _ := json.Marshal(request.Inputs)
+	schedule.Name = request.Name
+	schedule.CronExpr = request.CronExpr
+	schedule.Timezone = request.Timezone
+	schedule.Inputs = string(inputs)
+	schedule.Enabled = request.Enabled
+	schedule.NextFireTime = nil
+	if request.Enabled {
+		schedule.NextFireTime = &next
+	}
+	return nil
+}
+
 func (t *TemplateService) Update(ctx context.Context, template *model.Template) error {
+	if err := checkTemplateData(template.Data); err != nil {
+		return err
+	}
+	if err := validateCallbackUrl(template.CallbackUrl); err != nil {
+		return err
+	}
 	return t.repo.Update(ctx, template)
 }
 
+// Validate 校验流程定义，返回每个节点的诊断信息
+func (t *TemplateService) Validate(_ context.Context, request *model.ValidateDefinitionRequest) *model.ValidateDefinitionResult {
+	return workflow.ValidateDefinitionJSON(request.Data)
+}
+
+// checkTemplateData 保存模板前校验流程定义，存在错误时不允许保存
+func checkTemplateData(data string) error {
+	result := workflow.ValidateDefinitionJSON(data)
+	if !result.Valid {
+		return &workflow.ValidationError{Diagnostics: result.Diagnostics}
+	}
+	return nil
+}
+
 func (t *TemplateService) GetStartInputVariables(ctx context.Context, id int64) ([]model.Input, error) {
 	detail, err := t.repo.GetDetail(ctx, id)
 	if err != nil {
@@ -75,6 +186,10 @@ func (t *TemplateService) GetStartInputVariables(ctx context.Context, id int64)
 func (t *TemplateService) GetNodePrototype(_ context.Context, nodeType model.NodeType) (string, error) {
 	var prototype *model.Node
 	switch nodeType {
+	case model.SystemVariableNodeId:
+		// 系统变量不是节点，返回可引用的变量列表
+		data, _ := json.Marshal(model.SystemVariablePrototype)
+		return string(data), nil
 	case model.NodeTypeCondition:
 		prototype = model.ConditionNodePrototype
 	case model.NodeTypeLLM:
@@ -93,6 +208,16 @@ func (t *TemplateService) GetNodePrototype(_ context.Context, nodeType model.Nod
 		prototype = model.ImageUnderstandingNodePrototype
 	case model.NodeTypeOCR:
 		prototype = model.OCRNodePrototype
+	case model.NodeTypeHumanTask:
+		prototype = model.HumanTaskNodePrototype
+	case model.NodeTypeIteration:
+		prototype = model.IterationNodePrototype
+	case model.NodeTypeSubWorkflow:
+		prototype = model.SubWorkflowNodePrototype
+	case model.NodeTypeKnowledgeWrite:
+		prototype = model.KbWriteNodePrototype
+	case model.NodeTypeCode:
+		prototype = model.CodeNodePrototype
 	case model.NodeTypeEnd:
 		prototype = model.EndNodePrototype
 	default:
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/common"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/StellrisJAY/workflow-ai/internal/repo"
+	"github.com/StellrisJAY/workflow-ai/internal/workflow"
+	"github.com/bwmarrin/snowflake"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout = 60  // 同步响应默认等待时间（秒）
+	maxWebhookTimeout     = 300 // 同步响应最长等待时间（秒）
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook不存在或已停用")
+	ErrInvalidSignature = errors.New("请求签名校验失败")
+)
+
+type WebhookService struct {
+	repo         *repo.WebhookRepo
+	templateRepo *repo.TemplateRepo
+	instanceRepo *repo.InstanceRepo
+	engine       *workflow.Engine
+	cipher       *common.SecretCipher
+	snowflake    *snowflake.Node
+}
+
+func NewWebhookService(repo *repo.WebhookRepo, templateRepo *repo.TemplateRepo, instanceRepo *repo.InstanceRepo,
+	engine *workflow.Engine, cipher *common.SecretCipher, snowflake *snowflake.Node) *WebhookService {
+	return &WebhookService{
+		repo:         repo,
+		templateRepo: templateRepo,
+		instanceRepo: instanceRepo,
+		engine:       engine,
+		cipher:       cipher,
+		snowflake:    snowflake,
+	}
+}
+
+// List 查询模板的webhook，签名密钥不会返回
+func (s *WebhookService) List(ctx context.Context, templateId int64) ([]*model.WorkflowWebhook, error) {
+	list, err := s.repo.ListByTemplate(ctx, templateId)
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range list {
+		webhook.HasSecret = webhook.Secret != ""
+	}
+	return list, nil
+}
+
+func (s *WebhookService) Create(ctx context.Context, templateId int64, request *model.WorkflowWebhookRequest) (*model.WorkflowWebhook, error) {
+	template, err := s.templateRepo.GetDetail(ctx, templateId)
+	if err != nil {
+		return nil, err
+	}
+	if template == nil {
+		return nil, errors.New("模板不存在")
+	}
+	token, err := generateWebhookToken()
+	if err != nil {
+		return nil, err
+	}
+	webhook := &model.WorkflowWebhook{
+		Id:         s.snowflake.Generate().Int64(),
+		TemplateId: templateId,
+		Token:      token,
+		AddTime:    time.Now(),
+		AddUser:    1,
+	}
+	if err := applyWebhookRequest(webhook, request); err != nil {
+		return nil, err
+	}
+	if err := s.applySecret(webhook, request); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Insert(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *WebhookService) Update(ctx context.Context, templateId int64, id int64, request *model.WorkflowWebhookRequest) error {
+	webhook, err := s.repo.Get(ctx, templateId, id)
+	if err != nil {
+		return err
+	}
+	if webhook == nil {
+		return errors.New("webhook不存在")
+	}
+	if err := applyWebhookRequest(webhook, request); err != nil {
+		return err
+	}
+	if err := s.applySecret(webhook, request); err != nil {
+		return err
+	}
+	return s.repo.Update(ctx, webhook)
+}
+
+// applySecret 加密保存签名密钥，secret为空时保留原来的密钥
+func (s *WebhookService) applySecret(webhook *model.WorkflowWebhook, request *model.WorkflowWebhookRequest) error {
+	switch {
+	case request.ClearSecret:
+		webhook.Secret = ""
+	case request.Secret != "":
+		secret, err := s.cipher.Encrypt(request.Secret)
+		if err != nil {
+			return err
+		}
+		webhook.Secret = secret
+	}
+	webhook.HasSecret = webhook.Secret != ""
+	return nil
+}
+
+func (s *WebhookService) Delete(ctx context.Context, templateId int64, id int64) error {
+	return s.repo.Delete(ctx, templateId, id)
+}
+
+// Trigger 处理外部系统的webhook请求，校验签名后把请求体映射为开始节点的输入变量并启动流程
+func (s *WebhookService) Trigger(ctx context.Context, token string, body []byte, signature string) (*model.WebhookTriggerResult, error) {
+	webhook, err := s.repo.GetByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if webhook == nil || !webhook.Enabled {
+		return nil, ErrWebhookNotFound
+	}
+	if webhook.Secret != "" {
+		secret, err := s.cipher.Decrypt(webhook.Secret)
+		if err != nil {
+			return nil, err
+		}
+		if !verifyWebhookSignature(secret, body, signature) {
+			return nil, ErrInvalidSignature
+		}
+	}
+	template, err := s.templateRepo.GetDetail(ctx, webhook.TemplateId)
+	if err != nil {
+		return nil, err
+	}
+	if template == nil {
+		return nil, errors.New("模板不存在")
+	}
+	inputs, err := mapWebhookInputs(webhook, template.Data, body)
+	if err != nil {
+		return nil, err
+	}
+	workflowId, err := s.engine.Start(ctx, template.Data, template.Id, webhook.AddUser, inputs, "")
+	if err != nil {
+		return nil, err
+	}
+	result := &model.WebhookTriggerResult{
+		WorkflowId: workflowId,
+		Status:     model.WorkflowInstanceStatusRunning,
+		StatusName: model.WorkflowInstanceStatusRunning.String(),
+	}
+	if webhook.ResponseMode != model.WebhookResponseModeSync {
+		return result, nil
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(webhook.Timeout)*time.Second)
+	defer cancel()
+	instance, err := s.engine.Wait(waitCtx, workflowId)
+	if err != nil {
+		// 等待超时时返回流程实例id，调用方可以查询流程实例的结果
+		if errors.Is(err, context.DeadlineExceeded) {
+			return result, nil
+		}
+		return nil, err
+	}
+	result.Status, result.StatusName = instance.Status, instance.Status.String()
+	if instance.Status == model.WorkflowInstanceStatusCompleted {
+		output, err := s.instanceRepo.GetEndNodeOutput(ctx, workflowId)
+		if err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal([]byte(output), &result.Output)
+	}
+	return result, nil
+}
+
+func applyWebhookRequest(webhook *model.WorkflowWebhook, request *model.WorkflowWebhookRequest) error {
+	switch request.ResponseMode {
+	case "":
+		request.ResponseMode = model.WebhookResponseModeAsync
+	case model.WebhookResponseModeAsync, model.WebhookResponseModeSync:
+	default:
+		return fmt.Errorf("不支持的响应方式: %s", request.ResponseMode)
+	}
+	timeout := request.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	if timeout > maxWebhookTimeout {
+		return fmt.Errorf("等待时间不能超过%d秒", maxWebhookTimeout)
+	}
+	for _, mapping := range request.Mappings {
+		if mapping.Variable == "" {
+			return errors.New("映射的变量名不能为空")
+		}
+		if _, _, err := workflow.EvalJSONPath(nil, mapping.Path); err != nil {
+			return err
+		}
+	}
+	mappings, _ := json.Marshal(request.Mappings)
+	webhook.Name = request.Name
+	webhook.Mappings = string(mappings)
+	webhook.ResponseMode = request.ResponseMode
+	webhook.Timeout = timeout
+	webhook.Enabled = request.Enabled
+	return nil
+}
+
+// mapWebhookInputs 按映射从请求体中取值，没有配置映射时使用请求体中与开始节点变量同名的字段
+func mapWebhookInputs(webhook *model.WorkflowWebhook, definitionJSON string, body []byte) (map[string]any, error) {
+	var payload any
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, errors.New("请求体必须是json")
+		}
+	}
+	var mappings []model.WebhookMapping
+	_ = json.Unmarshal([]byte(webhook.Mappings), &mappings)
+	if len(mappings) == 0 {
+		var definition model.WorkflowDefinition
+		_ = json.Unmarshal([]byte(definitionJSON), &definition)
+		for _, node := range definition.Nodes {
+			if node.Type != model.NodeTypeStart {
+				continue
+			}
+			for _, variable := range node.Data.Input {
+				mappings = append(mappings, model.WebhookMapping{Variable: variable.Name, Path: "$['" + variable.Name + "']"})
+			}
+		}
+	}
+	inputs := make(map[string]any)
+	for _, mapping := range mappings {
+		value, ok, err := workflow.EvalJSONPath(payload, mapping.Path)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			inputs[mapping.Variable] = value
+		}
+	}
+	return inputs, nil
+}
+
+// verifyWebhookSignature 校验请求体的HMAC-SHA256签名，签名为十六进制字符串，可以带有"sha256="前缀
+func verifyWebhookSignature(secret string, body []byte, signature string) bool {
+	signature = strings.TrimPrefix(strings.TrimSpace(signature), "sha256=")
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func generateWebhookToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
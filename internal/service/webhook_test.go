@@ -0,0 +1,128 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/StellrisJAY/workflow-ai/internal/common"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"reflect"
+	"testing"
+)
+
+func sign(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := `{"title":"hello"}`
+	tests := []struct {
+		name      string
+		secret    string
+		body      string
+		signature string
+		want      bool
+	}{
+		{"valid", "s3cret", body, sign("s3cret", body), true},
+		{"github prefix", "s3cret", body, "sha256=" + sign("s3cret", body), true},
+		{"surrounding spaces", "s3cret", body, " " + sign("s3cret", body) + " ", true},
+		{"wrong secret", "s3cret", body, sign("other", body), false},
+		{"tampered body", "s3cret", `{"title":"bye"}`, sign("s3cret", body), false},
+		{"missing signature", "s3cret", body, "", false},
+		{"prefix only", "s3cret", body, "sha256=", false},
+		{"not hex", "s3cret", body, "sha256=zz", false},
+		{"truncated", "s3cret", body, sign("s3cret", body)[:32], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyWebhookSignature(tt.secret, []byte(tt.body), tt.signature); got != tt.want {
+				t.Errorf("verifyWebhookSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapWebhookInputs(t *testing.T) {
+	definition, _ := json.Marshal(model.WorkflowDefinition{Nodes: []*model.Node{
+		{Id: "start", Type: model.NodeTypeStart, Data: model.NodeData{Input: []model.Input{{Name: "title"}, {Name: "count"}}}},
+		{Id: "end", Type: model.NodeTypeEnd, Data: model.NodeData{Input: []model.Input{{Name: "result"}}}},
+	}})
+	mappings := func(list ...model.WebhookMapping) string {
+		data, _ := json.Marshal(list)
+		return string(data)
+	}
+	tests := []struct {
+		name     string
+		mappings string
+		body     string
+		want     map[string]any
+		wantErr  bool
+	}{
+		{"same name fields", "", `{"title":"hello","count":2,"extra":true}`, map[string]any{"title": "hello", "count": float64(2)}, false},
+		{"missing field", "", `{"title":"hello"}`, map[string]any{"title": "hello"}, false},
+		{"empty body", "", "", map[string]any{}, false},
+		{"empty mappings", "[]", `{"title":"hello"}`, map[string]any{"title": "hello"}, false},
+		{"json path", mappings(
+			model.WebhookMapping{Variable: "title", Path: "$.issue.title"},
+			model.WebhookMapping{Variable: "labels", Path: "$.issue.labels[*].name"},
+			model.WebhookMapping{Variable: "first", Path: "$.issue.labels[0].name"},
+		), `{"issue":{"title":"bug","labels":[{"name":"a"},{"name":"b"}]}}`,
+			map[string]any{"title": "bug", "labels": []any{"a", "b"}, "first": "a"}, false},
+		{"mapped path missing", mappings(model.WebhookMapping{Variable: "title", Path: "$.issue.title"}), `{}`, map[string]any{}, false},
+		{"array body", mappings(model.WebhookMapping{Variable: "first", Path: "$[0]"}), `[1,2]`, map[string]any{"first": float64(1)}, false},
+		{"not json", "", "title=hello", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := &model.WorkflowWebhook{Mappings: tt.mappings}
+			got, err := mapWebhookInputs(webhook, string(definition), []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mapWebhookInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mapWebhookInputs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookApplySecret(t *testing.T) {
+	cipher, err := common.NewSecretCipher("test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &WebhookService{cipher: cipher}
+	webhook := &model.WorkflowWebhook{}
+	if err := s.applySecret(webhook, &model.WorkflowWebhookRequest{Secret: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Secret == "s3cret" || !webhook.HasSecret {
+		t.Fatalf("secret stored as %q, want ciphertext", webhook.Secret)
+	}
+	if plaintext, err := cipher.Decrypt(webhook.Secret); err != nil || plaintext != "s3cret" {
+		t.Errorf("Decrypt() = %q, %v", plaintext, err)
+	}
+	data, _ := json.Marshal(webhook)
+	var fields map[string]any
+	_ = json.Unmarshal(data, &fields)
+	if _, ok := fields["secret"]; ok || fields["hasSecret"] != true {
+		t.Errorf("webhook json = %s, want hasSecret without secret", data)
+	}
+
+	// 修改时密钥为空保留原来的密钥
+	encrypted := webhook.Secret
+	if err := s.applySecret(webhook, &model.WorkflowWebhookRequest{}); err != nil || webhook.Secret != encrypted {
+		t.Errorf("empty secret replaced the stored secret")
+	}
+	if err := s.applySecret(webhook, &model.WorkflowWebhookRequest{ClearSecret: true}); err != nil || webhook.Secret != "" || webhook.HasSecret {
+		t.Errorf("clearSecret did not remove the secret")
+	}
+
+	disabled := &WebhookService{cipher: &common.SecretCipher{}}
+	if err := disabled.applySecret(&model.WorkflowWebhook{}, &model.WorkflowWebhookRequest{Secret: "s3cret"}); err == nil {
+		t.Errorf("applySecret() without a secret key stored the secret")
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"github.com/StellrisJAY/workflow-ai/internal/repo"
 	"github.com/StellrisJAY/workflow-ai/internal/workflow"
+	"net/url"
 	"strings"
+	"time"
 )
 
 type WorkflowService struct {
@@ -37,21 +39,59 @@ func (w *WorkflowService) Start(ctx context.Context, request *model.StartWorkflo
 		}
 		definition = detail.Data
 	}
-	return w.engine.Start(ctx, definition, request.TemplateId, 1, request.Inputs, nil)
+	if err := validateCallbackUrl(request.CallbackUrl); err != nil {
+		return 0, err
+	}
+	return w.engine.Start(ctx, definition, request.TemplateId, 1, request.Inputs, request.CallbackUrl)
 }
 
-func (w *WorkflowService) StartWithMessageChan(ctx context.Context, request *model.StartWorkflowRequest,
-	msgChan chan model.WorkflowExecuteMessage) error {
-	detail, err := w.templateRepo.GetDetail(ctx, request.TemplateId)
-	if detail == nil {
-		return errors.New("template not found")
+// validateCallbackUrl 回调地址必须是http或https地址，为空表示不回调
+func validateCallbackUrl(callbackUrl string) error {
+	if callbackUrl == "" {
+		return nil
+	}
+	u, err := url.Parse(callbackUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("无效的回调地址")
 	}
+	return nil
+}
+
+func (w *WorkflowService) ListCallbackDeliveries(ctx context.Context, workflowId int64) ([]*model.CallbackDelivery, error) {
+	return w.instanceRepo.ListCallbackDeliveries(ctx, workflowId)
+}
+
+func (w *WorkflowService) Subscribe(ctx context.Context, workflowId int64, lastEventId int64) (*workflow.Subscription, error) {
+	return w.engine.Subscribe(ctx, workflowId, lastEventId)
+}
+
+func (w *WorkflowService) Unsubscribe(sub *workflow.Subscription) {
+	w.engine.Unsubscribe(sub)
+}
+
+func (w *WorkflowService) Cancel(ctx context.Context, workflowId int64) error {
+	return w.engine.Cancel(ctx, workflowId)
+}
+
+func (w *WorkflowService) Retry(ctx context.Context, workflowId int64, request *model.RetryWorkflowRequest) error {
+	return w.engine.Retry(ctx, workflowId, request)
+}
+
+func (w *WorkflowService) ListPendingTasks(ctx context.Context, query model.HumanTaskQuery) ([]*model.HumanTaskListDTO, int, error) {
+	tasks, total, err := w.instanceRepo.ListPendingHumanTasks(ctx, query)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	definition := detail.Data
-	_, err = w.engine.Start(ctx, definition, request.TemplateId, 1, request.Inputs, msgChan)
-	return err
+	for _, task := range tasks {
+		task.StatusName = task.Status.String()
+		_ = json.Unmarshal([]byte(task.FormFieldsJSON), &task.FormFields)
+		_ = json.Unmarshal([]byte(task.ValuesJSON), &task.Values)
+	}
+	return tasks, total, nil
+}
+
+func (w *WorkflowService) CompleteTask(ctx context.Context, taskId int64, request *model.CompleteHumanTaskRequest) error {
+	return w.engine.CompleteHumanTask(ctx, taskId, request, 1)
 }
 
 func (w *WorkflowService) ListWorkflowInstance(ctx context.Context, query model.WorkflowInstanceQuery) ([]*model.WorkflowInstanceListDTO, int, error) {
@@ -92,9 +132,54 @@ func (w *WorkflowService) GetWorkflowInstanceDetail(ctx context.Context, workflo
 	instance.NodeStatusList = nodeStatusList
 	instance.PassedEdgesList = workflow.GetPassedEdges(&definition, nodeStatusList, branches)
 	instance.SuccessBranchList = branches
+	instance.Usage, err = w.instanceRepo.GetWorkflowTokenUsage(ctx, workflowId)
+	if err != nil {
+		return nil, err
+	}
+	instance.NodeUsages, err = w.instanceRepo.ListNodeTokenUsages(ctx, workflowId)
+	if err != nil {
+		return nil, err
+	}
 	return instance, nil
 }
 
+func (w *WorkflowService) DebugNode(ctx context.Context, request *model.DebugNodeRequest) (*model.DebugNodeResult, error) {
+	return w.engine.DebugNode(ctx, request)
+}
+
+// UsageStatistics 统计时间范围内的token用量和费用，默认统计最近30天
+func (w *WorkflowService) UsageStatistics(ctx context.Context, query *model.UsageStatisticsQuery) ([]*model.UsageStatisticsDTO, error) {
+	switch query.GroupBy {
+	case "":
+		query.GroupBy = model.UsageGroupByTemplate
+	case model.UsageGroupByTemplate, model.UsageGroupByDay, model.UsageGroupByModel:
+	default:
+		return nil, errors.New("不支持的统计方式")
+	}
+	now := time.Now()
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if query.EndTime != "" {
+		t, err := time.ParseInLocation(time.DateOnly, query.EndTime, time.Local)
+		if err != nil {
+			return nil, errors.New("结束日期格式错误")
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -29)
+	if query.StartTime != "" {
+		t, err := time.ParseInLocation(time.DateOnly, query.StartTime, time.Local)
+		if err != nil {
+			return nil, errors.New("开始日期格式错误")
+		}
+		start = t
+	}
+	if start.After(end) {
+		return nil, errors.New("开始日期不能晚于结束日期")
+	}
+	query.Start, query.End = start, end.AddDate(0, 0, 1)
+	return w.instanceRepo.UsageStatistics(ctx, query)
+}
+
 func (w *WorkflowService) GetNodeInstance(ctx context.Context, workflowId int64, nodeId string) (*model.NodeInstanceDetailDTO, error) {
 	instance, err := w.instanceRepo.GetNodeInstanceByNodeId(ctx, workflowId, nodeId)
 	if err != nil {
@@ -120,6 +205,43 @@ func (w *WorkflowService) GetNodeInstance(ctx context.Context, workflowId int64,
 			outputVarTypes[outputVar.Name] = outputVar.Type
 		}
 	}
+	executions, err := w.instanceRepo.ListNodeExecutions(ctx, instance.Id)
+	if err != nil {
+		return nil, err
+	}
+	// 子流程节点创建的子流程实例
+	var childWorkflowId int64
+	if instance.Type == model.NodeTypeSubWorkflow {
+		child, err := w.instanceRepo.GetLatestChildWorkflowInstance(ctx, workflowId, nodeId)
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			childWorkflowId = child.Id
+		}
+	}
+	// 迭代节点的子节点实例
+	var children []*model.ChildNodeInstanceDTO
+	if instance.Type == model.NodeTypeIteration {
+		childInstances, err := w.instanceRepo.ListChildNodeInstances(ctx, instance.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range childInstances {
+			children = append(children, &model.ChildNodeInstanceDTO{
+				Id:           child.Id,
+				NodeId:       child.NodeId,
+				Type:         child.Type,
+				Iteration:    child.Iteration,
+				Status:       child.Status,
+				StatusName:   child.Status.String(),
+				Output:       child.Output,
+				Error:        child.Error,
+				AddTime:      child.AddTime,
+				CompleteTime: child.CompleteTime,
+			})
+		}
+	}
 	return &model.NodeInstanceDetailDTO{
 		Id:                  instance.Id,
 		NodeId:              instance.NodeId,
@@ -131,6 +253,10 @@ func (w *WorkflowService) GetNodeInstance(ctx context.Context, workflowId int64,
 		Output:              instance.Output,
 		Error:               instance.Error,
 		OutputVariableTypes: outputVarTypes,
+		Attempts:            instance.Attempts,
+		Executions:          executions,
+		Children:            children,
+		ChildWorkflowId:     childWorkflowId,
 	}, nil
 }
 
@@ -0,0 +1,240 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/bwmarrin/snowflake"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxCallbackAttempts = 5
+	callbackTimeout     = 10 * time.Second
+	callbackLease       = 3 * callbackTimeout // 投递中的回调超过租约时长没有结果时重新投递
+	callbackBatchSize   = 20
+)
+
+// callbackRetryPolicy 回调失败后的重试间隔，从5秒开始每次翻倍
+var callbackRetryPolicy = &model.ExecutionPolicy{Backoff: 5000, BackoffRate: 2}
+
+var errCallbackSecretNotConfigured = errors.New("未配置回调签名密钥(workflow.callbackSecret)，不能使用流程结束回调")
+
+// callbackStore 回调投递记录，由流程实例表实现
+type callbackStore interface {
+	InsertCallbackDelivery(ctx context.Context, delivery *model.CallbackDelivery) error
+	ListDueCallbackDeliveries(ctx context.Context, now time.Time, limit int) ([]*model.CallbackDelivery, error)
+	ClaimCallbackDelivery(ctx context.Context, id int64, now time.Time, until time.Time) (bool, error)
+	UpdateCallbackDelivery(ctx context.Context, delivery *model.CallbackDelivery) error
+}
+
+type transactor interface {
+	Tx(ctx context.Context, fn func(c context.Context) error) error
+}
+
+// CallbackSender 投递流程结束回调。每次投递记录一条投递记录，失败时写入下一次投递的记录，
+// 由轮询协程在到达投递时间后重试，服务重启或投递中的服务实例停止后回调不会丢失。
+// 请求头X-Signature-256为请求体的HMAC-SHA256签名，未配置签名密钥时不投递回调
+type CallbackSender struct {
+	store        callbackStore
+	tm           transactor
+	snowflake    *snowflake.Node
+	secret       string
+	pollInterval time.Duration
+	client       *http.Client
+	cancel       context.CancelFunc
+}
+
+func NewCallbackSender(store callbackStore, tm transactor, snowflake *snowflake.Node, secret string,
+	pollInterval time.Duration) *CallbackSender {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &CallbackSender{
+		store:        store,
+		tm:           tm,
+		snowflake:    snowflake,
+		secret:       secret,
+		pollInterval: pollInterval,
+		client:       &http.Client{Timeout: callbackTimeout},
+		cancel:       cancel,
+	}
+	go s.run(ctx)
+	return s
+}
+
+func (s *CallbackSender) Stop() {
+	s.cancel()
+}
+
+// Send 记录并立即投递一次回调，失败时由轮询协程按退避间隔重试
+func (s *CallbackSender) Send(ctx context.Context, workflowId int64, url string, body []byte) {
+	now := time.Now()
+	until := now.Add(callbackLease)
+	delivery := &model.CallbackDelivery{
+		Id:              s.snowflake.Generate().Int64(),
+		WorkflowId:      workflowId,
+		Url:             url,
+		Attempt:         1,
+		Payload:         string(body),
+		NextAttemptTime: &until,
+		AddTime:         now,
+	}
+	if err := s.store.InsertCallbackDelivery(ctx, delivery); err != nil {
+		log.Println("insert callback delivery error:", err)
+		return
+	}
+	s.deliver(ctx, delivery)
+}
+
+func (s *CallbackSender) run(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.deliverDue(ctx)
+		}
+	}
+}
+
+// deliverDue 领取并投递到达投递时间的回调，多个服务实例只有领取成功的会投递
+func (s *CallbackSender) deliverDue(ctx context.Context) {
+	now := time.Now()
+	deliveries, err := s.store.ListDueCallbackDeliveries(ctx, now, callbackBatchSize)
+	if err != nil {
+		log.Println("list due callback deliveries error:", err)
+		return
+	}
+	for _, delivery := range deliveries {
+		ok, err := s.store.ClaimCallbackDelivery(ctx, delivery.Id, now, now.Add(callbackLease))
+		if err != nil {
+			log.Println("claim callback delivery error:", err)
+			continue
+		}
+		if ok {
+			s.deliver(ctx, delivery)
+		}
+	}
+}
+
+// deliver 投递一次回调并记录结果，响应状态码为2xx时投递成功，失败且没有达到最大次数时写入下一次投递的记录
+func (s *CallbackSender) deliver(ctx context.Context, delivery *model.CallbackDelivery) {
+	delivery.AddTime = time.Now()
+	delivery.StatusCode = 0
+	err := s.post(ctx, delivery)
+	delivery.Duration = time.Since(delivery.AddTime).Milliseconds()
+	delivery.Success = err == nil
+	delivery.Error = ""
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	delivery.NextAttemptTime = nil
+	var next *model.CallbackDelivery
+	if !delivery.Success && delivery.Attempt < maxCallbackAttempts {
+		nextAttemptTime := time.Now().Add(backoffDuration(callbackRetryPolicy, delivery.Attempt))
+		next = &model.CallbackDelivery{
+			Id:              s.snowflake.Generate().Int64(),
+			WorkflowId:      delivery.WorkflowId,
+			Url:             delivery.Url,
+			Attempt:         delivery.Attempt + 1,
+			Payload:         delivery.Payload,
+			NextAttemptTime: &nextAttemptTime,
+			AddTime:         nextAttemptTime,
+		}
+	}
+	err = s.tm.Tx(context.WithoutCancel(ctx), func(ctx context.Context) error {
+		if err := s.store.UpdateCallbackDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+		return s.store.InsertCallbackDelivery(ctx, next)
+	})
+	if err != nil {
+		log.Println("update callback delivery error:", err)
+		return
+	}
+	if !delivery.Success && next == nil {
+		log.Printf("callback of workflow %d failed after %d attempts", delivery.WorkflowId, delivery.Attempt)
+	}
+}
+
+func (s *CallbackSender) post(ctx context.Context, delivery *model.CallbackDelivery) error {
+	if s.secret == "" {
+		return errCallbackSecretNotConfigured
+	}
+	body := []byte(delivery.Payload)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Workflow-Id", strconv.FormatInt(delivery.WorkflowId, 10))
+	request.Header.Set("X-Callback-Attempt", strconv.Itoa(delivery.Attempt))
+	request.Header.Set("X-Signature-256", signCallback(s.secret, body))
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 1<<20))
+	delivery.StatusCode = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("status code: %d", response.StatusCode)
+	}
+	return nil
+}
+
+// signCallback 计算回调请求体的HMAC-SHA256签名，格式为"sha256="加十六进制签名，与webhook的签名格式相同
+func signCallback(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyCallback 流程完成或失败后向回调地址推送流程的最终状态，失败后的重试由CallbackSender负责。
+// 首次投递会阻塞，需要在单独的协程中调用
+func (e *Engine) notifyCallback(workflowId int64) {
+	ctx := context.Background()
+	instance, err := e.instanceRepo.GetWorkflowInstance(ctx, workflowId)
+	if err != nil || instance == nil || instance.CallbackUrl == "" {
+		return
+	}
+	payload := model.CallbackPayload{
+		WorkflowId:   instance.Id,
+		TemplateId:   instance.TemplateId,
+		Status:       instance.Status,
+		StatusName:   instance.Status.String(),
+		Output:       make(map[string]any),
+		CompleteTime: instance.CompleteTime,
+	}
+	if instance.Status == model.WorkflowInstanceStatusCompleted {
+		output, err := e.instanceRepo.GetEndNodeOutput(ctx, workflowId)
+		if err != nil {
+			log.Println("get end node output error:", err)
+		}
+		_ = json.Unmarshal([]byte(output), &payload.Output)
+	} else {
+		payload.Error, err = e.instanceRepo.GetFailedNodeError(ctx, workflowId)
+		if err != nil {
+			log.Println("get failed node error:", err)
+		}
+	}
+	body, _ := json.Marshal(payload)
+	e.callbacks.Send(ctx, instance.Id, instance.CallbackUrl, body)
+}
@@ -0,0 +1,219 @@
+package workflow
+
+import (
+	"context"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/bwmarrin/snowflake"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignCallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   string
+	}{
+		// RFC 4231 测试用例2
+		{"rfc 4231", "Jefe", "what do ya want for nothing?",
+			"sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{"empty body", "key", "", "sha256=5d5d139563c95b5967b9bd9a8c9b233a9dedb45072794cd232dc1b74832607d0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signCallback(tt.secret, []byte(tt.body)); got != tt.want {
+				t.Errorf("signCallback() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// memoryCallbackStore 内存中的回调投递记录
+type memoryCallbackStore struct {
+	mutex      sync.Mutex
+	deliveries map[int64]*model.CallbackDelivery
+}
+
+func (m *memoryCallbackStore) InsertCallbackDelivery(_ context.Context, delivery *model.CallbackDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	copied := *delivery
+	m.deliveries[delivery.Id] = &copied
+	return nil
+}
+
+func (m *memoryCallbackStore) ListDueCallbackDeliveries(_ context.Context, now time.Time,
+	limit int) ([]*model.CallbackDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var result []*model.CallbackDelivery
+	for _, delivery := range m.deliveries {
+		if delivery.NextAttemptTime != nil && !delivery.NextAttemptTime.After(now) && len(result) < limit {
+			copied := *delivery
+			result = append(result, &copied)
+		}
+	}
+	return result, nil
+}
+
+func (m *memoryCallbackStore) ClaimCallbackDelivery(_ context.Context, id int64, now time.Time,
+	until time.Time) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delivery := m.deliveries[id]
+	if delivery == nil || delivery.NextAttemptTime == nil || delivery.NextAttemptTime.After(now) {
+		return false, nil
+	}
+	delivery.NextAttemptTime = &until
+	return true, nil
+}
+
+func (m *memoryCallbackStore) UpdateCallbackDelivery(_ context.Context, delivery *model.CallbackDelivery) error {
+	return m.InsertCallbackDelivery(context.Background(), delivery)
+}
+
+// list 按投递次数排序的投递记录
+func (m *memoryCallbackStore) list() []model.CallbackDelivery {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var result []model.CallbackDelivery
+	for _, delivery := range m.deliveries {
+		result = append(result, *delivery)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Attempt < result[j].Attempt })
+	return result
+}
+
+// makeDue 把等待中的投递提前到当前时间
+func (m *memoryCallbackStore) makeDue() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	for _, delivery := range m.deliveries {
+		if delivery.NextAttemptTime != nil {
+			delivery.NextAttemptTime = &now
+		}
+	}
+}
+
+type noTx struct{}
+
+func (noTx) Tx(ctx context.Context, fn func(c context.Context) error) error {
+	return fn(ctx)
+}
+
+func newMemoryCallbackStore() *memoryCallbackStore {
+	return &memoryCallbackStore{deliveries: make(map[int64]*model.CallbackDelivery)}
+}
+
+func newTestCallbackSender(t *testing.T, store *memoryCallbackStore, secret string) *CallbackSender {
+	t.Helper()
+	node, err := snowflake.NewNode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 轮询间隔足够长，由测试调用deliverDue
+	s := NewCallbackSender(store, noTx{}, node, secret, time.Hour)
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func TestCallbackSenderRetriesPersistedDeliveries(t *testing.T) {
+	var mutex sync.Mutex
+	var signatures, attempts []string
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if got, want := r.Header.Get("X-Signature-256"), signCallback("s3cret", body); got != want {
+			t.Errorf("X-Signature-256 = %s, want %s", got, want)
+		}
+		signatures = append(signatures, r.Header.Get("X-Signature-256"))
+		attempts = append(attempts, r.Header.Get("X-Callback-Attempt"))
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	store := newMemoryCallbackStore()
+	s := newTestCallbackSender(t, store, "s3cret")
+	s.Send(context.Background(), 100, server.URL, []byte(`{"workflowId":"100"}`))
+	deliveries := store.list()
+	if len(deliveries) != 2 {
+		t.Fatalf("got %d delivery records after a failed attempt, want 2", len(deliveries))
+	}
+	if first := deliveries[0]; first.Success || first.StatusCode != http.StatusServiceUnavailable || first.NextAttemptTime != nil {
+		t.Errorf("first attempt recorded as %+v", first)
+	}
+	next := deliveries[1]
+	if next.Attempt != 2 || next.NextAttemptTime == nil || next.Payload != deliveries[0].Payload {
+		t.Fatalf("retry not persisted: %+v", next)
+	}
+	if wait := time.Until(*next.NextAttemptTime); wait < 4*time.Second || wait > 5*time.Second {
+		t.Errorf("next attempt in %v, want about 5s", wait)
+	}
+
+	// 未到投递时间的记录不会投递，重启后的服务实例在到达投递时间后继续投递
+	s.deliverDue(context.Background())
+	restarted := newTestCallbackSender(t, store, "s3cret")
+	store.makeDue()
+	restarted.deliverDue(context.Background())
+	deliveries = store.list()
+	if len(deliveries) != 2 || !deliveries[1].Success || deliveries[1].NextAttemptTime != nil {
+		t.Errorf("retry not delivered: %+v", deliveries)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(attempts) != 2 || attempts[0] != "1" || attempts[1] != "2" {
+		t.Errorf("X-Callback-Attempt = %v, want [1 2]", attempts)
+	}
+}
+
+func TestCallbackSenderStopsAfterMaxAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	store := newMemoryCallbackStore()
+	s := newTestCallbackSender(t, store, "s3cret")
+	s.Send(context.Background(), 100, server.URL, []byte(`{}`))
+	for i := 1; i < maxCallbackAttempts+2; i++ {
+		store.makeDue()
+		s.deliverDue(context.Background())
+	}
+	deliveries := store.list()
+	if len(deliveries) != maxCallbackAttempts {
+		t.Fatalf("got %d delivery records, want %d", len(deliveries), maxCallbackAttempts)
+	}
+	for _, delivery := range deliveries {
+		if delivery.Success || delivery.NextAttemptTime != nil {
+			t.Errorf("attempt %d recorded as %+v", delivery.Attempt, delivery)
+		}
+	}
+}
+
+func TestCallbackSenderRequiresSecret(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+	store := newMemoryCallbackStore()
+	s := newTestCallbackSender(t, store, "")
+	s.Send(context.Background(), 100, server.URL, []byte(`{}`))
+	if called {
+		t.Errorf("unsigned callback was delivered")
+	}
+	if deliveries := store.list(); len(deliveries) == 0 || deliveries[0].Error != errCallbackSecretNotConfigured.Error() {
+		t.Errorf("delivery records = %+v, want the missing secret error", deliveries)
+	}
+}
@@ -0,0 +1,229 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/syntax"
+	"math"
+	"time"
+)
+
+const (
+	codeFileName           = "code"
+	codeEntryFunction      = "main"
+	defaultCodeMaxSteps    = 10_000_000
+	defaultCodeNodeTimeout = 10 * time.Second
+	maxCodeResultDepth     = 64 // 返回值最大嵌套层级
+)
+
+// executeCodeNode 在Starlark解释器中执行脚本，脚本不能访问网络和文件系统。
+// main函数的参数是节点的输入变量，返回的dict必须包含节点声明的所有输出变量
+func (e *Engine) executeCodeNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
+	nodeData *model.CodeNodeData, inputMap map[string]any) {
+	// 没有配置执行策略超时时使用默认超时，避免死循环长时间占用工作协程
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCodeNodeTimeout)
+		defer cancel()
+	}
+	result, err := runCode(ctx, nodeData, inputMap)
+	if err != nil {
+		panic(err)
+	}
+	output := make(map[string]any)
+	for _, variable := range node.Data.Output {
+		value, ok := result[variable.Name]
+		if !ok || value == nil {
+			panic(fmt.Errorf("main函数返回值缺少输出变量: %s", variable.Name))
+		}
+		converted, err := convertFormValue(variable.Type, value)
+		if err != nil {
+			panic(fmt.Errorf("输出变量%s类型错误: %w", variable.Name, err))
+		}
+		output[variable.Name] = converted
+	}
+	data, _ := json.Marshal(output)
+	nodeInstance.Output = string(data)
+}
+
+// runCode 执行脚本的main函数，返回main函数返回的dict
+func runCode(ctx context.Context, nodeData *model.CodeNodeData, inputMap map[string]any) (map[string]any, error) {
+	thread := &starlark.Thread{
+		Name: codeFileName,
+		// 不允许load其他模块
+		Load: func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("不支持加载模块: %s", module)
+		},
+		Print: func(_ *starlark.Thread, _ string) {},
+	}
+	maxSteps := nodeData.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = defaultCodeMaxSteps
+	}
+	thread.SetMaxExecutionSteps(maxSteps)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel("执行超时")
+		case <-done:
+		}
+	}()
+
+	predeclared := starlark.StringDict{"json": starlarkjson.Module}
+	globals, err := starlark.ExecFile(thread, codeFileName, nodeData.Code, predeclared)
+	if err != nil {
+		return nil, formatCodeError(err)
+	}
+	mainFunc, ok := globals[codeEntryFunction].(starlark.Callable)
+	if !ok {
+		return nil, errors.New("脚本缺少main函数")
+	}
+	inputs, err := toStarlarkValue(inputMap)
+	if err != nil {
+		return nil, err
+	}
+	value, err := starlark.Call(thread, mainFunc, starlark.Tuple{inputs}, nil)
+	if err != nil {
+		return nil, formatCodeError(err)
+	}
+	if _, ok := value.(*starlark.Dict); !ok {
+		return nil, fmt.Errorf("main函数必须返回dict，实际返回%s", value.Type())
+	}
+	result, err := fromStarlarkValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return result.(map[string]any), nil
+}
+
+// formatCodeError 将语法错误和运行时错误转换为带行号的错误信息
+func formatCodeError(err error) error {
+	var syntaxErr syntax.Error
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("第%d行语法错误: %s", syntaxErr.Pos.Line, syntaxErr.Msg)
+	}
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		// 错误可能发生在内置函数中，取脚本中最内层的调用位置
+		for i := 0; i < len(evalErr.CallStack); i++ {
+			pos := evalErr.CallStack.At(i).Pos
+			if pos.Filename() == codeFileName && pos.Line > 0 {
+				return fmt.Errorf("第%d行执行错误: %s", pos.Line, evalErr.Msg)
+			}
+		}
+		return fmt.Errorf("执行错误: %s", evalErr.Msg)
+	}
+	return err
+}
+
+// toStarlarkValue 将输入变量转换为Starlark值，先经过json序列化统一变量类型
+func toStarlarkValue(inputMap map[string]any) (starlark.Value, error) {
+	data, err := json.Marshal(inputMap)
+	if err != nil {
+		return nil, err
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return convertToStarlark(normalized), nil
+}
+
+func convertToStarlark(value any) starlark.Value {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None
+	case bool:
+		return starlark.Bool(v)
+	case string:
+		return starlark.String(v)
+	case float64:
+		// 整数转换为int，方便作为下标使用
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v))
+		}
+		return starlark.Float(v)
+	case []any:
+		elems := make([]starlark.Value, len(v))
+		for i, item := range v {
+			elems[i] = convertToStarlark(item)
+		}
+		return starlark.NewList(elems)
+	case map[string]any:
+		dict := starlark.NewDict(len(v))
+		for key, item := range v {
+			_ = dict.SetKey(starlark.String(key), convertToStarlark(item))
+		}
+		return dict
+	default:
+		return starlark.None
+	}
+}
+
+// fromStarlarkValue 将Starlark值转换为可以json序列化的值
+func fromStarlarkValue(value starlark.Value) (any, error) {
+	return convertFromStarlark(value, 0)
+}
+
+// convertFromStarlark 递归转换Starlark值，限制嵌套深度，避免自引用的list或dict导致栈溢出
+func convertFromStarlark(value starlark.Value, depth int) (any, error) {
+	if depth > maxCodeResultDepth {
+		return nil, fmt.Errorf("返回值嵌套层级超过%d层", maxCodeResultDepth)
+	}
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Bytes:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return float64(i), nil
+		}
+		return nil, errors.New("整数超出范围")
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		return convertStarlarkSequence(v, depth)
+	case starlark.Tuple:
+		return convertStarlarkSequence(v, depth)
+	case *starlark.Dict:
+		result := make(map[string]any, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict的key必须是字符串，实际为%s", item[0].Type())
+			}
+			converted, err := convertFromStarlark(item[1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			result[string(key)] = converted
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("不支持的返回值类型: %s", value.Type())
+	}
+}
+
+func convertStarlarkSequence(v starlark.Indexable, depth int) ([]any, error) {
+	result := make([]any, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		item, err := convertFromStarlark(v.Index(i), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = item
+	}
+	return result, nil
+}
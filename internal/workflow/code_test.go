@@ -0,0 +1,64 @@
+package workflow
+
+import (
+	"go.starlark.net/starlark"
+	"reflect"
+	"testing"
+)
+
+func TestFromStarlarkValue(t *testing.T) {
+	dict := starlark.NewDict(2)
+	_ = dict.SetKey(starlark.String("name"), starlark.String("a"))
+	_ = dict.SetKey(starlark.String("tags"), starlark.NewList([]starlark.Value{starlark.String("x")}))
+	badKey := starlark.NewDict(1)
+	_ = badKey.SetKey(starlark.MakeInt(1), starlark.None)
+	selfList := starlark.NewList(nil)
+	_ = selfList.Append(selfList)
+	selfDict := starlark.NewDict(1)
+	_ = selfDict.SetKey(starlark.String("self"), selfDict)
+	bigInt := starlark.MakeInt64(1 << 62).Mul(starlark.MakeInt(8))
+
+	tests := []struct {
+		name    string
+		value   starlark.Value
+		want    any
+		wantErr bool
+	}{
+		{"none", starlark.None, nil, false},
+		{"bool", starlark.True, true, false},
+		{"string", starlark.String("hello"), "hello", false},
+		{"bytes", starlark.Bytes("hello"), "hello", false},
+		{"int", starlark.MakeInt(42), float64(42), false},
+		{"int overflow", bigInt, nil, true},
+		{"float", starlark.Float(1.5), 1.5, false},
+		{"list", starlark.NewList([]starlark.Value{starlark.MakeInt(1), starlark.String("a")}), []any{float64(1), "a"}, false},
+		{"tuple", starlark.Tuple{starlark.True, starlark.None}, []any{true, nil}, false},
+		{"list of bytes", starlark.NewList([]starlark.Value{starlark.Bytes("b")}), []any{"b"}, false},
+		{"dict", dict, map[string]any{"name": "a", "tags": []any{"x"}}, false},
+		{"dict non-string key", badKey, nil, true},
+		{"range", starlark.Value(mustEval(t, "range(3)")), nil, true},
+		{"function", starlark.Value(mustEval(t, "len")), nil, true},
+		{"self-referencing list", selfList, nil, true},
+		{"self-referencing dict", selfDict, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fromStarlarkValue(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fromStarlarkValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fromStarlarkValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustEval(t *testing.T, expr string) starlark.Value {
+	t.Helper()
+	value, err := starlark.Eval(&starlark.Thread{}, "test", expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
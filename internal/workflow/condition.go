@@ -6,32 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
-	"log"
 	"strconv"
 	"strings"
-	"time"
 )
 
 func (e *Engine) executeConditionNode(ctx context.Context, node *model.Node, nodeData *model.ConditionNodeData,
 	nodeInstance *model.NodeInstance) error {
-	defer func() {
-		if err := recover(); err != nil {
-			nodeInstance.Status = model.NodeInstanceStatusFailed
-			nodeInstance.Error = err.(error).Error()
-			if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
-				log.Println("update kb node instance error:", err)
-			}
-		}
-	}()
 	branches := nodeData.Branches
 	// 获取流程定义
-	data, _ := e.instanceRepo.GetWorkflowDefinition(ctx, nodeInstance.WorkflowId)
-	if data == "" {
-		return errors.New("workflow definition not found")
-	}
-	var definition model.WorkflowDefinition
-	if err := json.Unmarshal([]byte(data), &definition); err != nil {
-		return errors.New("invalid workflow definition")
+	plan, err := e.getPlan(ctx, nodeInstance.WorkflowId)
+	if err != nil {
+		return err
 	}
 
 	// 找到第一个满足条件的分支
@@ -43,7 +28,7 @@ func (e *Engine) executeConditionNode(ctx context.Context, node *model.Node, nod
 			break
 		}
 		// if和else if分支
-		ok, err := e.evaluateConditions(ctx, branch.Conditions, branch.Connector, nodeInstance.WorkflowId, &definition)
+		ok, err := e.evaluateConditions(ctx, branch.Conditions, branch.Connector, nodeInstance.WorkflowId, plan)
 		if err != nil {
 			return err
 		}
@@ -60,30 +45,31 @@ func (e *Engine) executeConditionNode(ctx context.Context, node *model.Node, nod
 	output := model.ConditionNodeOutput{SuccessBranch: targetBranch.Handle}
 	outputData, _ := json.Marshal(output)
 	nodeInstance.Output = string(outputData)
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	nodeInstance.CompleteTime = time.Now()
-	err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance)
-	if err != nil {
-		return err
-	}
-
-	// 找到目标分支的后续节点
-	nextNodes := FindBranchNextNodes(&definition, node, targetBranch)
-	// 执行分支后续节点
-	e.executeNextNodes(ctx, nextNodes, &definition, nodeInstance.WorkflowId)
 	return nil
 }
 
+func (e *Engine) getWorkflowDefinition(ctx context.Context, workflowId int64) (*model.WorkflowDefinition, error) {
+	data, _ := e.instanceRepo.GetWorkflowDefinition(ctx, workflowId)
+	if data == "" {
+		return nil, errors.New("workflow definition not found")
+	}
+	var definition model.WorkflowDefinition
+	if err := json.Unmarshal([]byte(data), &definition); err != nil {
+		return nil, errors.New("invalid workflow definition")
+	}
+	return &definition, nil
+}
+
 func (e *Engine) evaluateConditions(ctx context.Context, conditions []*model.Condition, connector string, workflowId int64,
-	definition *model.WorkflowDefinition) (bool, error) {
+	plan *ExecutionPlan) (bool, error) {
 	isAnd := connector == "and"
 	for _, condition := range conditions {
 		// 从变量实例表中获取变量值
-		value1, value1Type, err := e.getConditionVariableValue(ctx, condition.Value1, workflowId, definition)
+		value1, value1Type, err := e.getConditionVariableValue(ctx, condition.Value1, workflowId, plan)
 		if err != nil {
 			return false, err
 		}
-		value2, value2Type, err := e.getConditionVariableValue(ctx, condition.Value2, workflowId, definition)
+		value2, value2Type, err := e.getConditionVariableValue(ctx, condition.Value2, workflowId, plan)
 		if err != nil {
 			return false, err
 		}
@@ -115,7 +101,7 @@ func (e *Engine) evaluateConditions(ctx context.Context, conditions []*model.Con
 }
 
 func (e *Engine) getConditionVariableValue(ctx context.Context, variable *model.Input, workflowId int64,
-	definition *model.WorkflowDefinition) (string,
+	plan *ExecutionPlan) (string,
 	model.VariableType, error) {
 	varType := variable.Type
 	if variable.Value.Type == model.VarValueTypeLiteral {
@@ -123,14 +109,34 @@ func (e *Engine) getConditionVariableValue(ctx context.Context, variable *model.
 	}
 
 	nodeId, varName := variable.Value.SourceNode, variable.Value.SourceName
-	value, err := e.instanceRepo.GetOutputVariableFromNodeInstance(ctx, nodeId, workflowId, varName)
+	inputMap, err := e.LookupInputVariables(ctx, []model.Input{*variable}, workflowId)
 	if err != nil {
 		return "", varType, err
 	}
-	originNode := FindNodeById(definition, nodeId)
-	originVar := FindNodeOutputVariable(originNode, varName)
-	value = strings.Trim(value, "\"")
-	return value, originVar.Type, nil
+	var value string
+	switch v := inputMap[variable.Name].(type) {
+	case nil:
+	case string:
+		value = v
+	default:
+		data, _ := json.Marshal(v)
+		value = string(data)
+	}
+	// 迭代子流程中的节点不在外层流程的执行计划中，使用变量声明的类型
+	originNode := plan.Node(nodeId)
+	if scope := iterationScopeFrom(ctx); scope != nil && scope.plan.Node(nodeId) != nil {
+		originNode = scope.plan.Node(nodeId)
+	}
+	if originNode != nil {
+		if originVar := FindNodeOutputVariable(originNode, varName); originVar != nil {
+			varType = originVar.Type
+		}
+	} else if nodeId == model.SystemVariableNodeId {
+		if sysVar := findSystemVariable(varName); sysVar != nil {
+			varType = sysVar.Type
+		}
+	}
+	return value, varType, nil
 }
 
 func compareNumber(op string, value1, value2 string) (bool, error) {
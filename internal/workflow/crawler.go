@@ -1,79 +1,238 @@
 package workflow
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"golang.org/x/net/html"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
 )
 
+const (
+	defaultHttpTimeout         = 30 * time.Second
+	defaultHttpMaxResponseSize = 10 << 20
+)
+
+var templateVariablePattern = regexp.MustCompile(`{{\s*([\w.]+)\s*}}`)
+
+// executeCrawlerNode 发送HTTP请求，输出状态码、响应头和响应内容。html页面只保留段落文本，非文本内容使用base64编码
 func (e *Engine) executeCrawlerNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
 	crawlerData *model.CrawlerNodeData, inputMap map[string]any) {
-	urlStr, ok := inputMap["url"]
-	if !ok {
+	urlStr, ok := inputMap["url"].(string)
+	if !ok || urlStr == "" {
 		panic(errors.New("url参数不存在"))
 	}
-	u, err := url.Parse(urlStr.(string))
+	request, err := buildHttpRequest(ctx, interpolateUrl(urlStr, inputMap), crawlerData, inputMap)
 	if err != nil {
 		panic(err)
 	}
-	// http请求
-	request, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	timeout := defaultHttpTimeout
+	if crawlerData.Timeout > 0 {
+		timeout = time.Duration(crawlerData.Timeout) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(request)
 	if err != nil {
 		panic(err)
 	}
-	response, err := http.DefaultClient.Do(request)
+	defer response.Body.Close()
+	if crawlerData.FailOnNon2xx && (response.StatusCode < 200 || response.StatusCode >= 300) {
+		panic(fmt.Errorf("HTTP请求失败, status code: %d", response.StatusCode))
+	}
+	maxSize := crawlerData.MaxResponseSize
+	if maxSize <= 0 {
+		maxSize = defaultHttpMaxResponseSize
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxSize+1))
 	if err != nil {
 		panic(err)
 	}
-	result := make(map[string]any)
-	// 请求失败
-	if response.StatusCode != 200 {
-		result["code"] = response.StatusCode
-		result["message"] = response.Status
-		return
+	if int64(len(body)) > maxSize {
+		panic(fmt.Errorf("响应内容超过%d字节", maxSize))
 	}
-	result["code"] = response.StatusCode
-	result["message"] = response.Status
 
 	contentType := response.Header.Get("Content-Type")
-	contentType = strings.Split(contentType, ";")[0]
-	result["contentType"] = contentType
-	switch contentType {
-	case "application/json", "text/plain":
-		bytes, err := io.ReadAll(response.Body)
-		if err != nil {
-			panic(err)
-		}
-		result["data"] = string(bytes)
-	case "text/html":
-		content, err := parseHTMLContent(response)
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	var content string
+	switch {
+	case contentType == "text/html":
+		content, err = parseHTMLContent(bytes.NewReader(body))
 		if err != nil {
 			panic(err)
 		}
-		result["data"] = content
+	case isTextContentType(contentType):
+		content = string(body)
 	default:
-		panic(fmt.Errorf("不支持的网页内容:%s", contentType))
+		content = base64.StdEncoding.EncodeToString(body)
+	}
+	headers := make(map[string]string)
+	for key := range response.Header {
+		headers[key] = response.Header.Get(key)
+	}
+	headersData, _ := json.Marshal(headers)
+	result := map[string]any{
+		"code":         response.StatusCode,
+		"headers":      string(headersData),
+		"content-type": contentType,
+		"content":      content,
 	}
 	outputs, _ := json.Marshal(result)
 	nodeInstance.Output = string(outputs)
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	nodeInstance.CompleteTime = time.Now()
-	if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
-		log.Println("update node instance error:", err)
+}
+
+// buildHttpRequest 根据节点配置创建请求，配置中的变量引用替换为输入变量的值
+func buildHttpRequest(ctx context.Context, urlStr string, crawlerData *model.CrawlerNodeData,
+	inputMap map[string]any) (*http.Request, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("不支持的url: %s", urlStr)
+	}
+	query := u.Query()
+	for _, param := range crawlerData.Params {
+		if param.Key == "" {
+			continue
+		}
+		query.Add(interpolateVariables(param.Key, inputMap), interpolateVariables(param.Value, inputMap))
+	}
+	u.RawQuery = query.Encode()
+
+	method := strings.ToUpper(crawlerData.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	var contentType string
+	switch crawlerData.BodyType {
+	case model.HttpBodyTypeJSON:
+		data := interpolateVariables(crawlerData.Body, inputMap)
+		if !json.Valid([]byte(data)) {
+			return nil, errors.New("请求体不是有效的json")
+		}
+		body, contentType = strings.NewReader(data), "application/json"
+	case model.HttpBodyTypeForm:
+		form := url.Values{}
+		for _, field := range crawlerData.FormData {
+			if field.Key == "" {
+				continue
+			}
+			form.Add(interpolateVariables(field.Key, inputMap), interpolateVariables(field.Value, inputMap))
+		}
+		body, contentType = strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+	case model.HttpBodyTypeRaw:
+		body, contentType = strings.NewReader(interpolateVariables(crawlerData.Body, inputMap)), "text/plain"
+	}
+	request, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	for _, header := range crawlerData.Headers {
+		if header.Key == "" {
+			continue
+		}
+		request.Header.Set(interpolateVariables(header.Key, inputMap), interpolateVariables(header.Value, inputMap))
+	}
+	if auth := crawlerData.Auth; auth != nil {
+		switch auth.Type {
+		case model.HttpAuthTypeBearer:
+			request.Header.Set("Authorization", "Bearer "+interpolateVariables(auth.Token, inputMap))
+		case model.HttpAuthTypeBasic:
+			request.SetBasicAuth(interpolateVariables(auth.Username, inputMap), interpolateVariables(auth.Password, inputMap))
+		case model.HttpAuthTypeApiKey:
+			if auth.HeaderName == "" {
+				return nil, errors.New("缺少API Key请求头名称")
+			}
+			request.Header.Set(auth.HeaderName, interpolateVariables(auth.ApiKey, inputMap))
+		}
+	}
+	return request, nil
+}
+
+// interpolateVariables 将{{变量名}}替换为输入变量的值，字符串直接替换，其他类型替换为json，不存在的变量保持原样
+func interpolateVariables(text string, inputMap map[string]any) string {
+	return templateVariablePattern.ReplaceAllStringFunc(text, func(match string) string {
+		value, ok := variableText(templateVariablePattern.FindStringSubmatch(match)[1], inputMap)
+		if !ok {
+			return match
+		}
+		return value
+	})
+}
+
+// interpolateUrl 替换url中的变量引用，路径中的值使用PathEscape转义，查询参数和锚点中的值使用QueryEscape转义。
+// 协议和主机部分的值不转义，支持整个url或基础地址来自输入变量
+func interpolateUrl(text string, inputMap map[string]any) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range templateVariablePattern.FindAllStringSubmatchIndex(text, -1) {
+		sb.WriteString(text[last:loc[0]])
+		last = loc[1]
+		value, ok := variableText(text[loc[2]:loc[3]], inputMap)
+		if !ok {
+			sb.WriteString(text[loc[0]:loc[1]])
+			continue
+		}
+		prefix := sb.String()
+		switch {
+		case strings.ContainsAny(prefix, "?#"):
+			sb.WriteString(url.QueryEscape(value))
+		case isUrlPath(prefix):
+			sb.WriteString(url.PathEscape(value))
+		default:
+			sb.WriteString(value)
+		}
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// isUrlPath 判断url前缀之后的内容是否位于路径部分
+func isUrlPath(prefix string) bool {
+	idx := strings.Index(prefix, "://")
+	return idx >= 0 && strings.Contains(prefix[idx+3:], "/")
+}
+
+// variableText 获取输入变量替换到文本中的值，字符串直接使用，其他类型使用json
+func variableText(name string, inputMap map[string]any) (string, bool) {
+	value, ok := inputMap[name]
+	if !ok {
+		return "", false
+	}
+	if s, ok := value.(string); ok {
+		return s, true
+	}
+	data, _ := json.Marshal(value)
+	return string(data), true
+}
+
+func isTextContentType(contentType string) bool {
+	if contentType == "" || strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+	for _, keyword := range []string{"json", "xml", "javascript", "x-www-form-urlencoded"} {
+		if strings.Contains(contentType, keyword) {
+			return true
+		}
 	}
+	return false
 }
 
-func parseHTMLContent(response *http.Response) (string, error) {
-	node, err := html.Parse(response.Body)
+func parseHTMLContent(reader io.Reader) (string, error) {
+	node, err := html.Parse(reader)
 	if err != nil {
 		return "", err
 	}
@@ -103,13 +262,13 @@ func parseHTMLContent(response *http.Response) (string, error) {
 	return content, nil
 }
 
-func httpRequest(urlStr string, method string) (*http.Response, error) {
+func httpRequest(ctx context.Context, urlStr string, method string) (*http.Response, error) {
 	u, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, err
 	}
 	// http请求
-	request, err := http.NewRequest(method, u.String(), nil)
+	request, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,33 @@
+package workflow
+
+import "testing"
+
+func TestInterpolateUrl(t *testing.T) {
+	inputMap := map[string]any{
+		"url":  "https://example.com/api?x=1",
+		"base": "https://example.com",
+		"q":    "a b&c=d",
+		"path": "../admin/users",
+		"id":   float64(42),
+	}
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"whole url", "{{url}}", "https://example.com/api?x=1"},
+		{"base url", "{{base}}/search/{{q}}", "https://example.com/search/a%20b&c=d"},
+		{"path traversal", "https://example.com/files/{{path}}", "https://example.com/files/..%2Fadmin%2Fusers"},
+		{"query value", "https://example.com/search?q={{q}}", "https://example.com/search?q=a+b%26c%3Dd"},
+		{"query after base", "{{base}}/search?q={{q}}&id={{id}}", "https://example.com/search?q=a+b%26c%3Dd&id=42"},
+		{"fragment", "https://example.com/#{{q}}", "https://example.com/#a+b%26c%3Dd"},
+		{"missing variable", "https://example.com/{{missing}}", "https://example.com/{{missing}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interpolateUrl(tt.text, inputMap); got != tt.want {
+				t.Errorf("interpolateUrl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
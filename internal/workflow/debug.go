@@ -0,0 +1,100 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"slices"
+	"time"
+)
+
+// ErrInvalidDebugRequest 调试请求的节点不能执行，错误信息会包含具体原因
+var ErrInvalidDebugRequest = errors.New("无效的调试请求")
+
+// DebugNode 单独执行一个节点，输入变量使用请求中的值，不创建流程实例，执行记录和token用量不会保存。
+// 依赖流程实例的节点（开始、结束、条件、人工处理、迭代、子流程）不能单独调试，
+// 写入知识库和HTTP请求节点会产生真实的写入和外部请求，需要请求中明确允许
+func (e *Engine) DebugNode(ctx context.Context, request *model.DebugNodeRequest) (*model.DebugNodeResult, error) {
+	node := request.Node
+	if node == nil {
+		return nil, fmt.Errorf("%w: 缺少节点", ErrInvalidDebugRequest)
+	}
+	switch node.Type {
+	case model.NodeTypeStart, model.NodeTypeEnd, model.NodeTypeCondition, model.NodeTypeHumanTask,
+		model.NodeTypeIteration, model.NodeTypeSubWorkflow:
+		return nil, fmt.Errorf("%w: 不支持单独调试的节点类型: %s", ErrInvalidDebugRequest, node.Type)
+	case model.NodeTypeKnowledgeWrite, model.NodeTypeCrawler:
+		if !request.AllowSideEffects {
+			return nil, fmt.Errorf("%w: 节点%s会写入数据或发送外部请求，需要设置allowSideEffects", ErrInvalidDebugRequest,
+				node.Data.Name)
+		}
+	case "":
+		return nil, fmt.Errorf("%w: 缺少节点类型", ErrInvalidDebugRequest)
+	}
+	// 节点配置缺失时执行节点会panic，提前返回配置错误
+	v := &validator{}
+	v.validateNodeData(node)
+	if idx := slices.IndexFunc(v.diagnostics, isErrorDiagnostic); idx != -1 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDebugRequest, v.diagnostics[idx].Message)
+	}
+	node, masker, err := e.resolveSecrets(ctx, node, request.TemplateId)
+	if err != nil {
+		return nil, err
+	}
+	inputMap := make(map[string]any)
+	for _, variable := range node.Data.Input {
+		if value, ok := request.Inputs[variable.Name]; ok {
+			inputMap[variable.Name] = value
+			continue
+		}
+		if variable.Value.Type == model.VarValueTypeLiteral {
+			inputMap[variable.Name] = variable.Value.Content
+			continue
+		}
+		if variable.Required {
+			return nil, fmt.Errorf("%w: 缺少必填变量: %s", ErrInvalidDebugRequest, variable.Name)
+		}
+	}
+	policy := node.Data.Policy
+	if policy == nil {
+		policy = &model.ExecutionPolicy{}
+	}
+	nodeInstance := &model.NodeInstance{
+		NodeId:  node.Id,
+		Type:    node.Type,
+		Status:  model.NodeInstanceStatusRunning,
+		AddTime: time.Now(),
+	}
+	trace := &executionTrace{}
+	start := time.Now()
+	// 调试只执行一次，不按执行策略重试
+	err = e.runNodeOnce(withSecretMasker(withExecutionTrace(ctx, trace), masker), node, nodeInstance, inputMap, policy)
+	inputs, _ := json.Marshal(inputMap)
+	result := &model.DebugNodeResult{
+		Status:      model.NodeInstanceStatusCompleted,
+		Inputs:      masker.mask(string(inputs)),
+		Output:      masker.mask(nodeInstance.Output),
+		Prompt:      masker.mask(trace.prompt),
+		RawResponse: masker.mask(trace.response),
+		Latency:     time.Since(start).Milliseconds(),
+		Usage:       &model.TokenUsageSummary{},
+	}
+	if err != nil {
+		result.Status = model.NodeInstanceStatusFailed
+		result.Error = masker.mask(err.Error())
+	}
+	result.StatusName = result.Status.String()
+	if trace.answered != nil {
+		result.ModelId, result.ModelName = trace.answered.ModelId, trace.answered.ModelName
+	}
+	for _, usage := range trace.usages {
+		result.Usage.PromptTokens += usage.PromptTokens
+		result.Usage.CompletionTokens += usage.CompletionTokens
+		result.Usage.TotalTokens += usage.PromptTokens + usage.CompletionTokens
+		result.Usage.Cost += usage.Cost
+		result.Usage.Calls++
+	}
+	return result, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/common"
 	"github.com/StellrisJAY/workflow-ai/internal/config"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"github.com/StellrisJAY/workflow-ai/internal/rag"
@@ -27,33 +28,111 @@ type Engine struct {
 	conf         *config.Config
 	fileRepo     *repo.FileRepo
 	fileStore    fs.FileStore
+	templateRepo *repo.TemplateRepo
+	secretRepo   *repo.SecretRepo
+	secretCipher *common.SecretCipher
 
-	instanceMsgChan sync.Map
+	instanceCtx sync.Map // 流程实例的上下文，用于取消正在执行的节点
+	plans       sync.Map // 流程实例id -> *ExecutionPlan
+	scheduler   *Scheduler
+	events      *EventBus
+	callbacks   *CallbackSender
+}
+
+type instanceContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewEngine(instanceRepo *repo.InstanceRepo, modelRepo *repo.ProviderRepo, snowflake *snowflake.Node,
 	tm *repo.TransactionManager, kbRepo *repo.KnowledgeBaseRepo, rag *rag.DocumentProcessor, conf *config.Config,
-	fileRepo *repo.FileRepo, fileStore fs.FileStore) *Engine {
-	return &Engine{
-		instanceRepo:    instanceRepo,
-		tm:              tm,
-		snowflake:       snowflake,
-		modelRepo:       modelRepo,
-		kbRepo:          kbRepo,
-		rag:             rag,
-		conf:            conf,
-		fileRepo:        fileRepo,
-		fileStore:       fileStore,
-		instanceMsgChan: sync.Map{},
+	fileRepo *repo.FileRepo, fileStore fs.FileStore, templateRepo *repo.TemplateRepo, secretRepo *repo.SecretRepo,
+	secretCipher *common.SecretCipher) *Engine {
+	e := &Engine{
+		instanceRepo: instanceRepo,
+		tm:           tm,
+		snowflake:    snowflake,
+		modelRepo:    modelRepo,
+		kbRepo:       kbRepo,
+		rag:          rag,
+		conf:         conf,
+		fileRepo:     fileRepo,
+		fileStore:    fileStore,
+		templateRepo: templateRepo,
+		secretRepo:   secretRepo,
+		secretCipher: secretCipher,
+		instanceCtx:  sync.Map{},
+		plans:        sync.Map{},
+	}
+	pollInterval := time.Duration(conf.Workflow.PollInterval) * time.Second
+	e.events = NewEventBus(instanceRepo, snowflake, pollInterval)
+	e.callbacks = NewCallbackSender(instanceRepo, tm, snowflake, conf.Workflow.CallbackSecret, pollInterval)
+	leaseDuration := time.Duration(conf.Workflow.LeaseDuration) * time.Second
+	e.scheduler = NewScheduler(instanceRepo, newExecutorId(conf.Server.Id), conf.Workflow.Workers, pollInterval,
+		leaseDuration, e.handleNodeInstance)
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	go e.watchFinishedInstances(pollInterval)
+	return e
+}
+
+// watchFinishedInstances 定期检查本服务中运行的流程实例，流程在其他服务实例上被取消或失败时中断本地正在执行的节点
+func (e *Engine) watchFinishedInstances(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		var ids []int64
+		e.instanceCtx.Range(func(key, _ any) bool {
+			ids = append(ids, key.(int64))
+			return true
+		})
+		if len(ids) == 0 {
+			continue
+		}
+		finished, err := e.instanceRepo.ListNotRunningWorkflowInstanceIds(context.Background(), ids)
+		if err != nil {
+			log.Println("list finished workflow instances error:", err)
+			continue
+		}
+		for _, id := range finished {
+			e.releaseInstance(id)
+		}
 	}
 }
 
+// Start 启动流程，callbackUrl为空时使用模板的默认回调地址
 func (e *Engine) Start(ctx context.Context, defJSON string, templateId int64, addUser int64,
-	input map[string]any, msgChan chan model.WorkflowExecuteMessage) (int64, error) {
+	input map[string]any, callbackUrl string) (int64, error) {
+	if callbackUrl == "" && templateId != 0 {
+		template, err := e.templateRepo.GetDetail(ctx, templateId)
+		if err != nil {
+			return 0, err
+		}
+		if template != nil {
+			callbackUrl = template.CallbackUrl
+		}
+	}
+	if callbackUrl != "" && e.conf.Workflow.CallbackSecret == "" {
+		return 0, errCallbackSecretNotConfigured
+	}
+	return e.startWorkflow(ctx, &model.WorkflowInstance{
+		TemplateId:  templateId,
+		Data:        defJSON,
+		AddUser:     addUser,
+		CallbackUrl: callbackUrl,
+	}, input)
+}
+
+// startWorkflow 创建流程实例和开始节点实例并调度后续节点，instance中需要设置流程定义、模板、创建人以及父流程信息
+func (e *Engine) startWorkflow(ctx context.Context, instance *model.WorkflowInstance, input map[string]any) (int64, error) {
 	var definition model.WorkflowDefinition
-	if err := json.Unmarshal([]byte(defJSON), &definition); err != nil {
+	if err := json.Unmarshal([]byte(instance.Data), &definition); err != nil {
 		return 0, fmt.Errorf("invalid workflow definition")
 	}
+	if err := CheckDefinition(&definition); err != nil {
+		return 0, err
+	}
 	idx := slices.IndexFunc(definition.Nodes, func(n *model.Node) bool { return n.Type == model.NodeTypeStart })
 	if idx == -1 {
 		return 0, fmt.Errorf("missing start node")
@@ -70,15 +149,10 @@ func (e *Engine) Start(ctx context.Context, defJSON string, templateId int64, ad
 			return 0, fmt.Errorf("缺少必填变量: %s", variable.Name)
 		}
 	}
-	instance := &model.WorkflowInstance{
-		Id:           e.snowflake.Generate().Int64(),
-		TemplateId:   templateId,
-		Data:         defJSON,
-		Status:       model.WorkflowInstanceStatusRunning,
-		AddTime:      time.Now(),
-		AddUser:      addUser,
-		CompleteTime: time.Now(),
-	}
+	instance.Id = e.snowflake.Generate().Int64()
+	instance.Status = model.WorkflowInstanceStatusRunning
+	instance.AddTime = time.Now()
+	instance.CompleteTime = time.Now()
 	// 创建开始节点实例，把传入开始节点的参数用json保存
 	inputJSON, _ := json.Marshal(input)
 	startNodeInstance := &model.NodeInstance{
@@ -105,10 +179,8 @@ func (e *Engine) Start(ctx context.Context, defJSON string, templateId int64, ad
 	if err != nil {
 		return 0, err
 	}
-	if msgChan != nil {
-		e.instanceMsgChan.Store(instance.Id, msgChan)
-	}
-	if err := e.stepWorkflow(ctx, startNode, instance.Id); err != nil {
+	e.plans.Store(instance.Id, CompilePlan(&definition))
+	if err := e.stepWorkflow(e.instanceContext(instance.Id), startNode, instance.Id); err != nil {
 		return 0, err
 	}
 	return instance.Id, nil
@@ -116,75 +188,392 @@ func (e *Engine) Start(ctx context.Context, defJSON string, templateId int64, ad
 
 func (e *Engine) LookupInputVariables(ctx context.Context, variableDef []model.Input, workflowId int64) (map[string]any, error) {
 	result := make(map[string]any)
-	nodeInstancesCache := make(map[string]*model.NodeInstance)
+	// 迭代子流程中的节点可以引用当前迭代的元素、同一次迭代中的子节点以及外层流程的节点
+	scope := iterationScopeFrom(ctx)
+	var sourceNodeIds, iterationNodeIds []string
+	useSystemVariables := false
 	for _, variable := range variableDef {
 		if variable.Value.Type == model.VarValueTypeLiteral {
-			result[variable.Name] = variable.Value.Content
 			continue
 		}
-		originNodeId, originVarName := variable.Value.SourceNode, variable.Value.SourceName
-		var originNodeInstance *model.NodeInstance
-		if n, ok := nodeInstancesCache[originNodeId]; ok {
-			originNodeInstance = n
-		} else {
-			originNodeInstance, _ = e.instanceRepo.GetNodeInstanceByNodeId(ctx, workflowId, originNodeId)
-			if originNodeInstance == nil {
-				continue
+		sourceNode := variable.Value.SourceNode
+		switch {
+		case sourceNode == model.SystemVariableNodeId:
+			useSystemVariables = true
+		case scope != nil && sourceNode == scope.nodeId:
+		case scope != nil && scope.plan.Node(sourceNode) != nil:
+			if !slices.Contains(iterationNodeIds, sourceNode) {
+				iterationNodeIds = append(iterationNodeIds, sourceNode)
+			}
+		default:
+			if !slices.Contains(sourceNodeIds, sourceNode) {
+				sourceNodeIds = append(sourceNodeIds, sourceNode)
 			}
-			nodeInstancesCache[originNodeId] = originNodeInstance
 		}
-		var inputMap map[string]any
-		_ = json.Unmarshal([]byte(originNodeInstance.Output), &inputMap)
-		if value, ok := inputMap[originVarName]; ok {
+	}
+	// 一次查询所有引用的来源节点实例
+	var nodeInstances []*model.NodeInstance
+	if len(sourceNodeIds) > 0 {
+		instances, err := e.instanceRepo.ListNodeInstancesByNodeIds(ctx, workflowId, sourceNodeIds)
+		if err != nil {
+			return nil, err
+		}
+		nodeInstances = append(nodeInstances, instances...)
+	}
+	if len(iterationNodeIds) > 0 {
+		instances, err := e.instanceRepo.ListIterationNodeInstances(ctx, scope.parentId, scope.index, iterationNodeIds)
+		if err != nil {
+			return nil, err
+		}
+		nodeInstances = append(nodeInstances, instances...)
+	}
+	outputs := make(map[string]map[string]any)
+	for _, nodeInstance := range nodeInstances {
+		var output map[string]any
+		_ = json.Unmarshal([]byte(nodeInstance.Output), &output)
+		outputs[nodeInstance.NodeId] = output
+	}
+	if scope != nil {
+		outputs[scope.nodeId] = map[string]any{"item": scope.item, "index": scope.index}
+	}
+	if useSystemVariables {
+		sysVariables, err := e.lookupSystemVariables(ctx, workflowId)
+		if err != nil {
+			return nil, err
+		}
+		outputs[model.SystemVariableNodeId] = sysVariables
+	}
+	for _, variable := range variableDef {
+		if variable.Value.Type == model.VarValueTypeLiteral {
+			result[variable.Name] = variable.Value.Content
+			continue
+		}
+		output, ok := outputs[variable.Value.SourceNode]
+		if !ok {
+			continue
+		}
+		if value, ok := output[variable.Value.SourceName]; ok {
 			result[variable.Name] = value
 		}
 	}
 	return result, nil
 }
 
+// Cancel 取消运行中的流程实例，停止调度后续节点并中断正在执行的节点
+func (e *Engine) Cancel(ctx context.Context, workflowId int64) error {
+	ok, err := e.instanceRepo.FinishWorkflowInstance(ctx, &model.WorkflowInstance{
+		Id:           workflowId,
+		Status:       model.WorkflowInstanceStatusCancelled,
+		CompleteTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("流程实例不在运行中")
+	}
+	// 中断正在执行的节点
+	e.releaseInstance(workflowId)
+	if err := e.instanceRepo.CancelNodeInstances(ctx, workflowId, "流程已取消",
+		model.NodeInstanceStatusRunning, model.NodeInstanceStatusQueued, model.NodeInstanceStatusWaiting); err != nil {
+		log.Println("cancel node instances error:", err)
+	}
+	if err := e.instanceRepo.CancelHumanTasks(ctx, workflowId); err != nil {
+		log.Println("cancel human tasks error:", err)
+	}
+	e.publishFinalEvent(workflowId, model.WorkflowExecuteMessage{
+		WorkflowStatus:     model.WorkflowInstanceStatusCancelled,
+		WorkflowStatusName: model.WorkflowInstanceStatusCancelled.String(),
+	})
+	e.cancelChildWorkflows(ctx, workflowId)
+	e.resumeParentWorkflow(ctx, workflowId)
+	return nil
+}
+
+// Retry 从失败的节点继续执行失败或已取消的流程实例，已完成节点的输出会被保留，不会重复执行
+func (e *Engine) Retry(ctx context.Context, workflowId int64, request *model.RetryWorkflowRequest) error {
+	instance, err := e.instanceRepo.GetWorkflowInstance(ctx, workflowId)
+	if err != nil || instance == nil {
+		return errors.New("流程实例不存在")
+	}
+	if instance.Status != model.WorkflowInstanceStatusFailed && instance.Status != model.WorkflowInstanceStatusCancelled {
+		return errors.New("只能重试失败或已取消的流程实例")
+	}
+	nodeStatusList, err := e.instanceRepo.ListNodeInstanceStatus(ctx, workflowId)
+	if err != nil {
+		return err
+	}
+	nodeStatus := make(map[string]model.NodeInstanceStatus)
+	for _, status := range nodeStatusList {
+		// 取消或失败后还未结束的节点，租约过期的节点所在的服务实例已经停止，重试时重新排队
+		if status.Status == model.NodeInstanceStatusRunning && status.ClaimedUntil != nil &&
+			status.ClaimedUntil.After(time.Now()) {
+			return errors.New("流程实例还有正在执行的节点，请稍后重试")
+		}
+		nodeStatus[status.NodeId] = status.Status
+	}
+	var definition model.WorkflowDefinition
+	if err := json.Unmarshal([]byte(instance.Data), &definition); err != nil {
+		return errors.New("invalid workflow definition")
+	}
+	if request.NodeId != "" {
+		node := FindNodeById(&definition, request.NodeId)
+		if node == nil {
+			return fmt.Errorf("node not found: %s", request.NodeId)
+		}
+		if nodeStatus[node.Id] == model.NodeInstanceStatusCompleted {
+			return errors.New("节点已执行完成，不能修改配置")
+		}
+		if request.NodeData != nil {
+			node.Data = *request.NodeData
+		}
+		for name, value := range request.Inputs {
+			idx := slices.IndexFunc(node.Data.Input, func(input model.Input) bool { return input.Name == name })
+			if idx == -1 {
+				return fmt.Errorf("节点不存在输入变量: %s", name)
+			}
+			node.Data.Input[idx].Value = model.Value{Type: model.VarValueTypeLiteral, Content: value}
+		}
+		// 修改后的节点配置与保存模板时一样需要校验
+		if err := CheckDefinition(&definition); err != nil {
+			return err
+		}
+	}
+	data, _ := json.Marshal(definition)
+	err = e.tm.Tx(ctx, func(ctx context.Context) error {
+		ok, err := e.instanceRepo.ResumeWorkflowInstance(ctx, workflowId, string(data))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("只能重试失败或已取消的流程实例")
+		}
+		return e.instanceRepo.RequeueFailedNodeInstances(ctx, workflowId)
+	})
+	if err != nil {
+		return err
+	}
+	// 节点配置可能被修改，按恢复后的版本重新编译执行计划
+	plan := CompilePlan(&definition)
+	plan.revision = instance.Revision + 1
+	e.plans.Store(workflowId, plan)
+	// 流程结束时没有调度的后续节点，按已完成的节点重新调度
+	if err := e.resumeWorkflow(ctx, workflowId); err != nil {
+		e.UpdateWorkflowFailed(context.WithoutCancel(ctx), workflowId)
+		return err
+	}
+	e.scheduler.Notify()
+	return nil
+}
+
+// instanceContext 获取流程实例的上下文，流程取消或结束时该上下文会被取消
+func (e *Engine) instanceContext(workflowId int64) context.Context {
+	if v, ok := e.instanceCtx.Load(workflowId); ok {
+		return v.(*instanceContext).ctx
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	v, loaded := e.instanceCtx.LoadOrStore(workflowId, &instanceContext{ctx: ctx, cancel: cancel})
+	if loaded {
+		cancel()
+	}
+	return v.(*instanceContext).ctx
+}
+
+// releaseInstance 取消并释放流程实例的上下文和执行计划
+func (e *Engine) releaseInstance(workflowId int64) {
+	if v, ok := e.instanceCtx.LoadAndDelete(workflowId); ok {
+		v.(*instanceContext).cancel()
+	}
+	e.plans.Delete(workflowId)
+}
+
+// publishFinalEvent 发布流程结束事件并关闭流程的所有订阅
+func (e *Engine) publishFinalEvent(workflowId int64, msg model.WorkflowExecuteMessage) {
+	msg.WorkflowId = workflowId
+	e.events.Publish(context.Background(), msg, true)
+	e.events.Close(workflowId)
+}
+
 func (e *Engine) executeNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance) {
-	defer func() {
-		if r := recover(); r != nil {
-			err := r.(error)
-			nodeInstance.Status = model.NodeInstanceStatusFailed
-			nodeInstance.CompleteTime = time.Now()
-			nodeInstance.Error = err.Error()
-			nodeInstance.Output = "{}"
-			if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
+	err := e.runNode(ctx, node, nodeInstance)
+	// 流程被取消时上下文已失效，使用不会被取消的上下文记录节点状态
+	dbCtx := context.WithoutCancel(ctx)
+	nodeInstance.CompleteTime = time.Now()
+	if err != nil {
+		nodeInstance.Output = "{}"
+		if ctx.Err() != nil {
+			nodeInstance.Status = model.NodeInstanceStatusCancelled
+			nodeInstance.Error = "流程已取消"
+			if _, err := e.instanceRepo.FinishRunningNodeInstance(dbCtx, nodeInstance); err != nil {
 				log.Println("update node instance failed", err)
 			}
-			e.UpdateWorkflowFailed(ctx, nodeInstance.WorkflowId)
+			return
+		}
+		nodeInstance.Status = model.NodeInstanceStatusFailed
+		nodeInstance.Error = err.Error()
+		ok, err := e.instanceRepo.FinishRunningNodeInstance(dbCtx, nodeInstance)
+		if err != nil {
+			log.Println("update node instance failed", err)
+		}
+		// 节点已经被取消
+		if err == nil && !ok {
+			return
+		}
+		e.UpdateWorkflowFailed(dbCtx, nodeInstance.WorkflowId)
+		return
+	}
+	// 等待人工处理的节点在任务完成后才会调度后续节点
+	waiting := nodeInstance.Status == model.NodeInstanceStatusWaiting
+	if !waiting {
+		nodeInstance.Status = model.NodeInstanceStatusCompleted
+	}
+	ok, err := e.instanceRepo.FinishRunningNodeInstance(dbCtx, nodeInstance)
+	if err != nil {
+		log.Println("update node instance failed", err)
+		e.UpdateWorkflowFailed(dbCtx, nodeInstance.WorkflowId)
+		return
+	}
+	// 流程在节点执行期间被取消，节点已标记为取消，不再调度后续节点
+	if !ok {
+		return
+	}
+	if node.Type == model.NodeTypeEnd {
+		e.completeWorkflow(dbCtx, node, nodeInstance)
+		return
+	}
+	e.sendNodeMessage(nodeInstance)
+	if waiting {
+		// 子流程可能在节点进入等待状态前就已经结束
+		if node.Type == model.NodeTypeSubWorkflow {
+			e.syncSubWorkflow(dbCtx, nodeInstance)
+		}
+		return
+	}
+	if err := e.stepWorkflow(ctx, node, nodeInstance.WorkflowId); err != nil {
+		log.Println("step workflow error:", err)
+		e.UpdateWorkflowFailed(dbCtx, nodeInstance.WorkflowId)
+	}
+}
+
+// sendNodeMessage 发布节点状态变化事件
+func (e *Engine) sendNodeMessage(nodeInstance *model.NodeInstance) {
+	e.events.Publish(context.Background(), model.WorkflowExecuteMessage{
+		WorkflowId:         nodeInstance.WorkflowId,
+		NodeId:             nodeInstance.NodeId,
+		NodeStatus:         nodeInstance.Status,
+		NodeStatusName:     nodeInstance.Status.String(),
+		WorkflowStatus:     model.WorkflowInstanceStatusRunning,
+		WorkflowStatusName: model.WorkflowInstanceStatusRunning.String(),
+		Output:             nodeInstance.Output,
+		Error:              nodeInstance.Error,
+	}, true)
+}
+
+// handleNodeInstance 执行调度器领取的节点实例
+func (e *Engine) handleNodeInstance(nodeInstance *model.NodeInstance) {
+	ctx := e.instanceContext(nodeInstance.WorkflowId)
+	plan, err := e.refreshPlan(ctx, nodeInstance.WorkflowId)
+	var node *model.Node
+	if err == nil {
+		if node = plan.Node(nodeInstance.NodeId); node == nil {
+			err = fmt.Errorf("node not found: %s", nodeInstance.NodeId)
 		}
-	}()
-	inputMap, err := e.LookupInputVariables(ctx, node.Data.Input, nodeInstance.WorkflowId)
+	}
+	if err != nil {
+		nodeInstance.Status = model.NodeInstanceStatusFailed
+		nodeInstance.Error = err.Error()
+		nodeInstance.Output = "{}"
+		nodeInstance.CompleteTime = time.Now()
+		dbCtx := context.WithoutCancel(ctx)
+		if ok, err := e.instanceRepo.FinishRunningNodeInstance(dbCtx, nodeInstance); err != nil {
+			log.Println("update node instance failed", err)
+		} else if !ok {
+			return
+		}
+		e.UpdateWorkflowFailed(dbCtx, nodeInstance.WorkflowId)
+		return
+	}
+	e.executeNode(ctx, node, nodeInstance)
+}
+
+// Recover 服务启动时恢复运行中的流程实例：租约已过期的节点重新排队，本服务中断前领取的节点在租约过期后由调度器重新排队，
+// 已完成节点的后续节点如果还没有被调度则补齐调度
+func (e *Engine) Recover(ctx context.Context) error {
+	requeued, err := e.scheduler.RequeueExpired(ctx)
+	if err != nil {
+		return err
+	}
+	workflowIds, err := e.instanceRepo.ListRunningWorkflowInstanceIds(ctx)
+	if err != nil {
+		return err
+	}
+	for _, workflowId := range workflowIds {
+		if err := e.resumeWorkflow(ctx, workflowId); err != nil {
+			log.Printf("resume workflow %d error: %v", workflowId, err)
+			e.UpdateWorkflowFailed(ctx, workflowId)
+		}
+	}
+	log.Printf("recovered %d running workflow instances, requeued %d node instances", len(workflowIds), requeued)
+	e.scheduler.Notify()
+	return nil
+}
+
+// resumeWorkflow 对流程实例中已完成和已跳过的节点重新调度后续节点，已有节点实例的节点不会被重复调度
+func (e *Engine) resumeWorkflow(ctx context.Context, workflowId int64) error {
+	plan, err := e.refreshPlan(ctx, workflowId)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	nodeInstances, err := e.instanceRepo.ListFinishedNodeInstances(ctx, workflowId)
+	if err != nil {
+		return err
+	}
+	instanceCtx := e.instanceContext(workflowId)
+	for _, nodeInstance := range nodeInstances {
+		node := plan.Node(nodeInstance.NodeId)
+		if node == nil {
+			return fmt.Errorf("node not found: %s", nodeInstance.NodeId)
+		}
+		// 结束节点已完成但流程状态未更新
+		if node.Type == model.NodeTypeEnd && nodeInstance.Status == model.NodeInstanceStatusCompleted {
+			e.completeWorkflow(ctx, node, nodeInstance)
+			return nil
+		}
+		if err := e.stepWorkflow(instanceCtx, node, workflowId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatchNode 根据节点类型执行节点，节点输出写入节点实例，执行失败时panic
+func (e *Engine) dispatchNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
+	inputMap map[string]any) {
 	switch node.Type {
 	case model.NodeTypeLLM:
 		llmNodeData := node.Data.LLMNodeData
 		if llmNodeData == nil {
 			panic(errors.New("invalid Model node data"))
 		}
-		e.executeLLMNode(context.TODO(), node, nodeInstance, llmNodeData, inputMap)
+		e.executeLLMNode(ctx, node, nodeInstance, llmNodeData, inputMap)
 	case model.NodeTypeEnd:
 		endNodeData := node.Data.EndNodeData
 		if endNodeData == nil {
 			panic(errors.New("invalid end node data"))
 		}
-		e.executeEndNode(context.TODO(), node, nodeInstance)
+		e.executeEndNode(ctx, node, nodeInstance, inputMap)
 	case model.NodeTypeCrawler:
 		crawlerNodeData := node.Data.CrawlerNodeData
 		if crawlerNodeData == nil {
 			panic(errors.New("invalid crawler node data"))
 		}
-		e.executeCrawlerNode(context.TODO(), node, nodeInstance, crawlerNodeData, inputMap)
+		e.executeCrawlerNode(ctx, node, nodeInstance, crawlerNodeData, inputMap)
 	case model.NodeTypeCondition:
 		conditionNodeData := node.Data.ConditionNodeData
 		if conditionNodeData == nil {
 			panic(errors.New("invalid condition node data"))
 		}
-		if err := e.executeConditionNode(context.TODO(), node, conditionNodeData, nodeInstance); err != nil {
+		if err := e.executeConditionNode(ctx, node, conditionNodeData, nodeInstance); err != nil {
 			panic(err)
 		}
 	case model.NodeTypeKnowledgeRetrieval:
@@ -192,113 +581,137 @@ func (e *Engine) executeNode(ctx context.Context, node *model.Node, nodeInstance
 		if kbRetrievalNodeData == nil {
 			panic(errors.New("invalid knowledge base node data"))
 		}
-		e.executeKnowledgeRetrieveNode(context.TODO(), node, kbRetrievalNodeData, nodeInstance)
+		e.executeKnowledgeRetrieveNode(ctx, node, kbRetrievalNodeData, nodeInstance, inputMap)
+	case model.NodeTypeKnowledgeWrite:
+		kbWriteNodeData := node.Data.KnowledgeBaseWriteNodeData
+		if kbWriteNodeData == nil {
+			panic(errors.New("invalid knowledge base write node data"))
+		}
+		e.executeKnowledgeWriteNode(ctx, node, nodeInstance, kbWriteNodeData, inputMap)
 	case model.NodeTypeWebSearch:
 		webSearchNodeData := node.Data.WebSearchNodeData
 		if webSearchNodeData == nil {
 			panic(errors.New("invalid webSearch Node Data"))
 		}
-		e.executeWebSearchNode(context.TODO(), node, nodeInstance, webSearchNodeData, inputMap)
+		e.executeWebSearchNode(ctx, node, nodeInstance, webSearchNodeData, inputMap)
 	case model.NodeTypeKeywordExtraction:
 		keywordExtractionNodeData := node.Data.KeywordExtractionNodeData
 		if keywordExtractionNodeData == nil {
 			panic(errors.New("invalid keyword extraction node data"))
 		}
-		e.executeKeywordExtractionNode(context.TODO(), node, nodeInstance, keywordExtractionNodeData, inputMap)
+		e.executeKeywordExtractionNode(ctx, node, nodeInstance, keywordExtractionNodeData, inputMap)
 	case model.NodeTypeQuestionOptimization:
 		llmTaskNodeData := node.Data.QuestionOptimizationNodeData
 		if llmTaskNodeData == nil {
 			panic(errors.New("invalid llm task node data"))
 		}
-		e.executeQuestionOptimizeNode(context.TODO(), node, nodeInstance, llmTaskNodeData, inputMap)
+		e.executeQuestionOptimizeNode(ctx, node, nodeInstance, llmTaskNodeData, inputMap)
 	case model.NodeTypeImageUnderstanding:
 		nodeData := node.Data.ImageUnderstandingNodeData
 		if nodeData == nil {
 			panic(errors.New("invalid image understanding node data"))
 		}
-		e.executeImageUnderstandingNode(context.TODO(), node, nodeInstance, nodeData, inputMap)
+		e.executeImageUnderstandingNode(ctx, node, nodeInstance, nodeData, inputMap)
 	case model.NodeTypeOCR:
 		nodeData := node.Data.OCRNodeData
 		if nodeData == nil {
 			panic(errors.New("invalid ocr node data"))
 		}
-		e.executeOCRNode(context.TODO(), node, nodeInstance, nodeData, inputMap)
-	}
-	if nodeInstance.Status != model.NodeInstanceStatusFailed {
-		msgChan, ok := e.instanceMsgChan.Load(nodeInstance.WorkflowId)
-		if ok {
-			msgChan.(chan model.WorkflowExecuteMessage) <- model.WorkflowExecuteMessage{
-				NodeId:             node.Id,
-				NodeStatus:         nodeInstance.Status,
-				NodeStatusName:     nodeInstance.Status.String(),
-				WorkflowStatus:     model.WorkflowInstanceStatusRunning,
-				WorkflowStatusName: model.WorkflowInstanceStatusRunning.String(),
-				Output:             nodeInstance.Output,
-				Error:              nodeInstance.Error,
-			}
+		e.executeOCRNode(ctx, node, nodeInstance, nodeData, inputMap)
+	case model.NodeTypeHumanTask:
+		nodeData := node.Data.HumanTaskNodeData
+		if nodeData == nil {
+			panic(errors.New("invalid human task node data"))
 		}
-		// 条件节点已经推进了流程，不需要再执行后续节点
-		if nodeInstance.Type != model.NodeTypeCondition && nodeInstance.Type != model.NodeTypeEnd {
-			if err := e.stepWorkflow(context.TODO(), node, nodeInstance.WorkflowId); err != nil {
-				panic(err)
-			}
+		e.executeHumanTaskNode(ctx, node, nodeInstance, nodeData, inputMap)
+	case model.NodeTypeIteration:
+		nodeData := node.Data.IterationNodeData
+		if nodeData == nil {
+			panic(errors.New("invalid iteration node data"))
+		}
+		e.executeIterationNode(ctx, node, nodeInstance, nodeData, inputMap)
+	case model.NodeTypeSubWorkflow:
+		nodeData := node.Data.SubWorkflowNodeData
+		if nodeData == nil {
+			panic(errors.New("invalid sub workflow node data"))
 		}
-	} else {
-		e.UpdateWorkflowFailed(context.TODO(), nodeInstance.WorkflowId)
+		e.executeSubWorkflowNode(ctx, node, nodeInstance, nodeData, inputMap)
+	case model.NodeTypeCode:
+		nodeData := node.Data.CodeNodeData
+		if nodeData == nil {
+			panic(errors.New("invalid code node data"))
+		}
+		e.executeCodeNode(ctx, node, nodeInstance, nodeData, inputMap)
+	default:
+		panic(fmt.Errorf("unsupported node type: %s", node.Type))
 	}
 }
 
 func (e *Engine) UpdateWorkflowFailed(ctx context.Context, workflowId int64) {
-	if err := e.instanceRepo.UpdateWorkflowInstance(ctx, &model.WorkflowInstance{
+	ok, err := e.instanceRepo.FinishWorkflowInstance(ctx, &model.WorkflowInstance{
 		Id:           workflowId,
 		Status:       model.WorkflowInstanceStatusFailed,
 		CompleteTime: time.Now(),
-	}); err != nil {
+	})
+	if err != nil {
 		log.Println("update workflow instance error:", err)
 	}
+	// 流程已经结束或被取消
+	if !ok {
+		return
+	}
+	e.releaseInstance(workflowId)
+	// 排队中和等待处理的节点不会再被执行
+	if err := e.instanceRepo.CancelNodeInstances(ctx, workflowId, "流程执行失败",
+		model.NodeInstanceStatusQueued, model.NodeInstanceStatusWaiting); err != nil {
+		log.Println("cancel node instances error:", err)
+	}
+	if err := e.instanceRepo.CancelHumanTasks(ctx, workflowId); err != nil {
+		log.Println("cancel human tasks error:", err)
+	}
+	e.publishFinalEvent(workflowId, model.WorkflowExecuteMessage{
+		WorkflowStatus:     model.WorkflowInstanceStatusFailed,
+		WorkflowStatusName: model.WorkflowInstanceStatusFailed.String(),
+	})
+	go e.notifyCallback(workflowId)
+	e.cancelChildWorkflows(ctx, workflowId)
+	e.resumeParentWorkflow(ctx, workflowId)
 }
 
 func (e *Engine) stepWorkflow(ctx context.Context, currNode *model.Node, workflowId int64) error {
-	instance, err := e.instanceRepo.GetWorkflowInstance(ctx, workflowId)
-	if err != nil || instance == nil {
-		return errors.New("can't find flow instance")
+	plan, err := e.getPlan(ctx, workflowId)
+	if err != nil {
+		return err
 	}
-	var definition model.WorkflowDefinition
-	_ = json.Unmarshal([]byte(instance.Data), &definition)
-	nextNodes := GetNextNodes(&definition, currNode)
-	return e.executeNextNodes(ctx, nextNodes, &definition, workflowId)
+	return e.executeNextNodes(ctx, plan.NextNodes(currNode.Id), plan, workflowId)
 }
 
-func (e *Engine) executeNextNodes(ctx context.Context, nextNodes []*model.Node, definition *model.WorkflowDefinition,
+// executeNextNodes 判断后续节点的所有前置节点是否都已完成或跳过：至少有一条入边被执行的节点进入调度队列，
+// 否则标记为跳过，并继续判断跳过节点的后续节点
+func (e *Engine) executeNextNodes(ctx context.Context, nextNodes []*model.Node, plan *ExecutionPlan,
 	workflowId int64) error {
 	if status, err := e.instanceRepo.GetWorkflowInstanceStatus(ctx, workflowId); err != nil {
 		log.Println("get workflow instance status error:", err)
 		return errors.New("get workflow instance status error")
-	} else if status == model.WorkflowInstanceStatusCompleted || status == model.WorkflowInstanceStatusFailed {
-		return errors.New("workflow instance is in completed or failed")
-	}
-	var executableNodes []struct {
-		NodeInstance *model.NodeInstance
-		Node         *model.Node
+	} else if status != model.WorkflowInstanceStatusRunning {
+		// 流程可能在其他服务实例上已经结束，释放本地缓存
+		e.releaseInstance(workflowId)
+		return errors.New("workflow instance is not running")
 	}
+	queued := false
+	var skippedNodes []*model.Node
 	for _, next := range nextNodes {
-		nodes := GetPrevNodes(definition, next)
-		ids := make([]string, len(nodes))
-		for i, node := range nodes {
-			ids[i] = node.Id
-		}
-		count, err := e.instanceRepo.CountRunningNodeInstancesWithNodeIds(ctx, workflowId, ids)
+		ready, runnable, err := e.evaluateJoin(ctx, plan, next, workflowId)
 		if err != nil {
-			log.Println("count running node instance error", err)
-			continue
+			return err
 		}
-		if count > 0 {
+		if !ready {
 			continue
 		}
 		nodeInstance := &model.NodeInstance{
 			Id:           e.snowflake.Generate().Int64(),
 			NodeId:       next.Id,
-			Status:       model.NodeInstanceStatusRunning,
+			Status:       model.NodeInstanceStatusQueued,
 			WorkflowId:   workflowId,
 			AddTime:      time.Now(),
 			CompleteTime: time.Now(),
@@ -306,53 +719,141 @@ func (e *Engine) executeNextNodes(ctx context.Context, nextNodes []*model.Node,
 			Output:       "{}",
 			Error:        "",
 		}
-		if err := e.instanceRepo.InsertNodeInstance(ctx, nodeInstance); err != nil {
+		if !runnable {
+			nodeInstance.Status = model.NodeInstanceStatusUnreached
+		}
+		// 多个前置节点同时完成时，只有一个能创建节点实例
+		inserted, err := e.instanceRepo.InsertNodeInstanceOnce(ctx, nodeInstance)
+		if err != nil {
 			return err
 		}
-		executableNodes = append(executableNodes, struct {
-			NodeInstance *model.NodeInstance
-			Node         *model.Node
-		}{NodeInstance: nodeInstance, Node: next})
+		if !inserted {
+			continue
+		}
+		if runnable {
+			queued = true
+		} else {
+			skippedNodes = append(skippedNodes, next)
+		}
 	}
-
-	for _, executableNode := range executableNodes {
-		go e.executeNode(context.Background(), executableNode.Node, executableNode.NodeInstance)
+	if queued {
+		e.scheduler.Notify()
+	}
+	for _, node := range skippedNodes {
+		if node.Type == model.NodeTypeEnd {
+			if err := e.checkEndNodesSkipped(ctx, plan, workflowId); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := e.executeNextNodes(ctx, plan.NextNodes(node.Id), plan, workflowId); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (e *Engine) executeEndNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance) {
-	// 结束节点的输出与输入相同
-	inputMap, err := e.LookupInputVariables(ctx, node.Data.Input, nodeInstance.WorkflowId)
+// evaluateJoin 判断节点能否被调度。所有前置节点都已完成或跳过时ready为true，
+// 此时只要有一条入边被执行runnable就为true，否则节点应当被跳过
+func (e *Engine) evaluateJoin(ctx context.Context, plan *ExecutionPlan, node *model.Node,
+	workflowId int64) (ready bool, runnable bool, err error) {
+	incoming := plan.IncomingEdges(node.Id)
+	sourceIds := make([]string, len(incoming))
+	for i, edge := range incoming {
+		sourceIds[i] = edge.Source
+	}
+	sourceInstances, err := e.instanceRepo.ListNodeInstancesByNodeIds(ctx, workflowId, sourceIds)
 	if err != nil {
-		panic(err)
+		return false, false, err
 	}
-	outputData, _ := json.Marshal(inputMap)
-	nodeInstance.Output = string(outputData)
-	nodeInstance.CompleteTime = time.Now()
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	msgChan, ok := e.instanceMsgChan.LoadAndDelete(nodeInstance.WorkflowId)
-	if ok {
-		msgChan.(chan model.WorkflowExecuteMessage) <- model.WorkflowExecuteMessage{
-			NodeId:             node.Id,
-			NodeStatus:         nodeInstance.Status,
-			NodeStatusName:     nodeInstance.Status.String(),
-			WorkflowStatus:     model.WorkflowInstanceStatusCompleted,
-			WorkflowStatusName: model.WorkflowInstanceStatusCompleted.String(),
-			Output:             nodeInstance.Output,
-			Error:              nodeInstance.Error,
-		}
-		close(msgChan.(chan model.WorkflowExecuteMessage))
+	ready, runnable = joinIncoming(incoming, sourceInstances)
+	return ready, runnable, nil
+}
+
+// joinIncoming 根据前置节点实例计算汇聚结果：所有前置节点都已完成或被跳过时ready，至少一条入边被执行时runnable
+func joinIncoming(incoming []*model.Edge, sourceInstances []*model.NodeInstance) (ready bool, runnable bool) {
+	instanceMap := make(map[string]*model.NodeInstance)
+	for _, instance := range sourceInstances {
+		instanceMap[instance.NodeId] = instance
 	}
+	for _, edge := range incoming {
+		source, ok := instanceMap[edge.Source]
+		// 前置节点还没有被调度
+		if !ok {
+			return false, false
+		}
+		switch source.Status {
+		case model.NodeInstanceStatusCompleted:
+			if isEdgeTaken(edge, source) {
+				runnable = true
+			}
+		case model.NodeInstanceStatusUnreached:
+		default:
+			return false, false
+		}
+	}
+	return true, runnable
+}
 
-	if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
-		panic(err)
+// isEdgeTaken 带handle的边只有在源节点输出选中该分支时才会被执行，节点进入失败分支时不执行没有handle的边
+func isEdgeTaken(edge *model.Edge, source *model.NodeInstance) bool {
+	var output model.ConditionNodeOutput
+	_ = json.Unmarshal([]byte(source.Output), &output)
+	if edge.SourceHandle == "" {
+		return output.SuccessBranch != model.NodeErrorHandle
+	}
+	return output.SuccessBranch == edge.SourceHandle
+}
+
+// checkEndNodesSkipped 所有结束节点都被跳过时流程无法结束，返回错误使流程失败
+func (e *Engine) checkEndNodesSkipped(ctx context.Context, plan *ExecutionPlan, workflowId int64) error {
+	endIds := plan.EndNodes()
+	instances, err := e.instanceRepo.ListNodeInstancesByNodeIds(ctx, workflowId, endIds)
+	if err != nil {
+		return err
+	}
+	if len(instances) < len(endIds) {
+		return nil
+	}
+	for _, instance := range instances {
+		if instance.Status != model.NodeInstanceStatusUnreached {
+			return nil
+		}
 	}
-	if err := e.instanceRepo.UpdateWorkflowInstance(ctx, &model.WorkflowInstance{
+	return errors.New("所有结束节点都被跳过，流程无法结束")
+}
+
+func (e *Engine) executeEndNode(_ context.Context, _ *model.Node, nodeInstance *model.NodeInstance,
+	inputMap map[string]any) {
+	// 结束节点的输出与输入相同
+	outputData, _ := json.Marshal(inputMap)
+	nodeInstance.Output = string(outputData)
+}
+
+// completeWorkflow 结束节点执行完成后，将流程实例修改为完成状态
+func (e *Engine) completeWorkflow(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance) {
+	ok, err := e.instanceRepo.FinishWorkflowInstance(ctx, &model.WorkflowInstance{
 		Id:           nodeInstance.WorkflowId,
 		Status:       model.WorkflowInstanceStatusCompleted,
 		CompleteTime: time.Now(),
-	}); err != nil {
-		panic(err)
+	})
+	if err != nil {
+		log.Println("update workflow instance error:", err)
+		return
+	}
+	if !ok {
+		return
 	}
+	e.releaseInstance(nodeInstance.WorkflowId)
+	e.publishFinalEvent(nodeInstance.WorkflowId, model.WorkflowExecuteMessage{
+		NodeId:             node.Id,
+		NodeStatus:         nodeInstance.Status,
+		NodeStatusName:     nodeInstance.Status.String(),
+		WorkflowStatus:     model.WorkflowInstanceStatusCompleted,
+		WorkflowStatusName: model.WorkflowInstanceStatusCompleted.String(),
+		Output:             nodeInstance.Output,
+		Error:              nodeInstance.Error,
+	})
+	go e.notifyCallback(nodeInstance.WorkflowId)
+	e.resumeParentWorkflow(ctx, nodeInstance.WorkflowId)
 }
@@ -0,0 +1,70 @@
+package workflow
+
+import (
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"testing"
+)
+
+func completed(nodeId string, output string) *model.NodeInstance {
+	return &model.NodeInstance{NodeId: nodeId, Status: model.NodeInstanceStatusCompleted, Output: output}
+}
+
+func withStatus(nodeId string, status model.NodeInstanceStatus) *model.NodeInstance {
+	return &model.NodeInstance{NodeId: nodeId, Status: status}
+}
+
+func TestIsEdgeTaken(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle string
+		output string
+		want   bool
+	}{
+		{"plain edge", "", `{"answer":"ok"}`, true},
+		{"plain edge empty output", "", "", true},
+		{"plain edge on error branch", "", `{"successBranch":"error"}`, false},
+		{"selected branch", "case-1", `{"successBranch":"case-1"}`, true},
+		{"other branch", "case-2", `{"successBranch":"case-1"}`, false},
+		{"error branch", model.NodeErrorHandle, `{"successBranch":"error"}`, true},
+		{"error branch not selected", model.NodeErrorHandle, `{"answer":"ok"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edge := &model.Edge{Source: "a", Target: "b", SourceHandle: tt.handle}
+			if got := isEdgeTaken(edge, completed("a", tt.output)); got != tt.want {
+				t.Errorf("isEdgeTaken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinIncoming(t *testing.T) {
+	incoming := []*model.Edge{
+		{Source: "a", Target: "c"},
+		{Source: "b", Target: "c", SourceHandle: "case-1"},
+	}
+	tests := []struct {
+		name         string
+		sources      []*model.NodeInstance
+		wantReady    bool
+		wantRunnable bool
+	}{
+		{"source not scheduled", []*model.NodeInstance{completed("a", "{}")}, false, false},
+		{"source running", []*model.NodeInstance{completed("a", "{}"), withStatus("b", model.NodeInstanceStatusRunning)}, false, false},
+		{"source queued", []*model.NodeInstance{completed("a", "{}"), withStatus("b", model.NodeInstanceStatusQueued)}, false, false},
+		{"source failed", []*model.NodeInstance{completed("a", "{}"), withStatus("b", model.NodeInstanceStatusFailed)}, false, false},
+		{"all taken", []*model.NodeInstance{completed("a", "{}"), completed("b", `{"successBranch":"case-1"}`)}, true, true},
+		{"one taken one skipped", []*model.NodeInstance{completed("a", "{}"), withStatus("b", model.NodeInstanceStatusUnreached)}, true, true},
+		{"branch not selected", []*model.NodeInstance{withStatus("a", model.NodeInstanceStatusUnreached), completed("b", `{"successBranch":"case-2"}`)}, true, false},
+		{"all skipped", []*model.NodeInstance{withStatus("a", model.NodeInstanceStatusUnreached), withStatus("b", model.NodeInstanceStatusUnreached)}, true, false},
+		{"error branch", []*model.NodeInstance{completed("a", `{"successBranch":"error"}`), withStatus("b", model.NodeInstanceStatusUnreached)}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, runnable := joinIncoming(incoming, tt.sources)
+			if ready != tt.wantReady || runnable != tt.wantRunnable {
+				t.Errorf("joinIncoming() = (%v, %v), want (%v, %v)", ready, runnable, tt.wantReady, tt.wantRunnable)
+			}
+		})
+	}
+}
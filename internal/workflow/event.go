@@ -0,0 +1,305 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/bwmarrin/snowflake"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// subscriptionBufferSize 订阅通道的缓冲大小，缓冲区满时断开订阅
+const subscriptionBufferSize = 1024
+
+// eventLog 流程事件日志，由流程实例表和事件表实现
+type eventLog interface {
+	InsertWorkflowEvent(ctx context.Context, event *model.WorkflowEvent) error
+	ListWorkflowEvents(ctx context.Context, workflowId int64, afterId int64) ([]*model.WorkflowEvent, error)
+	ListNotRunningWorkflowInstanceIds(ctx context.Context, ids []int64) ([]int64, error)
+}
+
+// Subscription 流程实例事件订阅
+type Subscription struct {
+	workflowId  int64
+	lastEventId int64                             // 已推送的最大事件id，从事件日志中补发之后的事件
+	C           chan model.WorkflowExecuteMessage // 实时事件，流程结束或订阅被断开时关闭
+	Replay      []model.WorkflowExecuteMessage    // 订阅时补发的历史事件
+}
+
+// EventBus 流程事件总线，状态变化事件记录到事件日志后再推送给订阅者。
+// 推送不会阻塞引擎，消费过慢的订阅者会被断开，客户端可以使用最后收到的事件id重新订阅。
+// 流程的节点可能由其他服务实例执行，总线定期从事件日志中补发其他服务实例记录的事件，流程结束后关闭订阅。
+// 流式输出的片段不记录事件日志，只推送给同一服务实例上的订阅者
+type EventBus struct {
+	eventLog     eventLog
+	snowflake    *snowflake.Node
+	pollInterval time.Duration
+	mutex        sync.Mutex
+	subscribers  map[int64]map[*Subscription]struct{}
+	cancel       context.CancelFunc
+}
+
+func NewEventBus(eventLog eventLog, snowflake *snowflake.Node, pollInterval time.Duration) *EventBus {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &EventBus{
+		eventLog:     eventLog,
+		snowflake:    snowflake,
+		pollInterval: pollInterval,
+		subscribers:  make(map[int64]map[*Subscription]struct{}),
+		cancel:       cancel,
+	}
+	go b.tail(ctx)
+	return b
+}
+
+func (b *EventBus) Stop() {
+	b.cancel()
+}
+
+// Publish 推送事件，persist为true时记录到事件日志，重新订阅时可以补发
+func (b *EventBus) Publish(ctx context.Context, msg model.WorkflowExecuteMessage, persist bool) {
+	if persist {
+		msg.EventId = b.snowflake.Generate().Int64()
+		data, _ := json.Marshal(msg)
+		err := b.eventLog.InsertWorkflowEvent(context.WithoutCancel(ctx), &model.WorkflowEvent{
+			Id:         msg.EventId,
+			WorkflowId: msg.WorkflowId,
+			Data:       string(data),
+			AddTime:    time.Now(),
+		})
+		if err != nil {
+			log.Println("insert workflow event error:", err)
+		}
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for sub := range b.subscribers[msg.WorkflowId] {
+		b.sendLocked(sub, msg)
+	}
+}
+
+// sendLocked 推送事件并记录已推送的事件id，订阅者的缓冲区满时断开订阅
+func (b *EventBus) sendLocked(sub *Subscription, msg model.WorkflowExecuteMessage) {
+	select {
+	case sub.C <- msg:
+		sub.lastEventId = max(sub.lastEventId, msg.EventId)
+	default:
+		b.removeLocked(sub)
+	}
+}
+
+// Close 流程结束后关闭所有订阅
+func (b *EventBus) Close(workflowId int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for sub := range b.subscribers[workflowId] {
+		b.removeLocked(sub)
+	}
+}
+
+// Subscribe 订阅流程实例的事件，事件日志中id大于lastEventId的事件会由总线补发
+func (b *EventBus) Subscribe(workflowId int64, lastEventId int64) *Subscription {
+	sub := &Subscription{
+		workflowId:  workflowId,
+		lastEventId: lastEventId,
+		C:           make(chan model.WorkflowExecuteMessage, subscriptionBufferSize),
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.subscribers[workflowId] == nil {
+		b.subscribers[workflowId] = make(map[*Subscription]struct{})
+	}
+	b.subscribers[workflowId][sub] = struct{}{}
+	return sub
+}
+
+// advance 订阅者已经通过Replay收到了eventId及之前的事件，总线不再补发
+func (b *EventBus) advance(sub *Subscription, eventId int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	sub.lastEventId = max(sub.lastEventId, eventId)
+}
+
+func (b *EventBus) Unsubscribe(sub *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.removeLocked(sub)
+}
+
+func (b *EventBus) removeLocked(sub *Subscription) {
+	subs, ok := b.subscribers[sub.workflowId]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	close(sub.C)
+	if len(subs) == 0 {
+		delete(b.subscribers, sub.workflowId)
+	}
+}
+
+// tail 定期从事件日志中补发订阅者还没有收到的事件，流程在任意服务实例上结束后关闭订阅
+func (b *EventBus) tail(ctx context.Context) {
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.poll(ctx)
+		}
+	}
+}
+
+func (b *EventBus) poll(ctx context.Context) {
+	b.mutex.Lock()
+	cursors := make(map[int64]int64, len(b.subscribers))
+	ids := make([]int64, 0, len(b.subscribers))
+	for workflowId, subs := range b.subscribers {
+		cursor := int64(math.MaxInt64)
+		for sub := range subs {
+			cursor = min(cursor, sub.lastEventId)
+		}
+		cursors[workflowId] = cursor
+		ids = append(ids, workflowId)
+	}
+	b.mutex.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+	// 先查询已结束的流程再查询事件，保证关闭订阅前已经补发了流程的最终事件
+	finished, err := b.eventLog.ListNotRunningWorkflowInstanceIds(ctx, ids)
+	if err != nil {
+		log.Println("list finished workflow instances error:", err)
+		return
+	}
+	for _, workflowId := range ids {
+		events, err := b.eventLog.ListWorkflowEvents(ctx, workflowId, cursors[workflowId])
+		if err != nil {
+			log.Println("list workflow events error:", err)
+			continue
+		}
+		b.deliver(workflowId, events)
+	}
+	for _, workflowId := range finished {
+		b.Close(workflowId)
+	}
+}
+
+// deliver 向订阅者推送事件日志中id大于其已推送事件id的事件
+func (b *EventBus) deliver(workflowId int64, events []*model.WorkflowEvent) {
+	if len(events) == 0 {
+		return
+	}
+	messages := make([]model.WorkflowExecuteMessage, 0, len(events))
+	for _, event := range events {
+		var msg model.WorkflowExecuteMessage
+		if err := json.Unmarshal([]byte(event.Data), &msg); err != nil {
+			continue
+		}
+		msg.EventId = event.Id
+		messages = append(messages, msg)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for sub := range b.subscribers[workflowId] {
+		for _, msg := range messages {
+			if msg.EventId <= sub.lastEventId {
+				continue
+			}
+			b.sendLocked(sub, msg)
+			// 缓冲区满时订阅已被断开
+			if _, ok := b.subscribers[workflowId][sub]; !ok {
+				break
+			}
+		}
+	}
+}
+
+// Subscribe 订阅流程实例的事件，补发lastEventId之后的历史事件。流程已经结束时订阅通道直接关闭
+func (e *Engine) Subscribe(ctx context.Context, workflowId int64, lastEventId int64) (*Subscription, error) {
+	// 先订阅再查询历史事件，避免丢失查询期间发布的事件
+	sub := e.events.Subscribe(workflowId, lastEventId)
+	instance, err := e.instanceRepo.GetWorkflowInstance(ctx, workflowId)
+	if err == nil && instance == nil {
+		err = errors.New("流程实例不存在")
+	}
+	if err != nil {
+		e.events.Unsubscribe(sub)
+		return nil, err
+	}
+	events, err := e.instanceRepo.ListWorkflowEvents(ctx, workflowId, lastEventId)
+	if err != nil {
+		e.events.Unsubscribe(sub)
+		return nil, err
+	}
+	finished := false
+	for _, event := range events {
+		var msg model.WorkflowExecuteMessage
+		if err := json.Unmarshal([]byte(event.Data), &msg); err != nil {
+			continue
+		}
+		msg.EventId = event.Id
+		sub.Replay = append(sub.Replay, msg)
+		finished = finished || msg.WorkflowStatus != model.WorkflowInstanceStatusRunning
+	}
+	if len(sub.Replay) > 0 {
+		e.events.advance(sub, sub.Replay[len(sub.Replay)-1].EventId)
+	}
+	if instance.Status != model.WorkflowInstanceStatusRunning {
+		e.events.Unsubscribe(sub)
+		// 流程刚结束时最后一条事件可能还没有记录，补充流程的最终状态
+		if !finished {
+			sub.Replay = append(sub.Replay, model.WorkflowExecuteMessage{
+				WorkflowId:         workflowId,
+				WorkflowStatus:     instance.Status,
+				WorkflowStatusName: instance.Status.String(),
+			})
+		}
+	}
+	return sub, nil
+}
+
+func (e *Engine) Unsubscribe(sub *Subscription) {
+	e.events.Unsubscribe(sub)
+}
+
+// Wait 等待流程实例结束，返回结束后的流程实例，ctx结束时返回ctx的错误
+func (e *Engine) Wait(ctx context.Context, workflowId int64) (*model.WorkflowInstance, error) {
+	for {
+		// 只需要流程结束的通知，不补发历史事件
+		sub, err := e.Subscribe(ctx, workflowId, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		closed := false
+		for !closed {
+			select {
+			case <-ctx.Done():
+				e.events.Unsubscribe(sub)
+				return nil, ctx.Err()
+			case _, ok := <-sub.C:
+				closed = !ok
+			}
+		}
+		// 订阅通道关闭时流程可能已经结束，也可能是订阅被断开
+		instance, err := e.instanceRepo.GetWorkflowInstance(ctx, workflowId)
+		if err != nil {
+			return nil, err
+		}
+		if instance.Status != model.WorkflowInstanceStatusRunning {
+			return instance, nil
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package workflow
+
+import (
+	"context"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/bwmarrin/snowflake"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryEventLog 内存中的事件日志，多个事件总线共享时模拟多个服务实例
+type memoryEventLog struct {
+	mutex    sync.Mutex
+	events   []*model.WorkflowEvent
+	finished map[int64]bool
+}
+
+func newMemoryEventLog() *memoryEventLog {
+	return &memoryEventLog{finished: make(map[int64]bool)}
+}
+
+func (l *memoryEventLog) InsertWorkflowEvent(_ context.Context, event *model.WorkflowEvent) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.events = append(l.events, event)
+	sort.Slice(l.events, func(i, j int) bool { return l.events[i].Id < l.events[j].Id })
+	return nil
+}
+
+func (l *memoryEventLog) ListWorkflowEvents(_ context.Context, workflowId int64,
+	afterId int64) ([]*model.WorkflowEvent, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var result []*model.WorkflowEvent
+	for _, event := range l.events {
+		if event.WorkflowId == workflowId && event.Id > afterId {
+			result = append(result, event)
+		}
+	}
+	return result, nil
+}
+
+func (l *memoryEventLog) ListNotRunningWorkflowInstanceIds(_ context.Context, ids []int64) ([]int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var result []int64
+	for _, id := range ids {
+		if l.finished[id] {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+func (l *memoryEventLog) finish(workflowId int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.finished[workflowId] = true
+}
+
+func newTestEventBus(t *testing.T, eventLog eventLog, nodeId int64) *EventBus {
+	t.Helper()
+	node, err := snowflake.NewNode(nodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewEventBus(eventLog, node, 10*time.Millisecond)
+	t.Cleanup(b.Stop)
+	return b
+}
+
+// receiveAll 读取订阅通道直到关闭
+func receiveAll(t *testing.T, sub *Subscription) []model.WorkflowExecuteMessage {
+	t.Helper()
+	var result []model.WorkflowExecuteMessage
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return result
+			}
+			result = append(result, msg)
+		case <-timeout:
+			t.Fatalf("subscription not closed, received %d events", len(result))
+		}
+	}
+}
+
+func TestEventBusDeliversEventsPublishedByOtherReplicas(t *testing.T) {
+	eventLog := newMemoryEventLog()
+	executor := newTestEventBus(t, eventLog, 1)
+	subscriber := newTestEventBus(t, eventLog, 2)
+	sub := subscriber.Subscribe(100, 0)
+	ctx := context.Background()
+	executor.Publish(ctx, model.WorkflowExecuteMessage{WorkflowId: 100, WorkflowStatus: model.WorkflowInstanceStatusRunning}, true)
+	// 流式输出的片段只推送给本地订阅者
+	executor.Publish(ctx, model.WorkflowExecuteMessage{WorkflowId: 100}, false)
+	executor.Publish(ctx, model.WorkflowExecuteMessage{WorkflowId: 100, WorkflowStatus: model.WorkflowInstanceStatusCompleted}, true)
+	executor.Close(100)
+	eventLog.finish(100)
+
+	received := receiveAll(t, sub)
+	if len(received) != 2 {
+		t.Fatalf("received %d events, want 2", len(received))
+	}
+	if received[0].EventId >= received[1].EventId {
+		t.Errorf("events out of order: %d, %d", received[0].EventId, received[1].EventId)
+	}
+	if received[1].WorkflowStatus != model.WorkflowInstanceStatusCompleted {
+		t.Errorf("last event status = %v, want completed", received[1].WorkflowStatus)
+	}
+}
+
+func TestEventBusDoesNotRedeliverLocalEvents(t *testing.T) {
+	eventLog := newMemoryEventLog()
+	b := newTestEventBus(t, eventLog, 1)
+	sub := b.Subscribe(100, 0)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		b.Publish(ctx, model.WorkflowExecuteMessage{WorkflowId: 100, WorkflowStatus: model.WorkflowInstanceStatusRunning}, true)
+	}
+	// 等待多个轮询周期后流程在其他服务实例上结束
+	time.Sleep(50 * time.Millisecond)
+	eventLog.finish(100)
+
+	received := receiveAll(t, sub)
+	if len(received) != 3 {
+		t.Errorf("received %d events, want 3", len(received))
+	}
+}
+
+func TestEventBusSkipsReplayedEvents(t *testing.T) {
+	eventLog := newMemoryEventLog()
+	executor := newTestEventBus(t, eventLog, 1)
+	ctx := context.Background()
+	executor.Publish(ctx, model.WorkflowExecuteMessage{WorkflowId: 100, WorkflowStatus: model.WorkflowInstanceStatusRunning}, true)
+	executor.Publish(ctx, model.WorkflowExecuteMessage{WorkflowId: 100, WorkflowStatus: model.WorkflowInstanceStatusRunning}, true)
+	replayed, _ := eventLog.ListWorkflowEvents(ctx, 100, 0)
+
+	subscriber := newTestEventBus(t, eventLog, 2)
+	sub := subscriber.Subscribe(100, 0)
+	subscriber.advance(sub, replayed[len(replayed)-1].Id)
+	executor.Publish(ctx, model.WorkflowExecuteMessage{WorkflowId: 100, WorkflowStatus: model.WorkflowInstanceStatusFailed}, true)
+	eventLog.finish(100)
+
+	received := receiveAll(t, sub)
+	if len(received) != 1 || received[0].WorkflowStatus != model.WorkflowInstanceStatusFailed {
+		t.Errorf("received %v, want only the event after the replay", received)
+	}
+}
@@ -0,0 +1,162 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/tmc/langchaingo/llms"
+	"log"
+	"sync"
+	"time"
+)
+
+// executionTrace 节点一次执行过程中的调试信息，通过context传递给节点执行函数，执行结束后写入节点执行记录
+type executionTrace struct {
+	mutex    sync.Mutex
+	prompt   string              // 渲染后的提示词或消息列表
+	response string              // 模型的原始响应
+	usages   []*model.TokenUsage // 模型调用的token用量
+	answered *model.TokenUsage   // 最终返回结果的模型，使用备用模型时与节点配置的主模型不同
+}
+
+type executionTraceKey struct{}
+
+func withExecutionTrace(ctx context.Context, trace *executionTrace) context.Context {
+	return context.WithValue(ctx, executionTraceKey{}, trace)
+}
+
+func executionTraceFrom(ctx context.Context) *executionTrace {
+	trace, _ := ctx.Value(executionTraceKey{}).(*executionTrace)
+	return trace
+}
+
+// recordPrompt 记录发送给模型的消息，图片内容不记录
+func recordPrompt(ctx context.Context, messages []llms.MessageContent) {
+	trace := executionTraceFrom(ctx)
+	if trace == nil {
+		return
+	}
+	type message struct {
+		Role    llms.ChatMessageType `json:"role"`
+		Content []string             `json:"content"`
+	}
+	records := make([]message, len(messages))
+	for i, msg := range messages {
+		records[i].Role = msg.Role
+		for _, part := range msg.Parts {
+			switch p := part.(type) {
+			case llms.TextContent:
+				records[i].Content = append(records[i].Content, p.Text)
+			case llms.ImageURLContent, llms.BinaryContent:
+				records[i].Content = append(records[i].Content, "[image]")
+			}
+		}
+	}
+	data, _ := json.Marshal(records)
+	trace.mutex.Lock()
+	trace.prompt = string(data)
+	trace.mutex.Unlock()
+}
+
+// recordResponse 记录模型的原始响应和token用量，费用按模型当前的价格计算
+func recordResponse(ctx context.Context, detail *model.ProviderModelDetail, response *llms.ContentResponse) {
+	trace := executionTraceFrom(ctx)
+	if trace == nil {
+		return
+	}
+	data, _ := json.Marshal(response)
+	usage := &model.TokenUsage{
+		ModelId:   detail.Id,
+		ModelName: detail.ModelName,
+		AddTime:   time.Now(),
+	}
+	for _, choice := range response.Choices {
+		// 不同供应商返回的用量字段名称不同
+		usage.PromptTokens += tokenCount(choice.GenerationInfo, "PromptTokens", "InputTokens")
+		usage.CompletionTokens += tokenCount(choice.GenerationInfo, "CompletionTokens", "OutputTokens")
+	}
+	usage.Cost = (float64(usage.PromptTokens)*detail.InputPrice + float64(usage.CompletionTokens)*detail.OutputPrice) / 1e6
+	trace.mutex.Lock()
+	trace.response = string(data)
+	trace.usages = append(trace.usages, usage)
+	trace.answered = usage
+	trace.mutex.Unlock()
+}
+
+func tokenCount(info map[string]any, keys ...string) int {
+	for _, key := range keys {
+		switch v := info[key].(type) {
+		case int:
+			return v
+		case int32:
+			return int(v)
+		case int64:
+			return int(v)
+		case float64:
+			return int(v)
+		}
+	}
+	return 0
+}
+
+// generateWithFallback 依次使用主模型和备用模型调用generate，模型不存在、调用失败或单个模型超时时尝试下一个模型，
+// 流程被取消或节点执行超时时不再尝试。所有模型都失败时返回最后一个错误。
+// 每个模型的输出通过stream推送，切换模型前推送重置事件，客户端丢弃失败模型已经输出的内容
+func (e *Engine) generateWithFallback(ctx context.Context, nodeInstance *model.NodeInstance, primary int64,
+	fallbacks model.ModelIds,
+	generate func(ctx context.Context, detail *model.ProviderModelDetail, stream *chunkStream) (string, error)) (string, error) {
+	modelIds := append([]int64{primary}, fallbacks...)
+	var lastErr error
+	for i, modelId := range modelIds {
+		if i > 0 {
+			log.Printf("model %d failed, fallback to model %d: %v", modelIds[i-1], modelId,
+				secretMaskerFrom(ctx).maskError(lastErr))
+		}
+		detail, err := e.modelRepo.GetProviderModelDetail(ctx, modelId)
+		if err != nil {
+			lastErr = err
+		} else if detail == nil {
+			lastErr = errors.New("无法找到节点需要的大模型")
+		} else {
+			stream := e.newChunkStream(ctx, nodeInstance)
+			attemptCtx, cancel := modelAttemptContext(ctx, len(modelIds)-i)
+			output, err := generate(attemptCtx, detail, stream)
+			cancel()
+			if err == nil {
+				stream.flush(ctx)
+				return output, nil
+			}
+			stream.reset(ctx)
+			lastErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", lastErr
+}
+
+// modelAttemptContext 节点设置了超时时，剩余时间平均分给还没有尝试的模型，单个模型超时后还有时间使用备用模型
+func modelAttemptContext(ctx context.Context, remainingModels int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || remainingModels <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remainingModels))
+}
+
+// generateContent 调用模型生成内容，记录提示词、模型的原始响应和token用量
+func generateContent(ctx context.Context, detail *model.ProviderModelDetail, llm llms.Model,
+	messages []llms.MessageContent, options ...llms.CallOption) (string, error) {
+	recordPrompt(ctx, messages)
+	response, err := llm.GenerateContent(ctx, messages, options...)
+	if err != nil {
+		return "", err
+	}
+	recordResponse(ctx, detail, response)
+	if len(response.Choices) == 0 {
+		return "", errors.New("模型没有返回内容")
+	}
+	return response.Choices[0].Content, nil
+}
@@ -0,0 +1,50 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestModelAttemptContext(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 9*time.Second)
+	defer cancel()
+	parentDeadline, _ := parent.Deadline()
+	tests := []struct {
+		name            string
+		ctx             context.Context
+		remainingModels int
+		wantDeadline    bool
+		wantTimeout     time.Duration
+	}{
+		{"no node timeout", context.Background(), 3, false, 0},
+		{"split between models", parent, 3, true, 3 * time.Second},
+		{"last model uses node deadline", parent, 1, true, 9 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := modelAttemptContext(tt.ctx, tt.remainingModels)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			if ok != tt.wantDeadline {
+				t.Fatalf("has deadline = %v, want %v", ok, tt.wantDeadline)
+			}
+			if !ok {
+				return
+			}
+			if deadline.After(parentDeadline) {
+				t.Errorf("attempt deadline %v after node deadline %v", deadline, parentDeadline)
+			}
+			if got := time.Until(deadline); got > tt.wantTimeout || got < tt.wantTimeout-time.Second {
+				t.Errorf("attempt timeout = %v, want about %v", got, tt.wantTimeout)
+			}
+		})
+	}
+	// 单个模型超时后节点上下文仍然有效，可以继续尝试备用模型
+	ctx, cancel := modelAttemptContext(parent, 1000)
+	defer cancel()
+	<-ctx.Done()
+	if parent.Err() != nil {
+		t.Errorf("node context expired with the first model attempt")
+	}
+}
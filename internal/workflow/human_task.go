@@ -0,0 +1,227 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/tmc/langchaingo/prompts"
+	"strconv"
+	"time"
+)
+
+// executeHumanTaskNode 创建人工任务，节点进入等待状态，任务处理完成后由CompleteHumanTask继续执行流程
+func (e *Engine) executeHumanTaskNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
+	nodeData *model.HumanTaskNodeData, inputMap map[string]any) {
+	description := nodeData.Description
+	if description != "" {
+		inputVariables := make([]string, 0, len(node.Data.Input))
+		for _, variable := range node.Data.Input {
+			inputVariables = append(inputVariables, variable.Name)
+		}
+		formatted, err := prompts.NewPromptTemplate(description, inputVariables).Format(inputMap)
+		if err != nil {
+			panic(err)
+		}
+		description = formatted
+	}
+	title := nodeData.Title
+	if title == "" {
+		title = node.Data.Name
+	}
+	// 表单默认值取同名的输入变量，方便处理人修改上游节点生成的内容
+	values := make(map[string]any)
+	for _, field := range nodeData.FormFields {
+		if value, ok := inputMap[field.Name]; ok {
+			values[field.Name] = value
+		}
+	}
+	fieldsData, _ := json.Marshal(nodeData.FormFields)
+	valuesData, _ := json.Marshal(values)
+	// 任务内容会保存并展示给处理人，引用的密钥需要脱敏
+	masker := secretMaskerFrom(ctx)
+	task := &model.HumanTask{
+		Id:             e.snowflake.Generate().Int64(),
+		WorkflowId:     nodeInstance.WorkflowId,
+		NodeInstanceId: nodeInstance.Id,
+		NodeId:         node.Id,
+		Title:          masker.mask(title),
+		Description:    masker.mask(description),
+		FormFields:     masker.mask(string(fieldsData)),
+		Values:         masker.mask(string(valuesData)),
+		Status:         model.HumanTaskStatusPending,
+		AddTime:        time.Now(),
+		CompleteTime:   time.Now(),
+	}
+	if err := e.instanceRepo.InsertHumanTask(ctx, task); err != nil {
+		panic(err)
+	}
+	nodeInstance.Status = model.NodeInstanceStatusWaiting
+}
+
+// CompleteHumanTask 处理人工任务，通过时执行approve分支，驳回时执行reject分支，表单值作为节点输出
+func (e *Engine) CompleteHumanTask(ctx context.Context, taskId int64, request *model.CompleteHumanTaskRequest,
+	userId int64) error {
+	task, err := e.instanceRepo.GetHumanTask(ctx, taskId)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		return errors.New("任务不存在")
+	}
+	if task.Status != model.HumanTaskStatusPending {
+		return errors.New("任务已处理")
+	}
+	var handle string
+	switch request.Action {
+	case model.HumanTaskActionApprove:
+		task.Status = model.HumanTaskStatusApproved
+		handle = model.HumanTaskHandleApprove
+	case model.HumanTaskActionReject:
+		task.Status = model.HumanTaskStatusRejected
+		handle = model.HumanTaskHandleReject
+	default:
+		return errors.New("无效的操作")
+	}
+	var fields []model.FormField
+	_ = json.Unmarshal([]byte(task.FormFields), &fields)
+	// 驳回时不要求填写必填字段
+	values, err := validateFormValues(fields, request.Values, request.Action == model.HumanTaskActionApprove)
+	if err != nil {
+		return err
+	}
+	valuesData, _ := json.Marshal(values)
+	task.Values = string(valuesData)
+	task.Comment = request.Comment
+	task.CompleteTime = time.Now()
+	task.CompleteUser = userId
+
+	output := make(map[string]any)
+	for name, value := range values {
+		output[name] = value
+	}
+	output["comment"] = request.Comment
+	output["successBranch"] = handle
+	outputData, _ := json.Marshal(output)
+	nodeInstance := &model.NodeInstance{
+		Id:           task.NodeInstanceId,
+		WorkflowId:   task.WorkflowId,
+		NodeId:       task.NodeId,
+		Status:       model.NodeInstanceStatusCompleted,
+		Output:       string(outputData),
+		CompleteTime: time.Now(),
+	}
+	err = e.tm.Tx(ctx, func(ctx context.Context) error {
+		ok, err := e.instanceRepo.FinishHumanTask(ctx, task)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("任务已处理")
+		}
+		ok, err = e.instanceRepo.FinishWaitingNodeInstance(ctx, nodeInstance)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("节点不在等待处理状态")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	e.sendNodeMessage(nodeInstance)
+	instanceCtx := e.instanceContext(task.WorkflowId)
+	plan, err := e.refreshPlan(instanceCtx, task.WorkflowId)
+	if err == nil {
+		if node := plan.Node(task.NodeId); node == nil {
+			err = fmt.Errorf("node not found: %s", task.NodeId)
+		} else {
+			err = e.stepWorkflow(instanceCtx, node, task.WorkflowId)
+		}
+	}
+	if err != nil {
+		e.UpdateWorkflowFailed(context.WithoutCancel(ctx), task.WorkflowId)
+		return err
+	}
+	return nil
+}
+
+// validateFormValues 按表单字段类型校验并转换提交的值，未定义的字段会被忽略
+func validateFormValues(fields []model.FormField, values map[string]any, checkRequired bool) (map[string]any, error) {
+	result := make(map[string]any)
+	for _, field := range fields {
+		value, ok := values[field.Name]
+		if !ok || value == nil || value == "" {
+			if field.Required && checkRequired {
+				return nil, fmt.Errorf("缺少必填字段: %s", field.Name)
+			}
+			continue
+		}
+		converted, err := convertFormValue(field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("字段%s格式错误: %w", field.Name, err)
+		}
+		result[field.Name] = converted
+	}
+	return result, nil
+}
+
+func convertFormValue(varType model.VariableType, value any) (any, error) {
+	switch varType {
+	case model.VariableTypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return nil, errors.New("需要字符串")
+	case model.VariableTypeNumber:
+		return convertNumber(value)
+	case model.VariableTypeStringArray:
+		array, ok := value.([]any)
+		if !ok {
+			return nil, errors.New("需要字符串数组")
+		}
+		result := make([]string, len(array))
+		for i, item := range array {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("需要字符串数组")
+			}
+			result[i] = s
+		}
+		return result, nil
+	case model.VariableTypeNumberArray:
+		array, ok := value.([]any)
+		if !ok {
+			return nil, errors.New("需要数值数组")
+		}
+		result := make([]float64, len(array))
+		for i, item := range array {
+			n, err := convertNumber(item)
+			if err != nil {
+				return nil, errors.New("需要数值数组")
+			}
+			result[i] = n
+		}
+		return result, nil
+	default:
+		return value, nil
+	}
+}
+
+func convertNumber(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, errors.New("需要数值")
+		}
+		return n, nil
+	default:
+		return 0, errors.New("需要数值")
+	}
+}
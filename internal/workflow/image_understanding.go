@@ -12,7 +12,6 @@ import (
 	"github.com/tmc/langchaingo/llms"
 	"strconv"
 	"strings"
-	"time"
 )
 
 func (e *Engine) executeImageUnderstandingNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
@@ -27,12 +26,16 @@ func (e *Engine) executeImageUnderstandingNode(ctx context.Context, node *model.
 		panic(errors.New("image参数错误"))
 	}
 
-	detail, _ := e.modelRepo.GetProviderModelDetail(ctx, nodeData.ModelId)
-	if detail == nil || detail.ModelType != model.ProviderModelTypeImageUnderstanding {
-		panic(errors.New("模型不存在"))
+	output, err := e.generateWithFallback(ctx, nodeInstance, nodeData.ModelId, nodeData.FallbackModelIds,
+		func(ctx context.Context, detail *model.ProviderModelDetail, stream *chunkStream) (string, error) {
+			if detail.ModelType != model.ProviderModelTypeImageUnderstanding {
+				return "", errors.New("模型不存在")
+			}
+			return e.doImageUnderstandingTask(ctx, fileId, nodeData.Prompt, nodeData.OutputFormat, detail, stream.write)
+		})
+	if err != nil {
+		panic(err)
 	}
-
-	output, err := e.doImageUnderstandingTask(ctx, fileId, nodeData.Prompt, nodeData.OutputFormat, detail)
 	if nodeData.OutputFormat == "JSON" {
 		output = strings.TrimPrefix(output, "```json")
 		output = strings.TrimSuffix(output, "```")
@@ -42,15 +45,10 @@ func (e *Engine) executeImageUnderstandingNode(ctx context.Context, node *model.
 	outputMap["text"] = output
 	outData, _ := json.Marshal(outputMap)
 	nodeInstance.Output = string(outData)
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	nodeInstance.CompleteTime = time.Now()
-	if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
-		panic(err)
-	}
 }
 
 func (e *Engine) doImageUnderstandingTask(ctx context.Context, fileId int64, prompt string, outputFormat string,
-	detail *model.ProviderModelDetail) (string, error) {
+	detail *model.ProviderModelDetail, streamingFunc func(ctx context.Context, chunk []byte) error) (string, error) {
 	file, err := e.fileRepo.Get(ctx, fileId)
 	if err != nil {
 		return "", err
@@ -77,10 +75,9 @@ func (e *Engine) doImageUnderstandingTask(ctx context.Context, fileId int64, pro
 			llms.ImageURLContent{URL: imageURL},
 		}},
 	}
-	content, err := api.GenerateContent(ctx, messages, llms.WithTemperature(0.2))
-	if err != nil {
-		return "", err
+	options := []llms.CallOption{llms.WithTemperature(0.2)}
+	if streamingFunc != nil {
+		options = append(options, llms.WithStreamingFunc(streamingFunc))
 	}
-	choice := content.Choices[0]
-	return choice.Content, nil
+	return generateContent(ctx, detail, api, messages, options...)
 }
@@ -0,0 +1,258 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"sync"
+	"time"
+)
+
+// iterationScope 迭代子流程中节点的执行范围，通过context传递给变量查找
+type iterationScope struct {
+	nodeId   string         // 迭代节点id
+	parentId int64          // 迭代节点实例id
+	index    int            // 迭代序号
+	item     any            // 当前迭代的数组元素
+	plan     *ExecutionPlan // 子流程执行计划
+}
+
+type iterationScopeKey struct{}
+
+func withIterationScope(ctx context.Context, scope *iterationScope) context.Context {
+	return context.WithValue(ctx, iterationScopeKey{}, scope)
+}
+
+func iterationScopeFrom(ctx context.Context) *iterationScope {
+	scope, _ := ctx.Value(iterationScopeKey{}).(*iterationScope)
+	return scope
+}
+
+// executeIterationNode 对输入数组的每个元素执行一次子流程，子流程结束节点的输出按元素顺序收集到results
+func (e *Engine) executeIterationNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
+	nodeData *model.IterationNodeData, inputMap map[string]any) {
+	items, ok := inputMap["items"].([]any)
+	if !ok {
+		panic(errors.New("迭代节点的items必须是数组"))
+	}
+	if iterationScopeFrom(ctx) != nil {
+		panic(errors.New("迭代节点不能嵌套"))
+	}
+	subGraph := nodeData.SubGraph
+	if subGraph == nil {
+		panic(errors.New("迭代节点缺少子流程"))
+	}
+	order, err := topologicalOrder(subGraph)
+	if err != nil {
+		panic(err)
+	}
+	for _, n := range order {
+		switch n.Type {
+		case model.NodeTypeStart, model.NodeTypeIteration, model.NodeTypeHumanTask, model.NodeTypeSubWorkflow:
+			panic(fmt.Errorf("迭代子流程不支持节点类型: %s", n.Type))
+		}
+	}
+	plan := CompilePlan(subGraph)
+
+	// 重新执行时保留已完成的子节点，其余子节点重新执行
+	if err := e.instanceRepo.DeleteUnfinishedChildNodeInstances(ctx, nodeInstance.Id); err != nil {
+		panic(err)
+	}
+	children, err := e.instanceRepo.ListChildNodeInstances(ctx, nodeInstance.Id)
+	if err != nil {
+		panic(err)
+	}
+	completed := make(map[int]map[string]*model.NodeInstance)
+	for _, child := range children {
+		if completed[child.Iteration] == nil {
+			completed[child.Iteration] = make(map[string]*model.NodeInstance)
+		}
+		completed[child.Iteration][child.NodeId] = child
+	}
+
+	parallelism := nodeData.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	stopOnError := nodeData.ErrorMode != model.IterationErrorModeSkip && nodeData.ErrorMode != model.IterationErrorModeCollect
+	iterCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// 第一个失败的迭代取消其余迭代，被取消的迭代的错误不作为节点的错误
+	var stopErr error
+	stopOnce := sync.Once{}
+	results := make([]any, len(items))
+	iterErrors := make([]error, len(items))
+	sem := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
+	for i, item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-iterCtx.Done():
+		}
+		if iterCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(index int, item any) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			scope := &iterationScope{nodeId: node.Id, parentId: nodeInstance.Id, index: index, item: item, plan: plan}
+			result, err := e.runIteration(withIterationScope(iterCtx, scope), order, plan, nodeInstance,
+				completed[index], nodeData.OutputVariable)
+			results[index], iterErrors[index] = result, err
+			if err != nil && stopOnError {
+				stopOnce.Do(func() {
+					stopErr = fmt.Errorf("第%d次迭代失败: %w", index, err)
+					cancel()
+				})
+			}
+		}(i, item)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		panic(ctx.Err())
+	}
+	if stopErr != nil {
+		panic(stopErr)
+	}
+
+	output := map[string]any{}
+	var collected []any
+	errorMessages := make([]string, 0)
+	for i, err := range iterErrors {
+		if err == nil {
+			collected = append(collected, results[i])
+			continue
+		}
+		if nodeData.ErrorMode == model.IterationErrorModeCollect {
+			collected = append(collected, nil)
+			errorMessages = append(errorMessages, fmt.Sprintf("%d: %s", i, err.Error()))
+		}
+	}
+	if collected == nil {
+		collected = make([]any, 0)
+	}
+	output["results"] = collected
+	output["errors"] = errorMessages
+	outputData, _ := json.Marshal(output)
+	nodeInstance.Output = string(outputData)
+}
+
+// runIteration 按拓扑顺序执行一次迭代的子流程，返回子流程结束节点的输出
+func (e *Engine) runIteration(ctx context.Context, order []*model.Node, plan *ExecutionPlan,
+	parent *model.NodeInstance, completed map[string]*model.NodeInstance, outputVariable string) (any, error) {
+	scope := iterationScopeFrom(ctx)
+	dbCtx := context.WithoutCancel(ctx)
+	instances := make(map[string]*model.NodeInstance)
+	var result any
+	for _, node := range order {
+		nodeInstance, ok := completed[node.Id]
+		if !ok {
+			// 与外层流程相同的汇聚规则：没有入边或至少一条入边被执行时才执行节点
+			incoming := plan.IncomingEdges(node.Id)
+			runnable := len(incoming) == 0
+			for _, edge := range incoming {
+				source, ok := instances[edge.Source]
+				if ok && source.Status == model.NodeInstanceStatusCompleted && isEdgeTaken(edge, source) {
+					runnable = true
+				}
+			}
+			nodeInstance = &model.NodeInstance{
+				Id:           e.snowflake.Generate().Int64(),
+				WorkflowId:   parent.WorkflowId,
+				NodeId:       node.Id,
+				Type:         node.Type,
+				Status:       model.NodeInstanceStatusRunning,
+				Output:       "{}",
+				AddTime:      time.Now(),
+				CompleteTime: time.Now(),
+				Executor:     parent.Executor,
+				ParentId:     parent.Id,
+				Iteration:    scope.index,
+			}
+			if !runnable {
+				nodeInstance.Status = model.NodeInstanceStatusUnreached
+			}
+			if err := e.instanceRepo.InsertNodeInstance(ctx, nodeInstance); err != nil {
+				return nil, err
+			}
+			if runnable {
+				err := e.runNode(ctx, node, nodeInstance)
+				nodeInstance.CompleteTime = time.Now()
+				if err != nil {
+					nodeInstance.Output = "{}"
+					nodeInstance.Error = err.Error()
+					nodeInstance.Status = model.NodeInstanceStatusFailed
+					if ctx.Err() != nil {
+						nodeInstance.Status = model.NodeInstanceStatusCancelled
+					}
+				} else {
+					nodeInstance.Status = model.NodeInstanceStatusCompleted
+				}
+				ok, updateErr := e.instanceRepo.FinishRunningNodeInstance(dbCtx, nodeInstance)
+				if updateErr != nil {
+					return nil, updateErr
+				}
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, errors.New("流程已取消")
+				}
+			}
+		}
+		instances[node.Id] = nodeInstance
+		if node.Type == model.NodeTypeEnd && nodeInstance.Status == model.NodeInstanceStatusCompleted {
+			var output map[string]any
+			_ = json.Unmarshal([]byte(nodeInstance.Output), &output)
+			if outputVariable == "" {
+				result = output
+			} else {
+				result = output[outputVariable]
+			}
+		}
+	}
+	return result, nil
+}
+
+// topologicalOrder 按拓扑顺序排列子流程节点，子流程存在环时返回错误
+func topologicalOrder(definition *model.WorkflowDefinition) ([]*model.Node, error) {
+	inDegree := make(map[string]int)
+	for _, node := range definition.Nodes {
+		inDegree[node.Id] = 0
+	}
+	for _, edge := range definition.Edges {
+		inDegree[edge.Target]++
+	}
+	var queue, order []*model.Node
+	for _, node := range definition.Nodes {
+		if inDegree[node.Id] == 0 {
+			queue = append(queue, node)
+		}
+	}
+	nodes := NodeSliceToMap(definition.Nodes)
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		order = append(order, node)
+		for _, edge := range definition.Edges {
+			if edge.Source != node.Id {
+				continue
+			}
+			inDegree[edge.Target]--
+			if inDegree[edge.Target] == 0 {
+				if target, ok := nodes[edge.Target]; ok {
+					queue = append(queue, target)
+				}
+			}
+		}
+	}
+	if len(order) != len(definition.Nodes) {
+		return nil, errors.New("子流程中存在环")
+	}
+	return order, nil
+}
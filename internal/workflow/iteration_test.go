@@ -0,0 +1,52 @@
+package workflow
+
+import (
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"slices"
+	"testing"
+)
+
+func TestTopologicalOrder(t *testing.T) {
+	nodes := func(ids ...string) []*model.Node {
+		result := make([]*model.Node, len(ids))
+		for i, id := range ids {
+			result[i] = &model.Node{Id: id}
+		}
+		return result
+	}
+	edge := func(source, target string) *model.Edge {
+		return &model.Edge{Source: source, Target: target}
+	}
+	tests := []struct {
+		name    string
+		def     *model.WorkflowDefinition
+		want    []string
+		wantErr bool
+	}{
+		{"single node", &model.WorkflowDefinition{Nodes: nodes("a")}, []string{"a"}, false},
+		{"chain declared in reverse", &model.WorkflowDefinition{Nodes: nodes("c", "b", "a"),
+			Edges: []*model.Edge{edge("a", "b"), edge("b", "c")}}, []string{"a", "b", "c"}, false},
+		{"diamond", &model.WorkflowDefinition{Nodes: nodes("d", "c", "b", "a"),
+			Edges: []*model.Edge{edge("a", "b"), edge("a", "c"), edge("b", "d"), edge("c", "d")}},
+			[]string{"a", "b", "c", "d"}, false},
+		{"parallel edges", &model.WorkflowDefinition{Nodes: nodes("a", "b"),
+			Edges: []*model.Edge{edge("a", "b"), edge("a", "b")}}, []string{"a", "b"}, false},
+		{"cycle", &model.WorkflowDefinition{Nodes: nodes("a", "b", "c"),
+			Edges: []*model.Edge{edge("a", "b"), edge("b", "c"), edge("c", "b")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := topologicalOrder(tt.def)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("topologicalOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := make([]string, len(order))
+			for i, node := range order {
+				got[i] = node.Id
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("topologicalOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package workflow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathToken JSONPath中的一段路径，wildcard表示取对象或数组的全部元素
+type jsonPathToken struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// EvalJSONPath 在json解析后的数据中按JSONPath取值，支持$、.key、['key']、[index]、[*]和.*，
+// 路径中包含通配符时返回所有匹配值组成的数组。路径不存在时ok为false
+func EvalJSONPath(data any, path string) (value any, ok bool, err error) {
+	tokens, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	current := []any{data}
+	multiple := false
+	for _, token := range tokens {
+		var next []any
+		for _, item := range current {
+			switch v := item.(type) {
+			case map[string]any:
+				if token.wildcard {
+					for _, child := range v {
+						next = append(next, child)
+					}
+				} else if child, exists := v[token.key]; exists && !token.isIndex {
+					next = append(next, child)
+				}
+			case []any:
+				if token.wildcard {
+					next = append(next, v...)
+				} else if token.isIndex {
+					index := token.index
+					if index < 0 {
+						index += len(v)
+					}
+					if index >= 0 && index < len(v) {
+						next = append(next, v[index])
+					}
+				}
+			}
+		}
+		multiple = multiple || token.wildcard
+		current = next
+	}
+	if multiple {
+		if current == nil {
+			current = make([]any, 0)
+		}
+		return current, true, nil
+	}
+	if len(current) == 0 {
+		return nil, false, nil
+	}
+	return current[0], true, nil
+}
+
+func parseJSONPath(path string) ([]jsonPathToken, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, fmt.Errorf("JSONPath不能为空")
+	}
+	// 省略$时从根对象开始
+	if !strings.HasPrefix(path, "$") {
+		path = "$." + path
+	}
+	var tokens []jsonPathToken
+	i := 1
+	for i < len(path) {
+		switch path[i] {
+		case '.':
+			i++
+			start := i
+			for i < len(path) && path[i] != '.' && path[i] != '[' {
+				i++
+			}
+			key := path[start:i]
+			if key == "" {
+				return nil, fmt.Errorf("无效的JSONPath: %s", path)
+			}
+			tokens = append(tokens, jsonPathToken{key: key, wildcard: key == "*"})
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("无效的JSONPath: %s", path)
+			}
+			content := strings.TrimSpace(path[i+1 : i+end])
+			i += end + 1
+			switch {
+			case content == "*":
+				tokens = append(tokens, jsonPathToken{wildcard: true})
+			case len(content) >= 2 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0]:
+				tokens = append(tokens, jsonPathToken{key: content[1 : len(content)-1]})
+			default:
+				index, err := strconv.Atoi(content)
+				if err != nil {
+					return nil, fmt.Errorf("无效的JSONPath下标: %s", content)
+				}
+				tokens = append(tokens, jsonPathToken{index: index, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("无效的JSONPath: %s", path)
+		}
+	}
+	return tokens, nil
+}
@@ -6,10 +6,9 @@ import (
 	"errors"
 	"github.com/StellrisJAY/workflow-ai/internal/ai"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
-	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/prompts"
 	"strings"
-	"time"
 )
 
 func (e *Engine) executeKeywordExtractionNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
@@ -19,26 +18,25 @@ func (e *Engine) executeKeywordExtractionNode(ctx context.Context, node *model.N
 		panic(errors.New("缺少question参数"))
 	}
 	question := q.(string)
-	detail, err := e.modelRepo.GetProviderModelDetail(ctx, nodeData.ModelId)
-	if err != nil {
-		panic(err)
-	}
-	modelAPI, err := ai.MakeModelInterface(detail, "JSON")
+	prompt, err := prompts.NewPromptTemplate(model.KeywordExtractionPrompt, []string{"question"}).Format(map[string]any{
+		"question": question,
+	})
 	if err != nil {
 		panic(err)
 	}
-	prompt := prompts.NewPromptTemplate(model.KeywordExtractionPrompt, []string{"question"})
-
-	chain := chains.NewLLMChain(modelAPI, prompt)
-	response, err := chain.Call(context.TODO(), map[string]any{
-		"question": question,
-	},
-		chains.WithTemperature(0.2))
+	output, err := e.generateWithFallback(ctx, nodeInstance, nodeData.ModelId, nodeData.FallbackModelIds,
+		func(ctx context.Context, detail *model.ProviderModelDetail, _ *chunkStream) (string, error) {
+			modelAPI, err := ai.MakeModelInterface(detail, "JSON")
+			if err != nil {
+				return "", err
+			}
+			return generateContent(ctx, detail, modelAPI, []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)},
+				llms.WithTemperature(0.2))
+		})
 	if err != nil {
 		panic(err)
 	}
 	// 将大模型输出结果写入节点实例，修改节点实例为完成状态
-	output := response[chain.OutputKey].(string)
 	output = strings.TrimPrefix(output, "```json")
 	output = strings.TrimSuffix(output, "```")
 	output = strings.TrimSpace(output)
@@ -51,9 +49,4 @@ func (e *Engine) executeKeywordExtractionNode(ctx context.Context, node *model.N
 	result["total"] = len(keywords)
 	data, _ := json.Marshal(result)
 	nodeInstance.Output = string(data)
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	nodeInstance.CompleteTime = time.Now()
-	if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
-		panic(err)
-	}
 }
@@ -1,25 +1,25 @@
 package workflow
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"path"
 	"strconv"
 	"time"
 )
 
 func (e *Engine) executeKnowledgeRetrieveNode(ctx context.Context, node *model.Node,
-	nodeData *model.RetrieveKnowledgeBaseNodeData, nodeInstance *model.NodeInstance) {
-	inputMap, err := e.LookupInputVariables(ctx, node.Data.Input, nodeInstance.WorkflowId)
-	if err != nil {
-		panic(err)
-	}
+	nodeData *model.RetrieveKnowledgeBaseNodeData, nodeInstance *model.NodeInstance, inputMap map[string]any) {
 	query, ok := inputMap["query"]
 	if !ok {
 		panic(errors.New("missing query variable"))
 	}
 	var result []*model.KbSearchReturnDocument
+	var err error
 	switch nodeData.SearchType {
 	case model.KbSearchTypeSimilarity:
 		result, err = e.rag.SimilaritySearch(ctx, nodeData.KbId, query.(string), nodeData.SimilarityThreshold, nodeData.Count)
@@ -45,9 +45,125 @@ func (e *Engine) executeKnowledgeRetrieveNode(ctx context.Context, node *model.N
 	output["documents"] = documents
 	data, _ := json.Marshal(output)
 	nodeInstance.Output = string(data)
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	nodeInstance.CompleteTime = time.Now()
-	if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
+}
+
+// executeKnowledgeWriteNode 将文本或文本文件写入知识库，与上传的文件使用相同的拆分和嵌入流程
+func (e *Engine) executeKnowledgeWriteNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
+	nodeData *model.KnowledgeBaseWriteNodeData, inputMap map[string]any) {
+	content, ok := inputMap["content"].(string)
+	if !ok || content == "" {
+		panic(errors.New("缺少content参数"))
+	}
+	kb, err := e.kbRepo.Detail(ctx, nodeData.KbId)
+	if err != nil {
 		panic(err)
 	}
+	if kb == nil {
+		panic(errors.New("知识库不存在"))
+	}
+	// content为文本文件类型时是文件id，否则是文本内容
+	var fileName string
+	var data []byte
+	if isTextFileInput(node, "content") {
+		fileId, err := strconv.ParseInt(content, 10, 64)
+		if err != nil {
+			panic(errors.New("content参数错误"))
+		}
+		file, err := e.fileRepo.Get(ctx, fileId)
+		if err != nil {
+			panic(err)
+		}
+		data, err = e.fileStore.Download(ctx, file.Url)
+		if err != nil {
+			panic(err)
+		}
+		fileName = file.Name
+	} else {
+		data = []byte(content)
+		fileName = interpolateVariables(nodeData.FileName, inputMap)
+		if fileName == "" {
+			fileName = fmt.Sprintf("%s-%d", node.Data.Name, time.Now().Unix())
+		}
+		if path.Ext(fileName) == "" {
+			fileName += ".txt"
+		}
+	}
+
+	metadata := map[string]any{
+		"workflowId": strconv.FormatInt(nodeInstance.WorkflowId, 10),
+		"nodeId":     node.Id,
+	}
+	for _, item := range nodeData.Metadata {
+		if item.Key != "" {
+			metadata[item.Key] = interpolateVariables(item.Value, inputMap)
+		}
+	}
+	metadataData, _ := json.Marshal(metadata)
+	separators := nodeData.Separators
+	if len(separators) == 0 {
+		separators = []string{"\n\n"}
+	}
+	separatorsData, _ := json.Marshal(separators)
+	chunkSize := nodeData.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = model.DefaultChunkSize
+	}
+	chunkOverlap := nodeData.ChunkOverlap
+	if chunkOverlap < 0 || chunkOverlap >= chunkSize {
+		chunkOverlap = model.DefaultChunkOverlap
+	}
+	fileId := e.snowflake.Generate().Int64()
+	kbFile := &model.KnowledgeBaseFile{
+		Id:           fileId,
+		Name:         fileName,
+		KbId:         kb.Id,
+		AddTime:      time.Now(),
+		AddUser:      1,
+		Length:       int64(len(data)),
+		Url:          fmt.Sprintf("%d/%d/%s", kb.Id, fileId, fileName),
+		Status:       model.KbFileUploaded,
+		Metadata:     string(metadataData),
+		Separators:   string(separatorsData),
+		ChunkSize:    chunkSize,
+		ChunkOverlap: chunkOverlap,
+	}
+	task := &model.KbFileProcessTask{
+		Id:           e.snowflake.Generate().Int64(),
+		KbId:         kb.Id,
+		FileId:       fileId,
+		Status:       model.KbFileProcessStatusQueued,
+		AddTime:      time.Now(),
+		CompleteTime: time.Now(),
+	}
+	err = e.tm.Tx(ctx, func(ctx context.Context) error {
+		if err := e.kbRepo.InsertFile(ctx, kbFile); err != nil {
+			return err
+		}
+		if err := e.kbRepo.InsertFileProcessTask(ctx, task); err != nil {
+			return err
+		}
+		return e.fileStore.Upload(ctx, kbFile.Url, bytes.NewReader(data))
+	})
+	if err != nil {
+		panic(err)
+	}
+	chunks, err := e.rag.ProcessTask(ctx, task.Id)
+	if err != nil {
+		panic(err)
+	}
+	output := map[string]any{
+		"fileId": strconv.FormatInt(fileId, 10),
+		"chunks": chunks,
+	}
+	outputData, _ := json.Marshal(output)
+	nodeInstance.Output = string(outputData)
+}
+
+func isTextFileInput(node *model.Node, name string) bool {
+	for _, input := range node.Data.Input {
+		if input.Name == name {
+			return input.Type == model.VariableTypeTextFile
+		}
+	}
+	return false
 }
@@ -4,44 +4,43 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/StellrisJAY/workflow-ai/internal/ai"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
-	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/prompts"
 	"log"
 	"strings"
-	"time"
 )
 
 func (e *Engine) executeLLMNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
 	llmNodeData *model.LLMNodeData, inputMap map[string]any) {
-	detail, _ := e.modelRepo.GetProviderModelDetail(ctx, llmNodeData.ModelId)
-	if detail == nil {
-		panic(errors.New("无法找到节点需要的大模型"))
-	}
-	// 创建大模型接口
-	llm, err := ai.MakeModelInterface(detail, llmNodeData.OutputFormat)
-	if err != nil {
-		log.Println("create llm error:", err)
-		panic(errors.New("创建大模型失败"))
-	}
 	// 创建提示词模板
 	inputVariables := make([]string, 0, len(node.Data.Input))
 	for _, variable := range node.Data.Input {
 		inputVariables = append(inputVariables, variable.Name)
 	}
-	prompt := prompts.NewPromptTemplate(llmNodeData.Prompt, inputVariables)
-	// 创建langchain，调用大模型API
-	chain := chains.NewLLMChain(llm, prompt)
-	response, err := chain.Call(context.TODO(), inputMap,
-		chains.WithTemperature(llmNodeData.Temperature),
-		chains.WithTopP(llmNodeData.TopP))
+	prompt, err := prompts.NewPromptTemplate(llmNodeData.Prompt, inputVariables).Format(inputMap)
+	if err != nil {
+		panic(err)
+	}
+	// 调用大模型API，主模型失败时使用备用模型
+	output, err := e.generateWithFallback(ctx, nodeInstance, llmNodeData.ModelId, llmNodeData.FallbackModelIds,
+		func(ctx context.Context, detail *model.ProviderModelDetail, stream *chunkStream) (string, error) {
+			// 创建大模型接口
+			llm, err := ai.MakeModelInterface(detail, llmNodeData.OutputFormat)
+			if err != nil {
+				log.Println("create llm error:", err)
+				return "", errors.New("创建大模型失败")
+			}
+			return generateContent(ctx, detail, llm, []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)},
+				llms.WithTemperature(llmNodeData.Temperature),
+				llms.WithTopP(llmNodeData.TopP),
+				llms.WithStreamingFunc(stream.write))
+		})
 	if err != nil {
 		panic(err)
 	}
 	// 将大模型输出结果写入节点实例，修改节点实例为完成状态
-	output := response[chain.OutputKey].(string)
 	// llm可能输出markdown格式，需要去除代码块前缀后缀
 	if llmNodeData.OutputFormat == "JSON" {
 		output = strings.TrimPrefix(output, "```json")
@@ -52,14 +51,10 @@ func (e *Engine) executeLLMNode(ctx context.Context, node *model.Node, nodeInsta
 	outputMap["text"] = output
 	outData, _ := json.Marshal(outputMap)
 	nodeInstance.Output = string(outData)
-	nodeInstance.CompleteTime = time.Now()
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
-		panic(fmt.Errorf("llm output format error: %v", err))
-	}
 }
 
-func executeLLMTask(detail *model.ProviderModelDetail, promptTemplate string, outputFormat string, inputMap map[string]any) (string, error) {
+func executeLLMTask(ctx context.Context, detail *model.ProviderModelDetail, promptTemplate string, outputFormat string,
+	inputMap map[string]any, streamingFunc func(ctx context.Context, chunk []byte) error) (string, error) {
 	modelAPI, err := ai.MakeModelInterface(detail, outputFormat)
 	if err != nil {
 		return "", err
@@ -68,15 +63,19 @@ func executeLLMTask(detail *model.ProviderModelDetail, promptTemplate string, ou
 	for k := range inputMap {
 		inputVariables = append(inputVariables, k)
 	}
-	prompt := prompts.NewPromptTemplate(promptTemplate, inputVariables)
-
-	chain := chains.NewLLMChain(modelAPI, prompt)
-	response, err := chain.Call(context.TODO(), inputMap,
-		chains.WithTemperature(0.2))
+	prompt, err := prompts.NewPromptTemplate(promptTemplate, inputVariables).Format(inputMap)
+	if err != nil {
+		return "", err
+	}
+	options := []llms.CallOption{llms.WithTemperature(0.2)}
+	if streamingFunc != nil {
+		options = append(options, llms.WithStreamingFunc(streamingFunc))
+	}
+	output, err := generateContent(ctx, detail, modelAPI, []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)},
+		options...)
 	if err != nil {
 		return "", err
 	}
-	output := response[chain.OutputKey].(string)
 	if outputFormat == "JSON" {
 		output = strings.TrimPrefix(output, "```json")
 		output = strings.TrimSuffix(output, "```")
@@ -84,3 +83,75 @@ func executeLLMTask(detail *model.ProviderModelDetail, promptTemplate string, ou
 	}
 	return output, nil
 }
+
+// chunkStream 大模型流式输出，每个输出片段作为事件推送给订阅流程的客户端。
+// 片段中的密钥会被脱敏，末尾可能是密钥开头的内容暂不推送，等待下一个片段或flush
+type chunkStream struct {
+	e            *Engine
+	nodeInstance *model.NodeInstance
+	masker       *secretMasker
+	pending      string
+	published    bool // 是否已经推送过片段
+}
+
+func (e *Engine) newChunkStream(ctx context.Context, nodeInstance *model.NodeInstance) *chunkStream {
+	return &chunkStream{e: e, nodeInstance: nodeInstance, masker: secretMaskerFrom(ctx)}
+}
+
+// write 作为大模型的流式输出回调
+func (s *chunkStream) write(ctx context.Context, chunk []byte) error {
+	if len(chunk) == 0 {
+		return nil
+	}
+	s.publish(ctx, s.next(string(chunk)))
+	return nil
+}
+
+// next 脱敏后返回可以推送的内容
+func (s *chunkStream) next(chunk string) string {
+	text := s.masker.mask(s.pending + chunk)
+	hold := s.masker.partialSuffixLen(text)
+	s.pending = text[len(text)-hold:]
+	return text[:len(text)-hold]
+}
+
+// flush 模型输出结束后推送剩余的内容
+func (s *chunkStream) flush(ctx context.Context) {
+	content := s.pending
+	s.pending = ""
+	s.publish(ctx, content)
+}
+
+// reset 模型调用失败时丢弃未推送的内容，已经推送过片段时通知客户端丢弃该节点的流式输出
+func (s *chunkStream) reset(ctx context.Context) {
+	s.pending = ""
+	if !s.published {
+		return
+	}
+	s.published = false
+	msg := s.message()
+	msg.StreamReset = true
+	s.e.events.Publish(ctx, msg, false)
+}
+
+func (s *chunkStream) publish(ctx context.Context, content string) {
+	if content == "" {
+		return
+	}
+	s.published = true
+	msg := s.message()
+	msg.StreamChatContent = content
+	// 流式输出的片段只推送给当前的订阅者，不记录事件日志
+	s.e.events.Publish(ctx, msg, false)
+}
+
+func (s *chunkStream) message() model.WorkflowExecuteMessage {
+	return model.WorkflowExecuteMessage{
+		WorkflowId:         s.nodeInstance.WorkflowId,
+		NodeId:             s.nodeInstance.NodeId,
+		NodeStatus:         model.NodeInstanceStatusRunning,
+		NodeStatusName:     model.NodeInstanceStatusRunning.String(),
+		WorkflowStatus:     model.WorkflowInstanceStatusRunning,
+		WorkflowStatusName: model.WorkflowInstanceStatusRunning.String(),
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
 	"strconv"
-	"time"
 )
 
 func (e *Engine) executeOCRNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
@@ -26,16 +25,11 @@ func (e *Engine) executeOCRNode(ctx context.Context, node *model.Node, nodeInsta
 		panic(errors.New("模型不存在"))
 	}
 
-	output, err := e.doImageUnderstandingTask(ctx, fileId, model.OCRPrompt, "TEXT", detail)
+	output, err := e.doImageUnderstandingTask(ctx, fileId, model.OCRPrompt, "TEXT", detail, nil)
 	out := map[string]string{
 		"text": output,
 	}
 	data, _ := json.Marshal(out)
 	output = string(data)
 	nodeInstance.Output = output
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	nodeInstance.CompleteTime = time.Now()
-	if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
-		panic(err)
-	}
 }
@@ -0,0 +1,99 @@
+package workflow
+
+import (
+	"context"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+)
+
+// ExecutionPlan 流程定义编译后的执行计划，包含节点索引和邻接表。
+// 流程实例运行期间缓存在引擎中，避免每执行一个节点都重新读取和解析流程定义
+type ExecutionPlan struct {
+	Definition *model.WorkflowDefinition
+	nodes      map[string]*model.Node
+	outgoing   map[string][]*model.Edge // 节点id -> 出边
+	incoming   map[string][]*model.Edge // 节点id -> 入边
+	endNodes   []string
+	revision   int // 编译时流程实例定义的版本
+}
+
+func CompilePlan(definition *model.WorkflowDefinition) *ExecutionPlan {
+	plan := &ExecutionPlan{
+		Definition: definition,
+		nodes:      NodeSliceToMap(definition.Nodes),
+		outgoing:   make(map[string][]*model.Edge),
+		incoming:   make(map[string][]*model.Edge),
+	}
+	for _, edge := range definition.Edges {
+		plan.outgoing[edge.Source] = append(plan.outgoing[edge.Source], edge)
+		plan.incoming[edge.Target] = append(plan.incoming[edge.Target], edge)
+	}
+	for _, node := range definition.Nodes {
+		if node.Type == model.NodeTypeEnd {
+			plan.endNodes = append(plan.endNodes, node.Id)
+		}
+	}
+	return plan
+}
+
+func (p *ExecutionPlan) Node(id string) *model.Node {
+	return p.nodes[id]
+}
+
+// NextNodes 节点所有出边指向的节点，多条边指向同一个节点时只返回一次
+func (p *ExecutionPlan) NextNodes(id string) []*model.Node {
+	var nextNodes []*model.Node
+	visited := make(map[string]struct{})
+	for _, edge := range p.outgoing[id] {
+		if _, ok := visited[edge.Target]; ok {
+			continue
+		}
+		visited[edge.Target] = struct{}{}
+		if node, ok := p.nodes[edge.Target]; ok {
+			nextNodes = append(nextNodes, node)
+		}
+	}
+	return nextNodes
+}
+
+func (p *ExecutionPlan) IncomingEdges(id string) []*model.Edge {
+	return p.incoming[id]
+}
+
+func (p *ExecutionPlan) EndNodes() []string {
+	return p.endNodes
+}
+
+// getPlan 获取流程实例的执行计划，缓存不存在时从数据库读取流程定义并编译
+func (e *Engine) getPlan(ctx context.Context, workflowId int64) (*ExecutionPlan, error) {
+	if v, ok := e.plans.Load(workflowId); ok {
+		return v.(*ExecutionPlan), nil
+	}
+	revision, err := e.instanceRepo.GetWorkflowRevision(ctx, workflowId)
+	if err != nil {
+		return nil, err
+	}
+	definition, err := e.getWorkflowDefinition(ctx, workflowId)
+	if err != nil {
+		return nil, err
+	}
+	plan := CompilePlan(definition)
+	plan.revision = revision
+	e.plans.Store(workflowId, plan)
+	return plan, nil
+}
+
+// refreshPlan 领取节点、处理人工任务等从外部进入流程时获取执行计划，其他服务实例重试流程时可能修改了节点配置，
+// 缓存的版本与持久化的版本不同时重新编译。流程内部的调度和汇聚判断直接使用缓存
+func (e *Engine) refreshPlan(ctx context.Context, workflowId int64) (*ExecutionPlan, error) {
+	if v, ok := e.plans.Load(workflowId); ok {
+		revision, err := e.instanceRepo.GetWorkflowRevision(ctx, workflowId)
+		if err != nil {
+			return nil, err
+		}
+		if v.(*ExecutionPlan).revision == revision {
+			return v.(*ExecutionPlan), nil
+		}
+		e.plans.CompareAndDelete(workflowId, v)
+	}
+	return e.getPlan(ctx, workflowId)
+}
@@ -0,0 +1,258 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"io"
+	"log"
+	"math"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBackoff     = time.Second
+	defaultBackoffRate = 2.0
+	maxBackoff         = time.Minute
+)
+
+// 匹配大模型接口、搜索接口返回的HTTP状态码，如 "status code: 502"、"502 Bad Gateway"
+var statusCodePattern = regexp.MustCompile(`(?i)(?:status code:?\s*|^)(\d{3})\b`)
+
+// defaultRetryOn 未配置重试错误类型时，只重试临时性错误
+var defaultRetryOn = []model.RetryableError{
+	model.RetryableErrorTimeout,
+	model.RetryableErrorNetwork,
+	model.RetryableErrorRateLimit,
+	model.RetryableErrorServerError,
+}
+
+// runNode 替换节点中引用的环境变量和密钥后执行节点，节点输出和错误信息中的密钥值会被脱敏。
+// 节点最终失败时按照执行策略的errorMode处理，处理后返回nil表示流程继续执行
+func (e *Engine) runNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance) error {
+	templateId, err := e.instanceRepo.GetWorkflowTemplateId(ctx, nodeInstance.WorkflowId)
+	if err != nil {
+		return err
+	}
+	resolved, masker, err := e.resolveSecrets(ctx, node, templateId)
+	if err == nil {
+		err = e.runNodeWithPolicy(withSecretMasker(ctx, masker), resolved, nodeInstance, masker)
+		nodeInstance.Output = masker.mask(nodeInstance.Output)
+		err = masker.maskError(err)
+	}
+	// 流程被取消时不处理
+	if err != nil && ctx.Err() == nil {
+		return applyErrorMode(node, nodeInstance, err)
+	}
+	return err
+}
+
+// applyErrorMode 节点失败后按照errorMode设置节点输出，节点状态为完成，错误信息保留在节点实例中
+func applyErrorMode(node *model.Node, nodeInstance *model.NodeInstance, err error) error {
+	policy := node.Data.Policy
+	if policy == nil {
+		return err
+	}
+	var output map[string]any
+	switch policy.ErrorMode {
+	case model.ErrorModeDefault:
+		output = policy.DefaultOutput
+		if output == nil {
+			output = make(map[string]any)
+		}
+	case model.ErrorModeBranch:
+		// successBranch与条件节点相同，后续只执行error分支
+		output = map[string]any{
+			"successBranch":             model.NodeErrorHandle,
+			model.NodeErrorMessageField: err.Error(),
+			model.NodeErrorTypeField:    errorType(err),
+		}
+	default:
+		return err
+	}
+	log.Printf("node %s failed, continue with error mode %s: %v", node.Id, policy.ErrorMode, err)
+	data, _ := json.Marshal(output)
+	nodeInstance.Output = string(data)
+	nodeInstance.Error = err.Error()
+	return nil
+}
+
+// errorType 失败分支中的错误类型，与重试策略的错误类型相同，无法识别时为unknown
+func errorType(err error) string {
+	if t := classifyError(err); t != "" {
+		return string(t)
+	}
+	return "unknown"
+}
+
+// runNodeWithPolicy 按照节点的执行策略执行节点，失败时根据策略等待后重试，每次执行都会记录到节点执行记录表
+func (e *Engine) runNodeWithPolicy(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
+	masker *secretMasker) error {
+	policy := node.Data.Policy
+	if policy == nil {
+		policy = &model.ExecutionPolicy{}
+	}
+	inputMap, err := e.LookupInputVariables(ctx, node.Data.Input, nodeInstance.WorkflowId)
+	if err != nil {
+		return err
+	}
+	dbCtx := context.WithoutCancel(ctx)
+	inputs, _ := json.Marshal(inputMap)
+	// 重试流程实例时节点的执行次数继续累加
+	executed := nodeInstance.Attempts
+	for attempt := 1; ; attempt++ {
+		nodeInstance.Attempts = executed + attempt
+		execution := &model.NodeExecution{
+			Id:             e.snowflake.Generate().Int64(),
+			NodeInstanceId: nodeInstance.Id,
+			WorkflowId:     nodeInstance.WorkflowId,
+			NodeId:         node.Id,
+			Attempt:        nodeInstance.Attempts,
+			Inputs:         masker.mask(string(inputs)),
+			QueuedTime:     nodeInstance.AddTime,
+			StartTime:      time.Now(),
+		}
+		trace := &executionTrace{}
+		err := e.runNodeOnce(withExecutionTrace(ctx, trace), node, nodeInstance, inputMap, policy)
+		execution.FinishTime = time.Now()
+		execution.Prompt, execution.RawResponse = masker.mask(trace.prompt), masker.mask(trace.response)
+		if trace.answered != nil {
+			execution.ModelId, execution.ModelName = trace.answered.ModelId, trace.answered.ModelName
+		}
+		e.saveTokenUsages(dbCtx, nodeInstance, trace.usages)
+		if err != nil {
+			execution.Status = model.NodeInstanceStatusFailed
+			execution.Error = masker.mask(err.Error())
+		} else {
+			execution.Status = model.NodeInstanceStatusCompleted
+		}
+		if err := e.instanceRepo.InsertNodeExecution(dbCtx, execution); err != nil {
+			log.Println("insert node execution error:", err)
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || attempt > policy.MaxRetries || !isRetryable(policy, err) {
+			return err
+		}
+		log.Printf("node %s attempt %d failed, retrying: %v", node.Id, attempt, masker.maskError(err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoffDuration(policy, attempt)):
+		}
+	}
+}
+
+// saveTokenUsages 保存节点一次执行中调用模型的token用量，失败的执行已经消耗的用量也会记录
+func (e *Engine) saveTokenUsages(ctx context.Context, nodeInstance *model.NodeInstance, usages []*model.TokenUsage) {
+	if len(usages) == 0 {
+		return
+	}
+	for _, usage := range usages {
+		usage.Id = e.snowflake.Generate().Int64()
+		usage.WorkflowId = nodeInstance.WorkflowId
+		usage.NodeInstanceId = nodeInstance.Id
+		usage.NodeId = nodeInstance.NodeId
+	}
+	if err := e.instanceRepo.InsertTokenUsages(ctx, usages); err != nil {
+		log.Println("insert token usage error:", err)
+	}
+}
+
+// runNodeOnce 执行一次节点，超时时间由执行策略决定，节点执行中的panic转换为错误返回
+func (e *Engine) runNodeOnce(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
+	inputMap map[string]any, policy *model.ExecutionPolicy) (err error) {
+	attemptCtx := ctx
+	if policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, time.Duration(policy.Timeout)*time.Second)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if rErr, ok := r.(error); ok {
+				err = rErr
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+			// 节点自身的超时，不是流程被取消
+			if ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("节点执行超时(%ds): %w", policy.Timeout, context.DeadlineExceeded)
+			}
+		}
+	}()
+	e.dispatchNode(attemptCtx, node, nodeInstance, inputMap)
+	return nil
+}
+
+// classifyError 判断错误所属的可重试类型，无法识别时返回空字符串
+func classifyError(err error) model.RetryableError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return model.RetryableErrorTimeout
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return model.RetryableErrorNetwork
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return model.RetryableErrorTimeout
+		}
+		return model.RetryableErrorNetwork
+	}
+	msg := err.Error()
+	if match := statusCodePattern.FindStringSubmatch(msg); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		switch {
+		case code == 429:
+			return model.RetryableErrorRateLimit
+		case code >= 500 && code < 600:
+			return model.RetryableErrorServerError
+		}
+	}
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "rate limit"):
+		return model.RetryableErrorRateLimit
+	case strings.Contains(lower, "connection reset"), strings.Contains(lower, "connection refused"):
+		return model.RetryableErrorNetwork
+	}
+	return ""
+}
+
+func isRetryable(policy *model.ExecutionPolicy, err error) bool {
+	retryOn := policy.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = defaultRetryOn
+	}
+	if slices.Contains(retryOn, model.RetryableErrorAll) {
+		return true
+	}
+	errType := classifyError(err)
+	return errType != "" && slices.Contains(retryOn, errType)
+}
+
+// backoffDuration 计算第attempt次执行失败后的等待时间
+func backoffDuration(policy *model.ExecutionPolicy, attempt int) time.Duration {
+	backoff := defaultBackoff
+	if policy.Backoff > 0 {
+		backoff = time.Duration(policy.Backoff) * time.Millisecond
+	}
+	rate := policy.BackoffRate
+	if rate < 1 {
+		rate = defaultBackoffRate
+	}
+	d := time.Duration(float64(backoff) * math.Pow(rate, float64(attempt-1)))
+	if d > maxBackoff || d <= 0 {
+		return maxBackoff
+	}
+	return d
+}
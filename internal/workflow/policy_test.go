@@ -0,0 +1,98 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type timeoutNetError struct{ timeout bool }
+
+func (e timeoutNetError) Error() string   { return "net error" }
+func (e timeoutNetError) Timeout() bool   { return e.timeout }
+func (e timeoutNetError) Temporary() bool { return false }
+
+var _ net.Error = timeoutNetError{}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want model.RetryableError
+	}{
+		{"deadline", context.DeadlineExceeded, model.RetryableErrorTimeout},
+		{"wrapped deadline", fmt.Errorf("节点执行超时(5s): %w", context.DeadlineExceeded), model.RetryableErrorTimeout},
+		{"eof", io.EOF, model.RetryableErrorNetwork},
+		{"unexpected eof", fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), model.RetryableErrorNetwork},
+		{"net timeout", timeoutNetError{timeout: true}, model.RetryableErrorTimeout},
+		{"net error", timeoutNetError{}, model.RetryableErrorNetwork},
+		{"status 429", errors.New("API returned unexpected status code: 429"), model.RetryableErrorRateLimit},
+		{"status 502 prefix", errors.New("502 Bad Gateway"), model.RetryableErrorServerError},
+		{"status 503", errors.New("status code 503"), model.RetryableErrorServerError},
+		{"status 400", errors.New("status code: 400"), ""},
+		{"rate limit text", errors.New("Rate limit exceeded"), model.RetryableErrorRateLimit},
+		{"connection reset", errors.New("read tcp: connection reset by peer"), model.RetryableErrorNetwork},
+		{"connection refused", errors.New("dial tcp: connection refused"), model.RetryableErrorNetwork},
+		{"masked", (&secretMasker{values: []string{"token"}}).maskError(errors.New("status code: 500 token")), model.RetryableErrorServerError},
+		{"unknown", errors.New("缺少query参数"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name    string
+		retryOn []model.RetryableError
+		err     error
+		want    bool
+	}{
+		{"default timeout", nil, context.DeadlineExceeded, true},
+		{"default unknown", nil, errors.New("bad input"), false},
+		{"all", []model.RetryableError{model.RetryableErrorAll}, errors.New("bad input"), true},
+		{"not listed", []model.RetryableError{model.RetryableErrorTimeout}, errors.New("status code: 429"), false},
+		{"listed", []model.RetryableError{model.RetryableErrorRateLimit}, errors.New("status code: 429"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &model.ExecutionPolicy{RetryOn: tt.retryOn}
+			if got := isRetryable(policy, tt.err); got != tt.want {
+				t.Errorf("isRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  model.ExecutionPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"default first", model.ExecutionPolicy{}, 1, time.Second},
+		{"default third", model.ExecutionPolicy{}, 3, 4 * time.Second},
+		{"custom backoff", model.ExecutionPolicy{Backoff: 500, BackoffRate: 3}, 2, 1500 * time.Millisecond},
+		{"rate below one uses default", model.ExecutionPolicy{Backoff: 100, BackoffRate: 0.5}, 2, 200 * time.Millisecond},
+		{"rate one is constant", model.ExecutionPolicy{Backoff: 100, BackoffRate: 1}, 10, 100 * time.Millisecond},
+		{"capped", model.ExecutionPolicy{}, 10, maxBackoff},
+		{"overflow capped", model.ExecutionPolicy{Backoff: 1000, BackoffRate: 10}, 100, maxBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backoffDuration(&tt.policy, tt.attempt); got != tt.want {
+				t.Errorf("backoffDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
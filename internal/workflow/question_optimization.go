@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"github.com/StellrisJAY/workflow-ai/internal/model"
-	"time"
 )
 
 func (e *Engine) executeQuestionOptimizeNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
@@ -14,20 +13,14 @@ func (e *Engine) executeQuestionOptimizeNode(ctx context.Context, node *model.No
 		panic("缺少question参数")
 	}
 	question := q.(string)
-	detail, err := e.modelRepo.GetProviderModelDetail(ctx, nodeData.ModelId)
-	if err != nil {
-		panic(err)
-	}
-	output, err := executeLLMTask(detail, model.QuestionOptimizationPrompt, "TEXT", map[string]interface{}{
-		"question": question,
-	})
+	output, err := e.generateWithFallback(ctx, nodeInstance, nodeData.ModelId, nodeData.FallbackModelIds,
+		func(ctx context.Context, detail *model.ProviderModelDetail, stream *chunkStream) (string, error) {
+			return executeLLMTask(ctx, detail, model.QuestionOptimizationPrompt, "TEXT", map[string]interface{}{
+				"question": question,
+			}, stream.write)
+		})
 	if err != nil {
 		panic(err)
 	}
 	nodeInstance.Output = fmt.Sprintf("{\"result\": \"%s\"}", output)
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	nodeInstance.CompleteTime = time.Now()
-	if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
-		panic(err)
-	}
 }
@@ -0,0 +1,125 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"github.com/StellrisJAY/workflow-ai/internal/repo"
+	"log"
+	"time"
+)
+
+const (
+	cronPollInterval = 10 * time.Second
+	cronBatchSize    = 20
+)
+
+// CronRunner 定时触发器，轮询到达执行时间的定时触发并启动流程。
+// 多个服务实例共享同一个数据库时，每次触发只有领取成功的服务实例会启动流程
+type CronRunner struct {
+	engine       *Engine
+	scheduleRepo *repo.ScheduleRepo
+	cancel       context.CancelFunc
+}
+
+func NewCronRunner(engine *Engine, scheduleRepo *repo.ScheduleRepo) *CronRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &CronRunner{engine: engine, scheduleRepo: scheduleRepo, cancel: cancel}
+	go r.run(ctx)
+	return r
+}
+
+func (r *CronRunner) Stop() {
+	r.cancel()
+}
+
+func (r *CronRunner) run(ctx context.Context) {
+	ticker := time.NewTicker(cronPollInterval)
+	defer ticker.Stop()
+	for {
+		r.fireDueSchedules(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *CronRunner) fireDueSchedules(ctx context.Context) {
+	now := time.Now()
+	schedules, err := r.scheduleRepo.ListDueSchedules(ctx, now, cronBatchSize)
+	if err != nil {
+		log.Println("list due schedules error:", err)
+		return
+	}
+	for _, schedule := range schedules {
+		r.fire(ctx, schedule, now)
+	}
+}
+
+// fire 先领取本次触发并更新下一次执行时间，再启动流程。服务停机期间错过的多次触发只会补发一次
+func (r *CronRunner) fire(ctx context.Context, schedule *model.WorkflowSchedule, now time.Time) {
+	var nextFireTime *time.Time
+	next, nextErr := NextFireTime(schedule.CronExpr, schedule.Timezone, now)
+	if nextErr == nil {
+		nextFireTime = &next
+	}
+	ok, err := r.scheduleRepo.ClaimSchedule(ctx, schedule, now, nextFireTime)
+	if err != nil {
+		log.Println("claim schedule error:", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	var errMsg string
+	workflowId, err := r.start(ctx, schedule)
+	if err != nil {
+		errMsg = err.Error()
+	} else if nextErr != nil {
+		errMsg = nextErr.Error()
+	}
+	if err := r.scheduleRepo.UpdateFireResult(context.WithoutCancel(ctx), schedule.Id, workflowId, errMsg); err != nil {
+		log.Println("update schedule result error:", err)
+	}
+}
+
+// start 使用模板当前的流程定义启动流程
+func (r *CronRunner) start(ctx context.Context, schedule *model.WorkflowSchedule) (int64, error) {
+	template, err := r.engine.templateRepo.GetDetail(ctx, schedule.TemplateId)
+	if err != nil {
+		return 0, err
+	}
+	if template == nil {
+		return 0, errors.New("模板不存在")
+	}
+	inputs := make(map[string]any)
+	if schedule.Inputs != "" {
+		if err := json.Unmarshal([]byte(schedule.Inputs), &inputs); err != nil {
+			return 0, fmt.Errorf("输入变量格式错误: %w", err)
+		}
+	}
+	return r.engine.Start(ctx, template.Data, template.Id, schedule.AddUser, inputs, "")
+}
+
+// NextFireTime 按时区计算cron表达式在now之后的下一次执行时间，时区为空时使用服务器时区
+func NextFireTime(cronExpr string, timezone string, now time.Time) (time.Time, error) {
+	cron, err := ParseCron(cronExpr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.Local
+	if timezone != "" {
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return time.Time{}, fmt.Errorf("无效的时区: %s", timezone)
+		}
+	}
+	next := cron.Next(now.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("cron表达式没有可以执行的时间")
+	}
+	return next, nil
+}
@@ -0,0 +1,60 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextFireTime(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("time zone database not available:", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available:", err)
+	}
+	// 2024-03-09 是星期六
+	now := time.Date(2024, 3, 9, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		name     string
+		expr     string
+		timezone string
+		now      time.Time
+		want     time.Time
+		wantErr  bool
+	}{
+		{"every minute", "* * * * *", "UTC", now, time.Date(2024, 3, 9, 10, 31, 0, 0, time.UTC), false},
+		{"exact minute is not repeated", "30 10 * * *", "UTC", time.Date(2024, 3, 9, 10, 30, 0, 0, time.UTC),
+			time.Date(2024, 3, 10, 10, 30, 0, 0, time.UTC), false},
+		{"step", "*/15 * * * *", "UTC", now, time.Date(2024, 3, 9, 10, 45, 0, 0, time.UTC), false},
+		{"daily macro", "@daily", "UTC", now, time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), false},
+		{"weekday names", "0 9 * * mon-fri", "UTC", now, time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC), false},
+		{"sunday as 7", "0 9 * * 7", "UTC", now, time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC), false},
+		{"day of month or day of week", "0 0 15 * mon", "UTC", now, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), false},
+		{"leap day", "0 0 29 2 *", "UTC", now, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC), false},
+		{"time zone", "0 9 * * *", "Asia/Shanghai", now, time.Date(2024, 3, 10, 9, 0, 0, 0, shanghai), false},
+		// 2024-03-10 02:00 纽约进入夏令时，当天没有2:30
+		{"daylight saving gap", "30 2 * * *", "America/New_York", time.Date(2024, 3, 9, 12, 0, 0, 0, newYork),
+			time.Date(2024, 3, 11, 2, 30, 0, 0, newYork), false},
+		{"hour step across daylight saving gap", "0 3 * * *", "America/New_York", time.Date(2024, 3, 10, 0, 30, 0, 0, newYork),
+			time.Date(2024, 3, 10, 3, 0, 0, 0, newYork), false},
+		// 2024-11-03 纽约结束夏令时，1点出现两次，使用第一次
+		{"daylight saving overlap", "30 1 * * *", "America/New_York", time.Date(2024, 11, 2, 12, 0, 0, 0, newYork),
+			time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), false},
+		{"impossible date", "0 0 30 2 *", "UTC", now, time.Time{}, true},
+		{"invalid expression", "0 0 * *", "UTC", now, time.Time{}, true},
+		{"invalid time zone", "* * * * *", "Mars/Olympus", now, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextFireTime(tt.expr, tt.timezone, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NextFireTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("NextFireTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,4 +1,176 @@
 package workflow
 
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultSchedulerWorkers = 16
+	defaultPollInterval     = 5 * time.Second
+	defaultLeaseDuration    = 30 * time.Second
+)
+
+// nodeQueue 调度器使用的节点队列，由节点实例表实现
+type nodeQueue interface {
+	ClaimQueuedNodeInstance(ctx context.Context, executor string, lease time.Duration) (*model.NodeInstance, error)
+	RenewNodeInstanceClaim(ctx context.Context, id int64, executor string, lease time.Duration) (bool, error)
+	RequeueExpiredNodeInstances(ctx context.Context) (int64, error)
+}
+
+// Scheduler 节点调度器，可执行的节点以排队状态保存在节点实例表中，由固定数量的工作协程领取执行。
+// 服务重启后队列中的节点不会丢失，多个服务实例也可以共享同一个队列。
+// 领取的节点带有租约，执行期间定期续约，领取节点的服务实例停止后租约过期，节点由任意服务实例重新排队
 type Scheduler struct {
+	queue        nodeQueue
+	executor     string // 当前服务实例id，记录在领取的节点实例上
+	workers      int
+	pollInterval time.Duration
+	lease        time.Duration
+	notify       chan struct{}
+	handler      func(nodeInstance *model.NodeInstance)
+	cancel       context.CancelFunc
+}
+
+func NewScheduler(queue nodeQueue, executor string, workers int, pollInterval time.Duration, lease time.Duration,
+	handler func(nodeInstance *model.NodeInstance)) *Scheduler {
+	if workers <= 0 {
+		workers = defaultSchedulerWorkers
+	}
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	if lease <= 0 {
+		lease = defaultLeaseDuration
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		queue:        queue,
+		executor:     executor,
+		workers:      workers,
+		pollInterval: pollInterval,
+		lease:        lease,
+		notify:       make(chan struct{}, workers),
+		handler:      handler,
+		cancel:       cancel,
+	}
+	for i := 0; i < workers; i++ {
+		go s.worker(ctx)
+	}
+	go s.requeueLoop(ctx)
+	return s
+}
+
+// newExecutorId 生成当前服务进程的唯一id，多个服务实例配置了相同的server.id时也不会把对方的节点当作自己的
+func newExecutorId(serverId string) string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	id := fmt.Sprintf("%s-%s-%s", serverId, hostname, hex.EncodeToString(suffix))
+	// executor字段最长64个字符，保留随机后缀
+	if len(id) > 64 {
+		id = id[len(id)-64:]
+	}
+	return id
+}
+
+// Notify 有新的节点进入队列时唤醒空闲的工作协程，队列满时说明工作协程都已被唤醒
+func (s *Scheduler) Notify() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) Stop() {
+	s.cancel()
+}
+
+// RequeueExpired 将租约过期的节点重新排队并唤醒工作协程
+func (s *Scheduler) RequeueExpired(ctx context.Context) (int64, error) {
+	requeued, err := s.queue.RequeueExpiredNodeInstances(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if requeued > 0 {
+		log.Printf("requeued %d node instances with expired claims", requeued)
+		s.Notify()
+	}
+	return requeued, nil
+}
+
+func (s *Scheduler) requeueLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.RequeueExpired(ctx); err != nil {
+				log.Println("requeue expired node instances error:", err)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) worker(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		// 持续领取直到队列为空，再等待通知或下一次轮询
+		for ctx.Err() == nil {
+			nodeInstance, err := s.queue.ClaimQueuedNodeInstance(ctx, s.executor, s.lease)
+			if err != nil {
+				log.Println("claim node instance error:", err)
+				break
+			}
+			if nodeInstance == nil {
+				break
+			}
+			s.run(nodeInstance)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// run 执行领取的节点，执行期间每隔三分之一租约时长续约一次
+func (s *Scheduler) run(nodeInstance *model.NodeInstance) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ok, err := s.queue.RenewNodeInstanceClaim(ctx, nodeInstance.Id, s.executor, s.lease)
+				if err != nil {
+					log.Println("renew node instance claim error:", err)
+					continue
+				}
+				// 节点已结束或租约过期后被重新领取
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	s.handler(nodeInstance)
+	cancel()
+	<-done
 }
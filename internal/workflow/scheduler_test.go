@@ -0,0 +1,188 @@
+package workflow
+
+import (
+	"context"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryQueue 内存中的节点队列，领取、续约和重新排队的条件与节点实例表相同
+type memoryQueue struct {
+	mutex sync.Mutex
+	nodes map[int64]*model.NodeInstance
+}
+
+func newMemoryQueue(nodes ...*model.NodeInstance) *memoryQueue {
+	q := &memoryQueue{nodes: make(map[int64]*model.NodeInstance)}
+	for _, node := range nodes {
+		q.nodes[node.Id] = node
+	}
+	return q
+}
+
+func (q *memoryQueue) ClaimQueuedNodeInstance(_ context.Context, executor string,
+	lease time.Duration) (*model.NodeInstance, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, node := range q.nodes {
+		if node.Status != model.NodeInstanceStatusQueued {
+			continue
+		}
+		claimedUntil := time.Now().Add(lease)
+		node.Status, node.Executor, node.ClaimedUntil = model.NodeInstanceStatusRunning, executor, &claimedUntil
+		claimed := *node
+		return &claimed, nil
+	}
+	return nil, nil
+}
+
+func (q *memoryQueue) RenewNodeInstanceClaim(_ context.Context, id int64, executor string,
+	lease time.Duration) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	node := q.nodes[id]
+	if node == nil || node.Status != model.NodeInstanceStatusRunning || node.Executor != executor {
+		return false, nil
+	}
+	claimedUntil := time.Now().Add(lease)
+	node.ClaimedUntil = &claimedUntil
+	return true, nil
+}
+
+func (q *memoryQueue) RequeueExpiredNodeInstances(_ context.Context) (int64, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var requeued int64
+	for _, node := range q.nodes {
+		if node.Status == model.NodeInstanceStatusRunning && (node.ClaimedUntil == nil || node.ClaimedUntil.Before(time.Now())) {
+			node.Status = model.NodeInstanceStatusQueued
+			requeued++
+		}
+	}
+	return requeued, nil
+}
+
+func (q *memoryQueue) complete(id int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.nodes[id].Status = model.NodeInstanceStatusCompleted
+}
+
+func (q *memoryQueue) get(id int64) model.NodeInstance {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return *q.nodes[id]
+}
+
+// handledRecorder 记录每个节点实例被执行的次数
+type handledRecorder struct {
+	mutex   sync.Mutex
+	counts  map[int64]int
+	handled chan int64
+}
+
+func newHandledRecorder() *handledRecorder {
+	return &handledRecorder{counts: make(map[int64]int), handled: make(chan int64, 100)}
+}
+
+func (r *handledRecorder) record(id int64) {
+	r.mutex.Lock()
+	r.counts[id]++
+	r.mutex.Unlock()
+	r.handled <- id
+}
+
+func (r *handledRecorder) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.handled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handled %d node instances, want %d", i, n)
+		}
+	}
+}
+
+func TestSchedulerClaimsEachNodeOnce(t *testing.T) {
+	const count = 50
+	nodes := make([]*model.NodeInstance, count)
+	for i := range nodes {
+		nodes[i] = &model.NodeInstance{Id: int64(i + 1), Status: model.NodeInstanceStatusQueued}
+	}
+	queue := newMemoryQueue(nodes...)
+	recorder := newHandledRecorder()
+	s := NewScheduler(queue, "test", 8, 10*time.Millisecond, time.Minute, func(ni *model.NodeInstance) {
+		queue.complete(ni.Id)
+		recorder.record(ni.Id)
+	})
+	defer s.Stop()
+	s.Notify()
+	recorder.wait(t, count)
+	// 等待一个轮询周期，确认没有重复执行
+	time.Sleep(50 * time.Millisecond)
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	for _, node := range nodes {
+		if c := recorder.counts[node.Id]; c != 1 {
+			t.Errorf("node instance %d handled %d times, want 1", node.Id, c)
+		}
+	}
+}
+
+func TestSchedulerRequeuesExpiredClaimsOnRecover(t *testing.T) {
+	expired := time.Now().Add(-time.Second)
+	alive := time.Now().Add(time.Hour)
+	queue := newMemoryQueue(
+		// 已停止的服务实例领取的节点
+		&model.NodeInstance{Id: 1, Status: model.NodeInstanceStatusRunning, Executor: "dead", ClaimedUntil: &expired},
+		// 升级前领取的节点没有租约
+		&model.NodeInstance{Id: 2, Status: model.NodeInstanceStatusRunning, Executor: "1"},
+		// 其他服务实例正在执行的节点
+		&model.NodeInstance{Id: 3, Status: model.NodeInstanceStatusRunning, Executor: "other", ClaimedUntil: &alive},
+	)
+	recorder := newHandledRecorder()
+	s := NewScheduler(queue, "test", 2, time.Hour, time.Minute, func(ni *model.NodeInstance) {
+		queue.complete(ni.Id)
+		recorder.record(ni.Id)
+	})
+	defer s.Stop()
+	requeued, err := s.RequeueExpired(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued != 2 {
+		t.Errorf("requeued = %d, want 2", requeued)
+	}
+	recorder.wait(t, 2)
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if recorder.counts[1] != 1 || recorder.counts[2] != 1 {
+		t.Errorf("expired node instances handled %v, want once each", recorder.counts)
+	}
+	if recorder.counts[3] != 0 || queue.get(3).Status != model.NodeInstanceStatusRunning {
+		t.Errorf("node instance with a live claim was requeued")
+	}
+}
+
+func TestSchedulerRenewsClaimWhileRunning(t *testing.T) {
+	queue := newMemoryQueue(&model.NodeInstance{Id: 1, Status: model.NodeInstanceStatusQueued})
+	recorder := newHandledRecorder()
+	lease := 60 * time.Millisecond
+	s := NewScheduler(queue, "test", 1, 10*time.Millisecond, lease, func(ni *model.NodeInstance) {
+		// 执行时间超过多个租约周期
+		time.Sleep(5 * lease)
+		queue.complete(ni.Id)
+		recorder.record(ni.Id)
+	})
+	defer s.Stop()
+	s.Notify()
+	recorder.wait(t, 1)
+	time.Sleep(50 * time.Millisecond)
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if c := recorder.counts[1]; c != 1 {
+		t.Errorf("node instance handled %d times while its claim was renewed, want 1", c)
+	}
+}
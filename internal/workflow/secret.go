@@ -0,0 +1,152 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"regexp"
+	"strings"
+)
+
+// secretReferencePattern 匹配节点配置中的{{env.NAME}}和{{secret.NAME}}
+var secretReferencePattern = regexp.MustCompile(`{{\s*(env|secret)\.(\w+)\s*}}`)
+
+const secretMask = "******"
+
+// secretMasker 把文本中出现的密钥值替换为******，用于脱敏保存的输入、输出和错误信息
+type secretMasker struct {
+	values []string
+}
+
+func (m *secretMasker) add(value string) {
+	if value == "" {
+		return
+	}
+	m.values = append(m.values, value)
+	// 保存为json时特殊字符会被转义，转义后的值也需要替换
+	data, _ := json.Marshal(value)
+	if escaped := string(data[1 : len(data)-1]); escaped != value {
+		m.values = append(m.values, escaped)
+	}
+}
+
+func (m *secretMasker) mask(text string) string {
+	if m == nil {
+		return text
+	}
+	for _, value := range m.values {
+		text = strings.ReplaceAll(text, value, secretMask)
+	}
+	return text
+}
+
+// partialSuffixLen 文本末尾与某个密钥开头相同的最长长度，流式输出时这部分内容要等后续片段到达后才能判断是否需要脱敏
+func (m *secretMasker) partialSuffixLen(text string) int {
+	if m == nil {
+		return 0
+	}
+	longest := 0
+	for _, value := range m.values {
+		for n := min(len(value)-1, len(text)); n > longest; n-- {
+			if strings.HasSuffix(text, value[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+type secretMaskerKey struct{}
+
+// withSecretMasker 节点执行期间通过上下文传递脱敏器，流式输出和人工任务等直接对外展示的内容需要脱敏
+func withSecretMasker(ctx context.Context, masker *secretMasker) context.Context {
+	return context.WithValue(ctx, secretMaskerKey{}, masker)
+}
+
+func secretMaskerFrom(ctx context.Context) *secretMasker {
+	masker, _ := ctx.Value(secretMaskerKey{}).(*secretMasker)
+	return masker
+}
+
+// maskError 脱敏错误信息，保留原错误以便判断是否可以重试
+func (m *secretMasker) maskError(err error) error {
+	if err == nil || m == nil || len(m.values) == 0 {
+		return err
+	}
+	msg := m.mask(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &maskedError{msg: msg, err: err}
+}
+
+type maskedError struct {
+	msg string
+	err error
+}
+
+func (e *maskedError) Error() string { return e.msg }
+
+func (e *maskedError) Unwrap() error { return e.err }
+
+// resolveSecrets 把节点配置中引用的环境变量和密钥替换为实际的值，返回替换后的节点副本，不修改流程定义中的节点。
+// 模板内的变量优先于同名的全局变量，引用不存在的变量时返回错误
+func (e *Engine) resolveSecrets(ctx context.Context, node *model.Node, templateId int64) (*model.Node, *secretMasker, error) {
+	masker := &secretMasker{}
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !secretReferencePattern.Match(data) {
+		return node, masker, nil
+	}
+	secrets, err := e.secretRepo.ListAvailable(ctx, templateId)
+	if err != nil {
+		return nil, nil, err
+	}
+	available := make(map[string]*model.Secret)
+	for _, secret := range secrets {
+		key := string(secret.Kind) + "." + secret.Name
+		if existing, ok := available[key]; ok && existing.TemplateId != 0 {
+			continue
+		}
+		available[key] = secret
+	}
+	var resolveErr error
+	resolved := secretReferencePattern.ReplaceAllStringFunc(string(data), func(match string) string {
+		groups := secretReferencePattern.FindStringSubmatch(match)
+		secret, ok := available[groups[1]+"."+groups[2]]
+		if !ok {
+			if resolveErr == nil {
+				resolveErr = fmt.Errorf("引用的变量不存在: %s.%s", groups[1], groups[2])
+			}
+			return match
+		}
+		value := secret.Value
+		if secret.Kind == model.SecretKindSecret {
+			plaintext, err := e.secretCipher.Decrypt(secret.Value)
+			if err != nil {
+				if resolveErr == nil {
+					resolveErr = fmt.Errorf("密钥%s解密失败: %w", secret.Name, err)
+				}
+				return match
+			}
+			value = plaintext
+			masker.add(value)
+		}
+		// 替换发生在json字符串中，需要转义
+		escaped, _ := json.Marshal(value)
+		return string(escaped[1 : len(escaped)-1])
+	})
+	if resolveErr != nil {
+		return nil, nil, resolveErr
+	}
+	var result model.Node
+	if err := json.Unmarshal([]byte(resolved), &result); err != nil {
+		return nil, nil, errors.New("替换节点中的变量失败")
+	}
+	return &result, masker, nil
+}
@@ -0,0 +1,67 @@
+package workflow
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChunkStreamMasksSecretsAcrossChunks(t *testing.T) {
+	masker := &secretMasker{}
+	masker.add("sk-123456")
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"whole secret", []string{"key is sk-123456."}, "key is ******."},
+		{"split secret", []string{"key is sk-", "123", "456."}, "key is ******."},
+		{"prefix only", []string{"key is sk-", "abc"}, "key is sk-abc"},
+		{"secret at end", []string{"sk-123", "456"}, "******"},
+		{"partial at end", []string{"done sk-12"}, "done sk-12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &chunkStream{masker: masker}
+			var sb strings.Builder
+			for _, chunk := range tt.chunks {
+				out := s.next(chunk)
+				if strings.Contains(out, "sk-123456") {
+					t.Fatalf("chunk %q leaked secret", out)
+				}
+				sb.WriteString(out)
+			}
+			sb.WriteString(s.pending)
+			if got := sb.String(); got != tt.want {
+				t.Errorf("stream output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskInputs(t *testing.T) {
+	masker := &secretMasker{}
+	masker.add("sk-123456")
+	inputs := map[string]any{
+		"key":    "sk-123456",
+		"header": "Bearer sk-123456",
+		"nested": map[string]any{"list": []any{"sk-123456", 1.5}},
+		"count":  float64(3),
+	}
+	got := maskInputs(masker, inputs)
+	want := map[string]any{
+		"key":    "******",
+		"header": "Bearer ******",
+		"nested": map[string]any{"list": []any{"******", 1.5}},
+		"count":  float64(3),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("maskInputs() = %v, want %v", got, want)
+	}
+	if inputs["key"] != "sk-123456" {
+		t.Errorf("maskInputs() modified the original inputs")
+	}
+	if got := maskInputs(nil, inputs); !reflect.DeepEqual(got, inputs) {
+		t.Errorf("maskInputs() without masker = %v, want %v", got, inputs)
+	}
+}
@@ -0,0 +1,186 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"log"
+	"time"
+)
+
+// maxSubWorkflowDepth 子流程最大嵌套层数
+const maxSubWorkflowDepth = 5
+
+// executeSubWorkflowNode 使用节点的输入变量作为开始节点参数创建子流程实例，节点进入等待状态，子流程结束后继续执行。
+// 开始节点的参数会保存在子流程中，输入中的密钥会被脱敏，子流程需要使用密钥时在子流程模板中直接引用
+func (e *Engine) executeSubWorkflowNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
+	nodeData *model.SubWorkflowNodeData, inputMap map[string]any) {
+	// 服务重启或流程重试时节点会重新执行，已经创建的子流程没有失败就继续等待
+	child, err := e.instanceRepo.GetLatestChildWorkflowInstance(ctx, nodeInstance.WorkflowId, node.Id)
+	if err != nil {
+		panic(err)
+	}
+	if child != nil && (child.Status == model.WorkflowInstanceStatusRunning ||
+		child.Status == model.WorkflowInstanceStatusCompleted) {
+		nodeInstance.Status = model.NodeInstanceStatusWaiting
+		return
+	}
+	if nodeData.TemplateId == 0 {
+		panic(errors.New("未选择子流程模板"))
+	}
+	// 检查嵌套层数，并且不能调用上层流程使用的模板
+	var addUser int64
+	workflowId := nodeInstance.WorkflowId
+	for depth := 1; workflowId != 0; depth++ {
+		if depth > maxSubWorkflowDepth {
+			panic(fmt.Errorf("子流程嵌套不能超过%d层", maxSubWorkflowDepth))
+		}
+		instance, err := e.instanceRepo.GetWorkflowInstance(ctx, workflowId)
+		if err != nil || instance == nil {
+			panic(errors.New("can't find flow instance"))
+		}
+		if instance.TemplateId == nodeData.TemplateId {
+			panic(errors.New("子流程不能调用当前流程或上层流程的模板"))
+		}
+		if addUser == 0 {
+			addUser = instance.AddUser
+		}
+		workflowId = instance.ParentId
+	}
+	template, err := e.templateRepo.GetDetail(ctx, nodeData.TemplateId)
+	if err != nil {
+		panic(err)
+	}
+	if template == nil {
+		panic(errors.New("子流程模板不存在"))
+	}
+	_, err = e.startWorkflow(ctx, &model.WorkflowInstance{
+		TemplateId:   nodeData.TemplateId,
+		Data:         template.Data,
+		AddUser:      addUser,
+		ParentId:     nodeInstance.WorkflowId,
+		ParentNodeId: node.Id,
+	}, maskInputs(secretMaskerFrom(ctx), inputMap))
+	if err != nil {
+		panic(fmt.Errorf("启动子流程失败: %w", err))
+	}
+	nodeInstance.Status = model.NodeInstanceStatusWaiting
+}
+
+// maskInputs 脱敏输入变量中的密钥，返回新的输入变量，不修改inputMap
+func maskInputs(masker *secretMasker, inputMap map[string]any) map[string]any {
+	result := make(map[string]any, len(inputMap))
+	for name, value := range inputMap {
+		result[name] = maskValue(masker, value)
+	}
+	return result
+}
+
+func maskValue(masker *secretMasker, value any) any {
+	switch v := value.(type) {
+	case string:
+		return masker.mask(v)
+	case map[string]any:
+		return maskInputs(masker, v)
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = maskValue(masker, item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// resumeParentWorkflow 子流程结束后，完成父流程中等待的子流程节点
+func (e *Engine) resumeParentWorkflow(ctx context.Context, workflowId int64) {
+	child, err := e.instanceRepo.GetWorkflowInstance(ctx, workflowId)
+	if err != nil || child == nil || child.ParentId == 0 {
+		return
+	}
+	nodeInstance, err := e.instanceRepo.GetNodeInstanceByNodeId(ctx, child.ParentId, child.ParentNodeId)
+	if err != nil || nodeInstance == nil || nodeInstance.Status != model.NodeInstanceStatusWaiting {
+		return
+	}
+	e.finishSubWorkflowNode(ctx, nodeInstance, child)
+}
+
+// syncSubWorkflow 子流程节点进入等待状态后，检查子流程是否已经结束
+func (e *Engine) syncSubWorkflow(ctx context.Context, nodeInstance *model.NodeInstance) {
+	child, err := e.instanceRepo.GetLatestChildWorkflowInstance(ctx, nodeInstance.WorkflowId, nodeInstance.NodeId)
+	if err != nil || child == nil || child.Status == model.WorkflowInstanceStatusRunning {
+		return
+	}
+	e.finishSubWorkflowNode(ctx, nodeInstance, child)
+}
+
+// finishSubWorkflowNode 子流程完成时结束节点的输出作为子流程节点的输出，子流程失败或取消时按照节点的errorMode处理
+func (e *Engine) finishSubWorkflowNode(ctx context.Context, nodeInstance *model.NodeInstance, child *model.WorkflowInstance) {
+	instanceCtx := e.instanceContext(nodeInstance.WorkflowId)
+	plan, err := e.refreshPlan(instanceCtx, nodeInstance.WorkflowId)
+	var node *model.Node
+	if err == nil {
+		if node = plan.Node(nodeInstance.NodeId); node == nil {
+			err = fmt.Errorf("node not found: %s", nodeInstance.NodeId)
+		}
+	}
+	nodeInstance.CompleteTime = time.Now()
+	nodeInstance.Output = "{}"
+	switch child.Status {
+	case model.WorkflowInstanceStatusCompleted:
+		output, err := e.instanceRepo.GetEndNodeOutput(ctx, child.Id)
+		if err != nil {
+			nodeInstance.Status = model.NodeInstanceStatusFailed
+			nodeInstance.Error = err.Error()
+			break
+		}
+		nodeInstance.Status = model.NodeInstanceStatusCompleted
+		nodeInstance.Output = output
+	case model.WorkflowInstanceStatusCancelled:
+		nodeInstance.Status = model.NodeInstanceStatusFailed
+		nodeInstance.Error = "子流程已取消"
+	default:
+		nodeInstance.Status = model.NodeInstanceStatusFailed
+		nodeInstance.Error = "子流程执行失败"
+	}
+	if nodeInstance.Status == model.NodeInstanceStatusFailed && node != nil &&
+		applyErrorMode(node, nodeInstance, errors.New(nodeInstance.Error)) == nil {
+		nodeInstance.Status = model.NodeInstanceStatusCompleted
+	}
+	// 子流程结束和节点进入等待状态可能同时发生，只有一方能更新成功
+	ok, updateErr := e.instanceRepo.FinishWaitingNodeInstance(ctx, nodeInstance)
+	if updateErr != nil {
+		log.Println("update node instance failed", updateErr)
+	}
+	if !ok {
+		return
+	}
+	e.sendNodeMessage(nodeInstance)
+	if nodeInstance.Status != model.NodeInstanceStatusCompleted {
+		e.UpdateWorkflowFailed(ctx, nodeInstance.WorkflowId)
+		return
+	}
+	if err == nil {
+		err = e.stepWorkflow(instanceCtx, node, nodeInstance.WorkflowId)
+	}
+	if err != nil {
+		log.Println("step workflow error:", err)
+		e.UpdateWorkflowFailed(ctx, nodeInstance.WorkflowId)
+	}
+}
+
+// cancelChildWorkflows 父流程取消或失败时取消运行中的子流程
+func (e *Engine) cancelChildWorkflows(ctx context.Context, workflowId int64) {
+	childIds, err := e.instanceRepo.ListRunningChildWorkflowIds(ctx, workflowId)
+	if err != nil {
+		log.Println("list child workflow error:", err)
+		return
+	}
+	for _, childId := range childIds {
+		if err := e.Cancel(ctx, childId); err != nil {
+			log.Println("cancel child workflow error:", err)
+		}
+	}
+}
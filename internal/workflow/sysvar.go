@@ -0,0 +1,52 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lookupSystemVariables 根据流程实例生成系统变量，子流程中的系统变量属于子流程实例。
+// query取开始节点的query输入，没有query输入时使用全部输入的json
+func (e *Engine) lookupSystemVariables(ctx context.Context, workflowId int64) (map[string]any, error) {
+	instance, err := e.instanceRepo.GetWorkflowInstance(ctx, workflowId)
+	if err != nil {
+		return nil, err
+	}
+	if instance == nil {
+		return nil, errors.New("流程实例不存在")
+	}
+	startOutput, err := e.instanceRepo.GetStartNodeOutput(ctx, workflowId)
+	if err != nil {
+		return nil, err
+	}
+	query := startOutput
+	var input map[string]any
+	if err := json.Unmarshal([]byte(startOutput), &input); err == nil {
+		if q, ok := input["query"].(string); ok {
+			query = q
+		}
+	}
+	return map[string]any{
+		"query":       query,
+		"workflow_id": strconv.FormatInt(instance.Id, 10),
+		"template_id": strconv.FormatInt(instance.TemplateId, 10),
+		"user":        strconv.FormatInt(instance.AddUser, 10),
+		"time":        instance.AddTime.Format(time.DateTime),
+	}, nil
+}
+
+// findSystemVariable 按变量名查找系统变量，name可以带sys.前缀
+func findSystemVariable(name string) *model.Input {
+	name = strings.TrimPrefix(name, model.SystemVariableNodeId+".")
+	for i := range model.SystemVariablePrototype {
+		if model.SystemVariablePrototype[i].Value.SourceName == name {
+			return &model.SystemVariablePrototype[i]
+		}
+	}
+	return nil
+}
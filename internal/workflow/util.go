@@ -80,13 +80,35 @@ func GetNodeOutputVariables(node *model.Node) []model.Output {
 		}
 		return outputs
 	}
-	return node.Data.Output
+	outputs := node.Data.Output
+	// 人工节点的表单字段也是输出变量
+	if node.Type == model.NodeTypeHumanTask && node.Data.HumanTaskNodeData != nil {
+		outputs = slices.Clone(outputs)
+		for _, field := range node.Data.HumanTaskNodeData.FormFields {
+			outputs = append(outputs, model.Output{Name: field.Name, Type: field.Type})
+		}
+	}
+	// 失败分支的后续节点可以引用错误信息
+	if hasErrorBranch(node) {
+		outputs = append(slices.Clone(outputs),
+			model.Output{Name: model.NodeErrorMessageField, Type: model.VariableTypeString},
+			model.Output{Name: model.NodeErrorTypeField, Type: model.VariableTypeString})
+	}
+	return outputs
+}
+
+func hasErrorBranch(node *model.Node) bool {
+	return node.Data.Policy != nil && node.Data.Policy.ErrorMode == model.ErrorModeBranch
 }
 
 func GetPassedEdges(definition *model.WorkflowDefinition, nodes []*model.NodeStatusDTO,
 	branches []*model.WorkflowInstanceSuccessBranchDTO) []string {
 	nodeMap := make(map[string]struct{})
 	for _, node := range nodes {
+		// 跳过的节点没有被执行
+		if node.Status == model.NodeInstanceStatusUnreached {
+			continue
+		}
 		nodeMap[node.NodeId] = struct{}{}
 	}
 	branchMap := make(map[string]string)
@@ -103,6 +125,9 @@ func GetPassedEdges(definition *model.WorkflowDefinition, nodes []*model.NodeSta
 		if edge.SourceHandle != "" {
 			// handle是否是成功的分支
 			ok3 = branchMap[edge.Source] == edge.SourceHandle
+		} else {
+			// 节点进入失败分支时没有handle的边不会被执行
+			ok3 = branchMap[edge.Source] != model.NodeErrorHandle
 		}
 		if ok1 && ok2 && ok3 {
 			passedEdges = append(passedEdges, edge.Id)
@@ -0,0 +1,494 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"slices"
+)
+
+// ValidationError 流程定义校验失败，Diagnostics包含全部诊断信息
+type ValidationError struct {
+	Diagnostics []*model.Diagnostic
+}
+
+func (e *ValidationError) Error() string {
+	var errs []*model.Diagnostic
+	for _, d := range e.Diagnostics {
+		if d.Level == model.DiagnosticLevelError {
+			errs = append(errs, d)
+		}
+	}
+	if len(errs) == 0 {
+		return "流程定义校验失败"
+	}
+	if len(errs) == 1 {
+		return "流程定义校验失败: " + errs[0].Message
+	}
+	return fmt.Sprintf("流程定义校验失败: %s 等%d个错误", errs[0].Message, len(errs))
+}
+
+// ValidateDefinitionJSON 解析并校验流程定义json
+func ValidateDefinitionJSON(data string) *model.ValidateDefinitionResult {
+	var definition model.WorkflowDefinition
+	if err := json.Unmarshal([]byte(data), &definition); err != nil {
+		return &model.ValidateDefinitionResult{
+			Diagnostics: []*model.Diagnostic{{Level: model.DiagnosticLevelError, Message: "流程定义格式错误"}},
+		}
+	}
+	diagnostics := ValidateDefinition(&definition)
+	return &model.ValidateDefinitionResult{
+		Valid:       !slices.ContainsFunc(diagnostics, isErrorDiagnostic),
+		Diagnostics: diagnostics,
+	}
+}
+
+// CheckDefinition 校验流程定义，存在错误时返回ValidationError
+func CheckDefinition(definition *model.WorkflowDefinition) error {
+	diagnostics := ValidateDefinition(definition)
+	if slices.ContainsFunc(diagnostics, isErrorDiagnostic) {
+		return &ValidationError{Diagnostics: diagnostics}
+	}
+	return nil
+}
+
+func isErrorDiagnostic(d *model.Diagnostic) bool {
+	return d.Level == model.DiagnosticLevelError
+}
+
+// ValidateDefinition 静态检查流程定义：环、不可达节点、开始和结束节点、连线的节点和分支、变量引用及类型、模型配置
+func ValidateDefinition(definition *model.WorkflowDefinition) []*model.Diagnostic {
+	v := &validator{diagnostics: make([]*model.Diagnostic, 0)}
+	v.validateGraph(definition, nil)
+	return v.diagnostics
+}
+
+type validator struct {
+	diagnostics []*model.Diagnostic
+	parentId    string // 正在检查的迭代子流程所属的迭代节点
+}
+
+// graph 流程或迭代子流程中有效连线组成的图
+type graph struct {
+	nodes    map[string]*model.Node
+	outgoing map[string][]*model.Edge
+	incoming map[string][]*model.Edge
+}
+
+// outerScope 迭代子流程可以引用的外层节点
+type outerScope struct {
+	iterationNode *model.Node
+	graph         *graph
+	ancestors     map[string]struct{} // 迭代节点的上游节点
+}
+
+func (v *validator) report(level model.DiagnosticLevel, nodeId, edgeId string, format string, args ...any) {
+	v.diagnostics = append(v.diagnostics, &model.Diagnostic{
+		Level:        level,
+		NodeId:       nodeId,
+		EdgeId:       edgeId,
+		ParentNodeId: v.parentId,
+		Message:      fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) errorf(nodeId, edgeId string, format string, args ...any) {
+	v.report(model.DiagnosticLevelError, nodeId, edgeId, format, args...)
+}
+
+func (v *validator) warnf(nodeId, edgeId string, format string, args ...any) {
+	v.report(model.DiagnosticLevelWarning, nodeId, edgeId, format, args...)
+}
+
+func (v *validator) validateGraph(definition *model.WorkflowDefinition, outer *outerScope) {
+	g := &graph{
+		nodes:    make(map[string]*model.Node),
+		outgoing: make(map[string][]*model.Edge),
+		incoming: make(map[string][]*model.Edge),
+	}
+	var startNodes, endNodes []*model.Node
+	for _, node := range definition.Nodes {
+		if node == nil {
+			continue
+		}
+		if node.Id == "" {
+			v.errorf("", "", "节点%s缺少id", node.Data.Name)
+			continue
+		}
+		if _, ok := g.nodes[node.Id]; ok {
+			v.errorf(node.Id, "", "节点id重复: %s", node.Id)
+			continue
+		}
+		g.nodes[node.Id] = node
+		switch node.Type {
+		case model.NodeTypeStart:
+			startNodes = append(startNodes, node)
+		case model.NodeTypeEnd:
+			endNodes = append(endNodes, node)
+		}
+	}
+	if outer == nil {
+		if len(startNodes) == 0 {
+			v.errorf("", "", "缺少开始节点")
+		} else if len(startNodes) > 1 {
+			for _, node := range startNodes[1:] {
+				v.errorf(node.Id, "", "只能有一个开始节点")
+			}
+		}
+	}
+	if len(endNodes) == 0 {
+		if outer == nil {
+			v.errorf("", "", "缺少结束节点")
+		} else {
+			v.errorf(outer.iterationNode.Id, "", "迭代子流程缺少结束节点")
+		}
+	}
+	v.validateEdges(definition.Edges, g)
+	v.validateCycles(definition, g)
+	if outer == nil && len(startNodes) > 0 {
+		reachable := g.reachable(startNodes[0].Id)
+		for _, node := range definition.Nodes {
+			if _, ok := reachable[node.Id]; node != nil && node.Id != "" && !ok {
+				v.warnf(node.Id, "", "节点%s无法从开始节点到达", node.Data.Name)
+			}
+		}
+	}
+	for _, node := range definition.Nodes {
+		if node == nil || g.nodes[node.Id] != node {
+			continue
+		}
+		if outer != nil {
+			switch node.Type {
+			case model.NodeTypeStart, model.NodeTypeIteration, model.NodeTypeHumanTask, model.NodeTypeSubWorkflow:
+				v.errorf(node.Id, "", "迭代子流程不支持节点类型: %s", node.Type)
+				continue
+			}
+		}
+		v.validateNodeData(node)
+		v.validateReferences(node, g, outer)
+		if node.Type == model.NodeTypeIteration && node.Data.IterationNodeData != nil &&
+			node.Data.IterationNodeData.SubGraph != nil {
+			sub := &validator{diagnostics: v.diagnostics, parentId: node.Id}
+			sub.validateGraph(node.Data.IterationNodeData.SubGraph, &outerScope{
+				iterationNode: node,
+				graph:         g,
+				ancestors:     g.ancestors(node.Id),
+			})
+			v.diagnostics = sub.diagnostics
+		}
+	}
+}
+
+// validateEdges 检查连线的节点和分支是否存在，只有有效的连线加入图中
+func (v *validator) validateEdges(edges []*model.Edge, g *graph) {
+	for _, edge := range edges {
+		if edge == nil {
+			continue
+		}
+		source, ok := g.nodes[edge.Source]
+		if !ok {
+			v.errorf("", edge.Id, "连线的起始节点不存在: %s", edge.Source)
+			continue
+		}
+		target, ok := g.nodes[edge.Target]
+		if !ok {
+			v.errorf(source.Id, edge.Id, "连线的目标节点不存在: %s", edge.Target)
+			continue
+		}
+		if source.Type == model.NodeTypeEnd {
+			v.errorf(source.Id, edge.Id, "结束节点不能连接其他节点")
+			continue
+		}
+		if target.Type == model.NodeTypeStart {
+			v.errorf(target.Id, edge.Id, "开始节点不能被其他节点连接")
+			continue
+		}
+		handles := sourceHandles(source)
+		if handles == nil && edge.SourceHandle != "" {
+			v.errorf(source.Id, edge.Id, "节点%s没有分支: %s", source.Data.Name, edge.SourceHandle)
+			continue
+		}
+		if handles != nil && !slices.Contains(handles, edge.SourceHandle) {
+			v.errorf(source.Id, edge.Id, "节点%s的分支不存在: %s", source.Data.Name, edge.SourceHandle)
+			continue
+		}
+		if edge.TargetHandle != "" {
+			v.errorf(target.Id, edge.Id, "节点%s的连接点不存在: %s", target.Data.Name, edge.TargetHandle)
+			continue
+		}
+		g.outgoing[edge.Source] = append(g.outgoing[edge.Source], edge)
+		g.incoming[edge.Target] = append(g.incoming[edge.Target], edge)
+	}
+}
+
+// sourceHandles 节点的分支，返回nil表示节点没有分支，出边不能指定分支
+func sourceHandles(node *model.Node) []string {
+	handles := nodeBranchHandles(node)
+	if hasErrorBranch(node) {
+		if handles == nil {
+			handles = []string{""}
+		}
+		handles = append(handles, model.NodeErrorHandle)
+	}
+	return handles
+}
+
+func nodeBranchHandles(node *model.Node) []string {
+	switch node.Type {
+	case model.NodeTypeCondition:
+		handles := make([]string, 0)
+		if node.Data.ConditionNodeData != nil {
+			for _, branch := range node.Data.ConditionNodeData.Branches {
+				handles = append(handles, branch.Handle)
+			}
+		}
+		return handles
+	case model.NodeTypeHumanTask:
+		// 人工节点没有指定分支的出边在通过和驳回时都会执行
+		return []string{"", model.HumanTaskHandleApprove, model.HumanTaskHandleReject}
+	default:
+		return nil
+	}
+}
+
+// validateCycles 深度优先遍历，指向遍历栈中节点的连线形成环
+func (v *validator) validateCycles(definition *model.WorkflowDefinition, g *graph) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		for _, edge := range g.outgoing[id] {
+			switch state[edge.Target] {
+			case unvisited:
+				visit(edge.Target)
+			case visiting:
+				v.errorf(edge.Target, edge.Id, "连线形成环: %s -> %s", g.nodes[edge.Source].Data.Name,
+					g.nodes[edge.Target].Data.Name)
+			}
+		}
+		state[id] = visited
+	}
+	for _, node := range definition.Nodes {
+		if node != nil && g.nodes[node.Id] == node && state[node.Id] == unvisited {
+			visit(node.Id)
+		}
+	}
+}
+
+// validateNodeData 检查节点类型对应的配置和模型
+func (v *validator) validateNodeData(node *model.Node) {
+	var modelId int64
+	var fallbacks model.ModelIds
+	var configured bool
+	requireModel := true
+	switch node.Type {
+	case model.NodeTypeLLM:
+		if configured = node.Data.LLMNodeData != nil; configured {
+			modelId, fallbacks = node.Data.LLMNodeData.ModelId, node.Data.LLMNodeData.FallbackModelIds
+		}
+	case model.NodeTypeKeywordExtraction:
+		if configured = node.Data.KeywordExtractionNodeData != nil; configured {
+			modelId, fallbacks = node.Data.KeywordExtractionNodeData.ModelId, node.Data.KeywordExtractionNodeData.FallbackModelIds
+		}
+	case model.NodeTypeQuestionOptimization:
+		if configured = node.Data.QuestionOptimizationNodeData != nil; configured {
+			modelId, fallbacks = node.Data.QuestionOptimizationNodeData.ModelId, node.Data.QuestionOptimizationNodeData.FallbackModelIds
+		}
+	case model.NodeTypeImageUnderstanding:
+		if configured = node.Data.ImageUnderstandingNodeData != nil; configured {
+			modelId, fallbacks = node.Data.ImageUnderstandingNodeData.ModelId, node.Data.ImageUnderstandingNodeData.FallbackModelIds
+		}
+	case model.NodeTypeOCR:
+		if configured = node.Data.OCRNodeData != nil; configured {
+			modelId = node.Data.OCRNodeData.ModelId
+		}
+	case model.NodeTypeStart:
+		requireModel, configured = false, node.Data.StartNodeData != nil
+	case model.NodeTypeCondition:
+		requireModel, configured = false, node.Data.ConditionNodeData != nil
+	case model.NodeTypeIteration:
+		requireModel, configured = false, node.Data.IterationNodeData != nil && node.Data.IterationNodeData.SubGraph != nil
+	case model.NodeTypeSubWorkflow:
+		requireModel, configured = false, node.Data.SubWorkflowNodeData != nil
+		if configured && node.Data.SubWorkflowNodeData.TemplateId == 0 {
+			v.errorf(node.Id, "", "节点%s未选择子流程模板", node.Data.Name)
+		}
+	case model.NodeTypeCode:
+		requireModel, configured = false, node.Data.CodeNodeData != nil
+	case model.NodeTypeHumanTask:
+		requireModel, configured = false, node.Data.HumanTaskNodeData != nil
+	case model.NodeTypeEnd:
+		requireModel, configured = false, node.Data.EndNodeData != nil
+	case model.NodeTypeCrawler:
+		requireModel, configured = false, node.Data.CrawlerNodeData != nil
+	case model.NodeTypeWebSearch:
+		requireModel, configured = false, node.Data.WebSearchNodeData != nil
+	case model.NodeTypeKnowledgeRetrieval:
+		requireModel, configured = false, node.Data.RetrieveKnowledgeBaseNodeData != nil
+		if configured && node.Data.RetrieveKnowledgeBaseNodeData.KbId == 0 {
+			v.errorf(node.Id, "", "节点%s未选择知识库", node.Data.Name)
+		}
+	case model.NodeTypeKnowledgeWrite:
+		requireModel, configured = false, node.Data.KnowledgeBaseWriteNodeData != nil
+		if configured && node.Data.KnowledgeBaseWriteNodeData.KbId == 0 {
+			v.errorf(node.Id, "", "节点%s未选择知识库", node.Data.Name)
+		}
+	default:
+		v.errorf(node.Id, "", "节点%s的类型不支持: %s", node.Data.Name, node.Type)
+		return
+	}
+	if !configured {
+		v.errorf(node.Id, "", "节点%s缺少配置", node.Data.Name)
+		return
+	}
+	if requireModel && modelId == 0 {
+		v.errorf(node.Id, "", "节点%s未选择模型", node.Data.Name)
+	}
+	for _, fallback := range fallbacks {
+		if fallback == 0 || fallback == modelId {
+			v.errorf(node.Id, "", "节点%s的备用模型无效", node.Data.Name)
+			break
+		}
+	}
+	if node.Data.Policy != nil {
+		switch node.Data.Policy.ErrorMode {
+		case "", model.ErrorModeFail:
+		case model.ErrorModeDefault, model.ErrorModeBranch:
+			if node.Type == model.NodeTypeStart || node.Type == model.NodeTypeEnd {
+				v.errorf(node.Id, "", "节点%s不支持失败处理方式: %s", node.Data.Name, node.Data.Policy.ErrorMode)
+			}
+		default:
+			v.errorf(node.Id, "", "节点%s的失败处理方式无效: %s", node.Data.Name, node.Data.Policy.ErrorMode)
+		}
+	}
+}
+
+// validateReferences 检查节点引用的变量是否存在于上游节点的输出中，以及变量类型是否匹配
+func (v *validator) validateReferences(node *model.Node, g *graph, outer *outerScope) {
+	if node.Type == model.NodeTypeStart {
+		return
+	}
+	ancestors := g.ancestors(node.Id)
+	for _, input := range node.Data.Input {
+		v.validateReference(node, &input, g, ancestors, outer, true)
+	}
+	// 条件节点比较时使用来源变量的类型，不检查类型是否匹配
+	if node.Type == model.NodeTypeCondition && node.Data.ConditionNodeData != nil {
+		for _, branch := range node.Data.ConditionNodeData.Branches {
+			for _, condition := range branch.Conditions {
+				for _, input := range []*model.Input{condition.Value1, condition.Value2} {
+					if input != nil {
+						v.validateReference(node, input, g, ancestors, outer, false)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) validateReference(node *model.Node, input *model.Input, g *graph, ancestors map[string]struct{},
+	outer *outerScope, checkType bool) {
+	if input.Value.Type != model.VarValueTypeRef {
+		return
+	}
+	sourceId, sourceName := input.Value.SourceNode, input.Value.SourceName
+	if sourceId == "" || sourceName == "" {
+		v.errorf(node.Id, "", "节点%s的变量%s未选择引用的变量", node.Data.Name, input.Name)
+		return
+	}
+	var output *model.Output
+	switch {
+	case sourceId == model.SystemVariableNodeId:
+		sysVar := findSystemVariable(sourceName)
+		if sysVar == nil {
+			v.errorf(node.Id, "", "节点%s的变量%s引用的系统变量不存在: %s", node.Data.Name, input.Name, sourceName)
+			return
+		}
+		output = &model.Output{Name: sysVar.Name, Type: sysVar.Type}
+	case outer != nil && sourceId == outer.iterationNode.Id:
+		output = iterationScopeVariable(outer.iterationNode, sourceName)
+	case g.nodes[sourceId] != nil:
+		if _, ok := ancestors[sourceId]; !ok {
+			v.errorf(node.Id, "", "节点%s的变量%s引用的节点%s不是上游节点", node.Data.Name, input.Name,
+				g.nodes[sourceId].Data.Name)
+			return
+		}
+		output = FindNodeOutputVariable(g.nodes[sourceId], sourceName)
+	case outer != nil && outer.graph.nodes[sourceId] != nil:
+		if _, ok := outer.ancestors[sourceId]; !ok {
+			v.errorf(node.Id, "", "节点%s的变量%s引用的节点%s不是迭代节点的上游节点", node.Data.Name, input.Name,
+				outer.graph.nodes[sourceId].Data.Name)
+			return
+		}
+		output = FindNodeOutputVariable(outer.graph.nodes[sourceId], sourceName)
+	default:
+		v.errorf(node.Id, "", "节点%s的变量%s引用的节点不存在: %s", node.Data.Name, input.Name, sourceId)
+		return
+	}
+	if output == nil {
+		v.errorf(node.Id, "", "节点%s的变量%s引用的变量不存在: %s", node.Data.Name, input.Name, sourceName)
+		return
+	}
+	if checkType && !isVariableTypeCompatible(input.Type, output.Type) {
+		v.errorf(node.Id, "", "节点%s的变量%s类型为%s，引用的变量%s类型为%s", node.Data.Name, input.Name,
+			input.Type, sourceName, output.Type)
+	}
+}
+
+// iterationScopeVariable 迭代子流程中可以引用的迭代节点变量，item的类型是items数组的元素类型
+func iterationScopeVariable(node *model.Node, name string) *model.Output {
+	switch name {
+	case "index":
+		return &model.Output{Name: name, Type: model.VariableTypeNumber}
+	case "item":
+		itemType := model.VariableTypeString
+		if len(node.Data.Input) > 0 && node.Data.Input[0].Type == model.VariableTypeNumberArray {
+			itemType = model.VariableTypeNumber
+		}
+		return &model.Output{Name: name, Type: itemType}
+	default:
+		return FindNodeOutputVariable(node, name)
+	}
+}
+
+// isVariableTypeCompatible 引用的变量类型是否可以赋值给输入变量，字符串类型的输入可以接收除图片以外的任意类型
+func isVariableTypeCompatible(inputType, outputType model.VariableType) bool {
+	if inputType == "" || outputType == "" || inputType == outputType {
+		return true
+	}
+	return inputType == model.VariableTypeString && outputType != model.VariableTypeImageFile
+}
+
+// reachable 从节点出发可以到达的节点
+func (g *graph) reachable(id string) map[string]struct{} {
+	return g.traverse(id, g.outgoing, func(e *model.Edge) string { return e.Target })
+}
+
+// ancestors 节点的所有上游节点
+func (g *graph) ancestors(id string) map[string]struct{} {
+	result := g.traverse(id, g.incoming, func(e *model.Edge) string { return e.Source })
+	delete(result, id)
+	return result
+}
+
+func (g *graph) traverse(id string, adjacency map[string][]*model.Edge, next func(*model.Edge) string) map[string]struct{} {
+	result := map[string]struct{}{id: {}}
+	queue := []string{id}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		for _, edge := range adjacency[curr] {
+			nextId := next(edge)
+			if _, ok := result[nextId]; !ok {
+				result[nextId] = struct{}{}
+				queue = append(queue, nextId)
+			}
+		}
+	}
+	return result
+}
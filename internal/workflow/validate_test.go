@@ -0,0 +1,90 @@
+package workflow
+
+import (
+	"errors"
+	"github.com/StellrisJAY/workflow-ai/internal/model"
+	"strings"
+	"testing"
+)
+
+func testNode(id string, nodeType model.NodeType, data model.NodeData) *model.Node {
+	data.Name = id
+	return &model.Node{Id: id, Type: nodeType, Data: data}
+}
+
+func refInput(name string, varType model.VariableType, sourceNode, sourceName string) model.Input {
+	return model.Input{Name: name, Type: varType, Value: model.Value{Type: model.VarValueTypeRef, SourceNode: sourceNode, SourceName: sourceName}}
+}
+
+// testDefinition 开始节点 -> 中间节点 -> 结束节点
+func testDefinition(middle *model.Node) *model.WorkflowDefinition {
+	start := testNode("start", model.NodeTypeStart, model.NodeData{
+		StartNodeData: &model.StartNodeData{},
+		Input:         []model.Input{{Name: "query", Type: model.VariableTypeString}},
+	})
+	end := testNode("end", model.NodeTypeEnd, model.NodeData{EndNodeData: &model.EndNodeData{}})
+	return &model.WorkflowDefinition{
+		Nodes: []*model.Node{start, middle, end},
+		Edges: []*model.Edge{
+			{Id: "e1", Source: "start", Target: middle.Id},
+			{Id: "e2", Source: middle.Id, Target: "end"},
+		},
+	}
+}
+
+func TestCheckDefinition(t *testing.T) {
+	query := []model.Input{refInput("url", model.VariableTypeString, "start", "query")}
+	tests := []struct {
+		name    string
+		def     *model.WorkflowDefinition
+		wantErr string
+	}{
+		{"valid crawler", testDefinition(testNode("http", model.NodeTypeCrawler, model.NodeData{
+			CrawlerNodeData: &model.CrawlerNodeData{}, Input: query})), ""},
+		{"valid web search", testDefinition(testNode("search", model.NodeTypeWebSearch, model.NodeData{
+			WebSearchNodeData: &model.WebSearchNodeData{TopN: 3}})), ""},
+		{"crawler missing config", testDefinition(testNode("http", model.NodeTypeCrawler, model.NodeData{Input: query})), "节点http缺少配置"},
+		{"web search missing config", testDefinition(testNode("search", model.NodeTypeWebSearch, model.NodeData{})), "节点search缺少配置"},
+		{"retrieval missing config", testDefinition(testNode("kb", model.NodeTypeKnowledgeRetrieval, model.NodeData{})), "节点kb缺少配置"},
+		{"retrieval missing kb", testDefinition(testNode("kb", model.NodeTypeKnowledgeRetrieval, model.NodeData{
+			RetrieveKnowledgeBaseNodeData: &model.RetrieveKnowledgeBaseNodeData{}})), "节点kb未选择知识库"},
+		{"knowledge write missing config", testDefinition(testNode("kb", model.NodeTypeKnowledgeWrite, model.NodeData{})), "节点kb缺少配置"},
+		{"llm missing model", testDefinition(testNode("llm", model.NodeTypeLLM, model.NodeData{
+			LLMNodeData: &model.LLMNodeData{}})), "节点llm未选择模型"},
+		{"invalid fallback", testDefinition(testNode("llm", model.NodeTypeLLM, model.NodeData{
+			LLMNodeData: &model.LLMNodeData{ModelId: 1, FallbackModelIds: model.ModelIds{1}}})), "节点llm的备用模型无效"},
+		{"unknown node type", testDefinition(testNode("x", model.NodeType("unknown"), model.NodeData{})), "节点x的类型不支持: unknown"},
+		{"missing reference", testDefinition(testNode("http", model.NodeTypeCrawler, model.NodeData{
+			CrawlerNodeData: &model.CrawlerNodeData{},
+			Input:           []model.Input{refInput("url", model.VariableTypeString, "start", "missing")}})), "引用的变量不存在"},
+		{"downstream reference", testDefinition(testNode("http", model.NodeTypeCrawler, model.NodeData{
+			CrawlerNodeData: &model.CrawlerNodeData{},
+			Input:           []model.Input{refInput("url", model.VariableTypeString, "end", "query")}})), "不是上游节点"},
+		{"missing start", &model.WorkflowDefinition{Nodes: []*model.Node{
+			testNode("end", model.NodeTypeEnd, model.NodeData{EndNodeData: &model.EndNodeData{}})}}, "缺少开始节点"},
+		{"invalid error mode", testDefinition(testNode("search", model.NodeTypeWebSearch, model.NodeData{
+			WebSearchNodeData: &model.WebSearchNodeData{},
+			Policy:            &model.ExecutionPolicy{ErrorMode: "ignore"}})), "失败处理方式无效"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckDefinition(tt.def)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckDefinition() error = %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("CheckDefinition() error = %v, want ValidationError", err)
+			}
+			for _, d := range validationErr.Diagnostics {
+				if d.Level == model.DiagnosticLevelError && strings.Contains(d.Message, tt.wantErr) {
+					return
+				}
+			}
+			t.Errorf("CheckDefinition() error = %v, want diagnostic containing %q", err, tt.wantErr)
+		})
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"time"
 )
 
 func (e *Engine) executeWebSearchNode(ctx context.Context, node *model.Node, nodeInstance *model.NodeInstance,
@@ -25,7 +24,7 @@ func (e *Engine) executeWebSearchNode(ctx context.Context, node *model.Node, nod
 		panic(errors.New("topN参数错误"))
 	}
 	searchProvider := websearch.CreateSearchProvider("bocha", e.conf)
-	result, err := searchProvider.Search(query, count)
+	result, err := searchProvider.Search(ctx, query, count)
 	if err != nil {
 		panic(err)
 	}
@@ -33,7 +32,7 @@ func (e *Engine) executeWebSearchNode(ctx context.Context, node *model.Node, nod
 	urls := make([]string, len(result))
 	for i, res := range result {
 		urls[i] = res.URL
-		response, err := httpRequest(res.URL, http.MethodGet)
+		response, err := httpRequest(ctx, res.URL, http.MethodGet)
 		if err != nil {
 			panic(err)
 		}
@@ -55,7 +54,7 @@ func (e *Engine) executeWebSearchNode(ctx context.Context, node *model.Node, nod
 			}
 			contents[i] = string(bytes)
 		case "text/html":
-			content, err := parseHTMLContent(response)
+			content, err := parseHTMLContent(response.Body)
 			if err != nil {
 				continue
 			}
@@ -69,10 +68,5 @@ func (e *Engine) executeWebSearchNode(ctx context.Context, node *model.Node, nod
 	outputMap["total"] = len(result)
 	outputMap["urls"] = urls
 	data, _ := json.Marshal(outputMap)
-	nodeInstance.Status = model.NodeInstanceStatusCompleted
-	nodeInstance.CompleteTime = time.Now()
 	nodeInstance.Output = string(data)
-	if err := e.instanceRepo.UpdateNodeInstance(ctx, nodeInstance); err != nil {
-		panic(err)
-	}
 }
@@ -2,6 +2,7 @@ package websearch
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/StellrisJAY/workflow-ai/internal/config"
@@ -78,7 +79,7 @@ func newBochaWebSearch(config *config.Config) SearchProvider {
 	return &bochaWebSearch{apiKey: config.BochaAPIKey}
 }
 
-func (b *bochaWebSearch) doSearch(query string, count int) (*bochaAnswer, error) {
+func (b *bochaWebSearch) doSearch(ctx context.Context, query string, count int) (*bochaAnswer, error) {
 	const endpoint = "https://api.bochaai.com/v1/web-search"
 	body := struct {
 		Query     string `json:"query"`
@@ -90,7 +91,7 @@ func (b *bochaWebSearch) doSearch(query string, count int) (*bochaAnswer, error)
 		Freshness: "nolimit",
 	}
 	data, _ := json.Marshal(body)
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, err
 	}
@@ -115,8 +116,8 @@ func (b *bochaWebSearch) doSearch(query string, count int) (*bochaAnswer, error)
 	return &res.Data, nil
 }
 
-func (b *bochaWebSearch) Search(query string, topN int) ([]SearchResult, error) {
-	answer, err := b.doSearch(query, topN)
+func (b *bochaWebSearch) Search(ctx context.Context, query string, topN int) ([]SearchResult, error) {
+	answer, err := b.doSearch(ctx, query, topN)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package websearch
 
 import (
+	"context"
 	"errors"
 	"github.com/StellrisJAY/workflow-ai/internal/config"
 )
@@ -12,7 +13,7 @@ type SearchResult struct {
 }
 
 type SearchProvider interface {
-	Search(query string, topN int) ([]SearchResult, error)
+	Search(ctx context.Context, query string, topN int) ([]SearchResult, error)
 }
 
 func CreateSearchProvider(provider string, config *config.Config) SearchProvider {